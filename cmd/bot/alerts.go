@@ -0,0 +1,411 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pechorka/whattobuy/moex"
+	"github.com/pechorka/whattobuy/rebalance"
+	"github.com/pechorka/whattobuy/store"
+	"github.com/pkg/errors"
+
+	tb "gopkg.in/tucnak/telebot.v2"
+)
+
+const (
+	// notificationsLimit is how many notifications one user may get during notificationsWindow,
+	// triggered rules wait for the next price update after that
+	notificationsLimit  = 5
+	notificationsWindow = time.Hour
+	// driftRepeat is how often user is reminded about the same drifted portfolio
+	driftRepeat = 24 * time.Hour
+)
+
+const alertUsage = "Ожидается формат '/alert тикер above|below цена', удалить оповещение: '/alert off номер'"
+
+// alertDirections maps words of /alert to PriceAlert.Above
+var alertDirections = map[string]bool{
+	"above": true,
+	"выше":  true,
+	"below": false,
+	"ниже":  false,
+}
+
+func (b *Bot) onAlert(m *tb.Message) {
+	fields := strings.Fields(m.Payload)
+	switch {
+	case len(fields) == 0:
+		b.listAlerts(m)
+	case len(fields) == 2 && (strings.EqualFold(fields[0], "off") || strings.EqualFold(fields[0], "удалить")):
+		b.deleteAlert(m, fields[1])
+	case len(fields) == 3:
+		b.addAlert(m, fields[0], fields[1], fields[2])
+	default:
+		b.onInvalidInput(m, errors.New(alertUsage))
+	}
+}
+
+func (b *Bot) addAlert(m *tb.Message, ticker, direction, price string) {
+	above, ok := alertDirections[strings.ToLower(direction)]
+	if !ok {
+		b.onInvalidInput(m, errors.Errorf("Направление должно быть above или below, а сейчас %s. %s", direction, alertUsage))
+		return
+	}
+	value, err := strconv.ParseFloat(strings.Replace(price, ",", ".", 1), 64)
+	if err != nil || value <= 0 {
+		b.onInvalidInput(m, errors.Errorf("Цена должна быть положительным числом, а сейчас %s", price))
+		return
+	}
+	ctx := context.TODO()
+	info, err := b.mapi.Get(ctx, moex.ParseSecurityRef(strings.ToUpper(ticker)))
+	var ambiguous *moex.AmbiguousError
+	switch {
+	case errors.As(err, &ambiguous):
+		candidates := make([]string, 0, len(ambiguous.Candidates))
+		for _, ref := range ambiguous.Candidates {
+			candidates = append(candidates, ref.String())
+		}
+		b.onInvalidInput(m, errors.Errorf("Бумага %s торгуется на нескольких площадках, укажите одну из них: %s", strings.ToUpper(ticker), strings.Join(candidates, ", ")))
+		return
+	case errors.Is(err, moex.ErrNotFound):
+		b.onInvalidInput(m, errors.Errorf("Бумага %s не найдена", strings.ToUpper(ticker)))
+		return
+	case err != nil:
+		b.onError(m, errors.Wrap(err, "error while fetching data from moex"))
+		return
+	}
+
+	alert := store.PriceAlert{SecID: info.Ref().String(), Above: above, Price: value, CreatedAt: time.Now()}
+	seq, err := b.store.AddAlert(m.Sender.ID, alert)
+	if err != nil {
+		b.onError(m, errors.Wrap(err, "error while saving alert"))
+		return
+	}
+	b.reply(m, fmt.Sprintf("Оповещение #%d: сообщу, когда %s станет %s. Сейчас %s",
+		seq, info.SecID, describeAlertPrice(alert, *info), formatQuote(alertQuote(*info), *info)))
+}
+
+func (b *Bot) deleteAlert(m *tb.Message, seqStr string) {
+	seq, err := strconv.Atoi(strings.TrimPrefix(seqStr, "#"))
+	if err != nil || seq <= 0 {
+		b.onInvalidInput(m, errors.Errorf("Номер оповещения должен быть положительным числом, а сейчас %s. Номера можно посмотреть командой /alert", seqStr))
+		return
+	}
+	err = b.store.DeleteAlerts(m.Sender.ID, seq)
+	if errors.Is(err, store.ErrAlertNotFound) {
+		b.onInvalidInput(m, errors.Errorf("Оповещения #%d нет. Номера можно посмотреть командой /alert", seq))
+		return
+	}
+	if err != nil {
+		b.onError(m, errors.Wrap(err, "error while deleting alert"))
+		return
+	}
+	b.reply(m, fmt.Sprintf("Оповещение #%d удалено", seq))
+}
+
+func (b *Bot) listAlerts(m *tb.Message) {
+	alerts, err := b.store.Alerts(m.Sender.ID)
+	if err != nil {
+		b.onError(m, errors.Wrap(err, "error while retriving alerts"))
+		return
+	}
+	if len(alerts) == 0 {
+		b.reply(m, "У вас нет оповещений о ценах. "+alertUsage)
+		return
+	}
+	refs := make([]moex.SecurityRef, 0, len(alerts))
+	for _, a := range alerts {
+		refs = append(refs, moex.ParseSecurityRef(a.SecID))
+	}
+	infos, err := b.mapi.GetMultiple(context.TODO(), refs...)
+	if err != nil {
+		b.onError(m, errors.Wrap(err, "error while retriving prices"))
+		return
+	}
+
+	var reply strings.Builder
+	reply.WriteString("Ваши оповещения о ценах:\n")
+	for i, a := range alerts {
+		info := infos[refs[i]]
+		reply.WriteString(fmt.Sprintf("#%d %s %s", a.Seq, tickerOf(a.SecID), describeAlertPrice(a, info)))
+		if price := alertQuote(info); price != 0 {
+			reply.WriteString(", сейчас " + formatQuote(price, info))
+		}
+		reply.WriteString("\n")
+	}
+	reply.WriteString("\nУдалить оповещение: /alert off номер")
+	b.reply(m, reply.String())
+}
+
+func (b *Bot) onDrift(m *tb.Message) {
+	portfolio, ok := b.activePortfolio(m)
+	if !ok {
+		return
+	}
+	payload := strings.TrimSuffix(strings.TrimSpace(m.Payload), "%")
+	if payload == "" {
+		rule, ok, err := b.store.Drift(m.Sender.ID, portfolio)
+		if err != nil {
+			b.onError(m, errors.Wrap(err, "error while retriving drift rule"))
+			return
+		}
+		if !ok {
+			b.reply(m, fmt.Sprintf("Оповещения об отклонении долей портфеля %s выключены. Включить: '/drift процент'", portfolio))
+			return
+		}
+		b.reply(m, fmt.Sprintf("Сообщу, когда доля любой бумаги портфеля %s отклонится от цели больше чем на %.2f п.п. Выключить: /drift 0", portfolio, rule.Threshold))
+		return
+	}
+	threshold, err := strconv.ParseFloat(strings.Replace(payload, ",", ".", 1), 64)
+	if err != nil || threshold < 0 || threshold >= 100 {
+		b.onInvalidInput(m, errors.Errorf("Допустимое отклонение должно быть числом от 0 до 100, а сейчас %s", m.Payload))
+		return
+	}
+	if err := b.store.SetDrift(m.Sender.ID, portfolio, threshold); err != nil {
+		b.onError(m, errors.Wrap(err, "error while saving drift rule"))
+		return
+	}
+	if threshold == 0 {
+		b.reply(m, fmt.Sprintf("Оповещения об отклонении долей портфеля %s выключены", portfolio))
+		return
+	}
+	b.reply(m, fmt.Sprintf("Сообщу, когда доля любой бумаги портфеля %s отклонится от цели больше чем на %.2f п.п. Доли считаются по бумагам, указанным командой /hold", portfolio, threshold))
+}
+
+// notify evaluates rules of all users, it is called after prices are updated
+func (b *Bot) notify() {
+	b.notifyMu.Lock()
+	defer b.notifyMu.Unlock()
+
+	rules, err := b.store.AllNotificationRules()
+	if err != nil {
+		log.Printf("[ERROR] while retriving notification rules: %v", err)
+		return
+	}
+	ctx := context.TODO()
+	now := time.Now()
+	for _, r := range rules {
+		b.notifyUser(ctx, r, now)
+	}
+}
+
+// notifyUser sends one message about all triggered rules of the user. Triggered alerts are deleted after that.
+func (b *Bot) notifyUser(ctx context.Context, r store.NotificationRules, now time.Time) {
+	var (
+		parts   []string
+		fired   []int
+		drifted []string
+	)
+	if len(r.Alerts) > 0 {
+		refs := make([]moex.SecurityRef, 0, len(r.Alerts))
+		for _, a := range r.Alerts {
+			refs = append(refs, moex.ParseSecurityRef(a.SecID))
+		}
+		infos, err := b.mapi.GetMultiple(ctx, refs...)
+		if err != nil {
+			log.Printf("[ERROR] while retriving prices for alerts of user %d: %v", r.UserID, err)
+		}
+		for i, a := range r.Alerts {
+			info, ok := infos[refs[i]]
+			if !ok {
+				continue
+			}
+			if text, ok := firedAlert(a, info); ok {
+				parts = append(parts, text)
+				fired = append(fired, a.Seq)
+			}
+		}
+	}
+
+	portfolios := make([]string, 0, len(r.Drift))
+	for portfolio := range r.Drift {
+		portfolios = append(portfolios, portfolio)
+	}
+	sort.Strings(portfolios)
+	for _, portfolio := range portfolios {
+		rule := r.Drift[portfolio]
+		if now.Sub(rule.NotifiedAt) < driftRepeat {
+			continue
+		}
+		drifts, err := b.portfolioDrift(ctx, r.UserID, portfolio)
+		if err != nil {
+			log.Printf("[ERROR] while calculating drift of portfolio %s of user %d: %v", portfolio, r.UserID, err)
+			continue
+		}
+		if exceeding := exceedingDrift(drifts, rule.Threshold); len(exceeding) > 0 {
+			parts = append(parts, describeDrift(portfolio, rule.Threshold, exceeding))
+			drifted = append(drifted, portfolio)
+		}
+	}
+
+	if len(parts) == 0 || !b.limiter.Allow(r.UserID, now) {
+		return
+	}
+	if len(fired) > 0 {
+		parts = append(parts, "Сработавшие оповещения удалены")
+	}
+	if _, err := b.telebot.Send(&tb.User{ID: r.UserID}, strings.Join(parts, "\n\n")); err != nil {
+		log.Printf("[ERROR] while notifying user %d: %v", r.UserID, err)
+		return
+	}
+	if len(fired) > 0 {
+		if err := b.store.DeleteAlerts(r.UserID, fired...); err != nil {
+			log.Printf("[ERROR] while deleting alerts of user %d: %v", r.UserID, err)
+		}
+	}
+	for _, portfolio := range drifted {
+		if err := b.store.MarkDriftNotified(r.UserID, portfolio, now); err != nil {
+			log.Printf("[ERROR] while saving drift notification of user %d: %v", r.UserID, err)
+		}
+	}
+}
+
+// positionDrift is the actual share of held position compared to its target, both in percents
+type positionDrift struct {
+	ID     string
+	Share  float64
+	Target float64
+}
+
+// portfolioDrift returns drift of held positions, it is empty when nothing is held
+func (b *Bot) portfolioDrift(ctx context.Context, userID int, portfolio string) ([]positionDrift, error) {
+	partfolio, err := b.store.GetPartfolio(userID, portfolio)
+	if err != nil {
+		return nil, err
+	}
+	holdings, err := b.store.GetHoldings(userID, portfolio)
+	if err != nil {
+		return nil, err
+	}
+	if len(partfolio) == 0 || len(holdings) == 0 {
+		return nil, nil
+	}
+	infos, err := b.loadSecurityPrices(ctx, nil, partfolio, holdings)
+	if err != nil {
+		return nil, err
+	}
+	// drift of unpriced securities is unknown, shares are computed without them
+	positions, _, _, err := b.rubPositions(ctx, partfolio, holdings, infos)
+	if err != nil {
+		return nil, err
+	}
+	return driftOf(positions), nil
+}
+
+func driftOf(positions []rebalance.Position) []positionDrift {
+	var total float64
+	for _, p := range positions {
+		total += p.Held * p.Price
+	}
+	if total == 0 {
+		return nil
+	}
+	drifts := make([]positionDrift, 0, len(positions))
+	for _, p := range positions {
+		drifts = append(drifts, positionDrift{ID: p.ID, Share: p.Held * p.Price / total * 100, Target: p.Weight})
+	}
+	return drifts
+}
+
+// exceedingDrift returns positions which drifted by more than threshold percentage points, biggest drift first
+func exceedingDrift(drifts []positionDrift, threshold float64) []positionDrift {
+	var res []positionDrift
+	for _, d := range drifts {
+		if math.Abs(d.Share-d.Target) > threshold {
+			res = append(res, d)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return math.Abs(res[i].Share-res[i].Target) > math.Abs(res[j].Share-res[j].Target)
+	})
+	return res
+}
+
+func describeDrift(portfolio string, threshold float64, drifts []positionDrift) string {
+	var s strings.Builder
+	s.WriteString(fmt.Sprintf("⚖️ Доли портфеля %s отклонились от целей больше чем на %.2f п.п.:\n", portfolio, threshold))
+	for _, d := range drifts {
+		s.WriteString(fmt.Sprintf("%s - %.2f%% при цели %.2f%%\n", tickerOf(d.ID), d.Share, d.Target))
+	}
+	s.WriteString("Вернуть доли к целям поможет /rebalance")
+	return s.String()
+}
+
+// firedAlert describes the alert if it is triggered by the live quote of the security.
+// Configured price source is not used, previous close would fire alerts a day late.
+func firedAlert(a store.PriceAlert, info moex.StockInfo) (string, bool) {
+	q := info.Live
+	if q.Price == 0 || !a.Triggered(q.Price) {
+		return "", false
+	}
+	text := fmt.Sprintf("🔔 #%d %s стала %s: %s %s", a.Seq, tickerOf(a.SecID), describeAlertPrice(a, info), priceSourceNames[q.Source], formatQuote(q.Price, info))
+	if !q.UpdatedAt.IsZero() {
+		text += " в " + q.UpdatedAt.In(moex.Moscow).Format("15:04")
+	}
+	return text, true
+}
+
+// alertQuote is the price alerts are checked against, price of configured source is used when there is no live quote
+func alertQuote(info moex.StockInfo) float64 {
+	if info.Live.Price != 0 {
+		return info.Live.Price
+	}
+	return info.Price
+}
+
+func describeAlertPrice(a store.PriceAlert, info moex.StockInfo) string {
+	direction := "ниже"
+	if a.Above {
+		direction = "выше"
+	}
+	return direction + " " + formatQuote(a.Price, info)
+}
+
+// formatQuote shows price in units of the quote: bonds are quoted in percents of face value
+func formatQuote(price float64, info moex.StockInfo) string {
+	if info.IsBond() {
+		return fmt.Sprintf("%.2f%% номинала", price)
+	}
+	if info.Currency == "" {
+		return fmt.Sprintf("%.2f", price)
+	}
+	return fmt.Sprintf("%.2f %s", price, info.Currency)
+}
+
+// rateLimiter allows at most limit events of one user during window
+type rateLimiter struct {
+	limit  int
+	window time.Duration
+
+	mu     sync.Mutex
+	events map[int][]time.Time
+}
+
+func newRateLimiter(limit int, window time.Duration) *rateLimiter {
+	return &rateLimiter{limit: limit, window: window, events: make(map[int][]time.Time)}
+}
+
+// Allow reports whether user may get one more event at now and counts it if so
+func (l *rateLimiter) Allow(userID int, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	recent := l.events[userID][:0]
+	for _, t := range l.events[userID] {
+		if now.Sub(t) < l.window {
+			recent = append(recent, t)
+		}
+	}
+	if len(recent) >= l.limit {
+		l.events[userID] = recent
+		return false
+	}
+	l.events[userID] = append(recent, now)
+	return true
+}
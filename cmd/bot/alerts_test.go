@@ -0,0 +1,94 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/pechorka/whattobuy/moex"
+	"github.com/pechorka/whattobuy/rebalance"
+	"github.com/pechorka/whattobuy/store"
+)
+
+func TestRateLimiter(t *testing.T) {
+	l := newRateLimiter(2, time.Hour)
+	now := time.Date(2021, 11, 3, 12, 0, 0, 0, moex.Moscow)
+	if !l.Allow(1, now) || !l.Allow(1, now.Add(time.Minute)) {
+		t.Fatal("expected first two events to be allowed")
+	}
+	if l.Allow(1, now.Add(2*time.Minute)) {
+		t.Error("expected third event within an hour to be denied")
+	}
+	if !l.Allow(2, now) {
+		t.Error("expected limit to be per user")
+	}
+	if !l.Allow(1, now.Add(time.Hour)) {
+		t.Error("expected event to be allowed after the first one left the window")
+	}
+	if l.Allow(1, now.Add(time.Hour+time.Second)) {
+		t.Error("expected denied events not to free the limit")
+	}
+}
+
+func TestExceedingDrift(t *testing.T) {
+	positions := []rebalance.Position{
+		{ID: "TQBR:AFKS", Weight: 50, Price: 10, Held: 60},
+		{ID: "TQBR:SBER", Weight: 30, Price: 100, Held: 3},
+		{ID: "TQTF:FXGD", Weight: 20, Price: 1, Held: 100},
+	}
+	drifts := driftOf(positions)
+	got := exceedingDrift(drifts, 5)
+	want := []positionDrift{
+		{ID: "TQBR:AFKS", Share: 60, Target: 50},
+		{ID: "TQTF:FXGD", Share: 10, Target: 20},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("expected %+v, got %+v", want, got)
+	}
+	for i := range want {
+		if got[i].ID != want[i].ID || math.Abs(got[i].Share-want[i].Share) > 1e-9 || got[i].Target != want[i].Target {
+			t.Errorf("expected %+v, got %+v", want[i], got[i])
+		}
+	}
+	if len(exceedingDrift(drifts, 10)) != 0 {
+		t.Error("expected drift of exactly threshold not to be exceeding")
+	}
+}
+
+func TestFiredAlert(t *testing.T) {
+	above := store.PriceAlert{Seq: 1, SecID: "TQBR:SBER", Above: true, Price: 300}
+	// price of previous close is below the alert, it must not be used
+	info := moex.StockInfo{Currency: moex.CurrencyRUB, Price: 290, PriceSource: moex.PriceSourcePrevClose}
+
+	tt := []struct {
+		name  string
+		live  moex.Quote
+		fired bool
+		text  string
+	}{
+		{name: "no live quote", fired: false},
+		{name: "last is below", live: moex.Quote{Price: 299, Source: moex.PriceSourceLast}, fired: false},
+		{
+			name:  "last crossed",
+			live:  moex.Quote{Price: 301.5, Source: moex.PriceSourceLast, UpdatedAt: time.Date(2021, 11, 3, 14, 5, 0, 0, moex.Moscow)},
+			fired: true,
+			text:  "🔔 #1 SBER стала выше 300.00 RUB: цена последней сделки 301.50 RUB в 14:05",
+		},
+		{
+			name:  "no trades yet",
+			live:  moex.Quote{Price: 300, Source: moex.PriceSourceMarket},
+			fired: true,
+			text:  "🔔 #1 SBER стала выше 300.00 RUB: рыночная цена 300.00 RUB",
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			info := info
+			info.Live = tc.live
+			text, fired := firedAlert(above, info)
+			if fired != tc.fired || text != tc.text {
+				t.Errorf("expected %v %q, got %v %q", tc.fired, tc.text, fired, text)
+			}
+		})
+	}
+}
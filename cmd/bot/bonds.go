@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pechorka/whattobuy/moex"
+	"github.com/pechorka/whattobuy/store"
+)
+
+// bondsSummary is analytics of the bond part of portfolio
+type bondsSummary struct {
+	// Percent is the share of bonds in portfolio
+	Percent float64
+	// Yield and Duration are averages weighted by target shares of bonds, duration is in days.
+	// Bonds without trades have no yield and are not counted, as well as bonds without duration in Duration.
+	Yield    float64
+	Duration float64
+}
+
+// summarizeBonds returns false if there are no bonds in portfolio
+func summarizeBonds(partfolio store.Partfolio, infos map[string]moex.StockInfo) (bondsSummary, bool) {
+	var (
+		s                   bondsSummary
+		counted, durCounted float64
+	)
+	for secid, percent := range partfolio {
+		info, ok := infos[secid]
+		if !ok || info.Bond == nil {
+			continue
+		}
+		s.Percent += percent
+		if info.Bond.Yield == 0 {
+			continue
+		}
+		counted += percent
+		s.Yield += info.Bond.Yield * percent
+		if info.Bond.Duration != 0 {
+			durCounted += percent
+			s.Duration += info.Bond.Duration * percent
+		}
+	}
+	if s.Percent == 0 {
+		return bondsSummary{}, false
+	}
+	if counted > 0 {
+		s.Yield /= counted
+	}
+	if durCounted > 0 {
+		s.Duration /= durCounted
+	}
+	return s, true
+}
+
+func describeBond(b *moex.BondInfo, faceUnit string) string {
+	var parts []string
+	if b.Yield != 0 {
+		yield := fmt.Sprintf("доходность %.2f%%", b.Yield)
+		if !b.OfferDate.IsZero() {
+			yield += " к оферте"
+		}
+		parts = append(parts, yield+measuredAt(b.YieldAt))
+	}
+	if b.Duration != 0 {
+		parts = append(parts, fmt.Sprintf("дюрация %s", formatDuration(b.Duration))+measuredAt(b.DurationAt))
+	}
+	if !b.MatDate.IsZero() {
+		parts = append(parts, "погашение "+b.MatDate.Format("02.01.2006"))
+	}
+	if !b.OfferDate.IsZero() {
+		parts = append(parts, "оферта "+b.OfferDate.Format("02.01.2006"))
+	}
+	if b.CouponValue != 0 {
+		coupon := fmt.Sprintf("купон %.2f%% (%.2f %s", b.CouponPercent, b.CouponValue, faceUnit)
+		if b.CouponPeriod > 0 {
+			coupon += fmt.Sprintf(" раз в %d дн.", b.CouponPeriod)
+		}
+		coupon += ")"
+		if !b.NextCoupon.IsZero() {
+			coupon += ", следующий " + b.NextCoupon.Format("02.01.2006")
+		}
+		parts = append(parts, coupon)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// measuredAt labels analytics with the date they were published at, yield and duration may be of different days
+func measuredAt(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return " на " + t.In(moex.Moscow).Format("02.01.2006")
+}
+
+// formatDuration shows duration in days as years
+func formatDuration(days float64) string {
+	return fmt.Sprintf("%.1f г.", days/365)
+}
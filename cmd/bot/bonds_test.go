@@ -0,0 +1,57 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/pechorka/whattobuy/moex"
+	"github.com/pechorka/whattobuy/store"
+)
+
+func TestSummarizeBonds(t *testing.T) {
+	infos := map[string]moex.StockInfo{
+		"TQBR:AFKS":         {},
+		"TQOB:SU26207RMFS9": {Bond: &moex.BondInfo{Yield: 8, Duration: 1500}},
+		"TQOB:SU26238RMFS4": {Bond: &moex.BondInfo{Yield: 9, Duration: 3000}},
+		// not traded yet, only its share is counted
+		"TQCB:RU000A1038V6": {Bond: &moex.BondInfo{}},
+	}
+	partfolio := store.Partfolio{"TQBR:AFKS": 50, "TQOB:SU26207RMFS9": 30, "TQOB:SU26238RMFS4": 10, "TQCB:RU000A1038V6": 10}
+
+	s, ok := summarizeBonds(partfolio, infos)
+	if !ok {
+		t.Fatal("expected bonds to be found")
+	}
+	if s.Percent != 50 {
+		t.Errorf("expected bonds to be 50%% of portfolio, got %f", s.Percent)
+	}
+	if math.Abs(s.Yield-8.25) > 1e-9 || math.Abs(s.Duration-1875) > 1e-9 {
+		t.Errorf("expected yield 8.25 and duration 1875, got %f and %f", s.Yield, s.Duration)
+	}
+
+	// duration is not known at previous close, yield is still averaged
+	infos["TQCB:RU000A1038V6"] = moex.StockInfo{Bond: &moex.BondInfo{Yield: 10}}
+	s, _ = summarizeBonds(partfolio, infos)
+	if math.Abs(s.Yield-8.6) > 1e-9 || math.Abs(s.Duration-1875) > 1e-9 {
+		t.Errorf("expected yield 8.6 and duration 1875, got %f and %f", s.Yield, s.Duration)
+	}
+
+	if _, ok := summarizeBonds(store.Partfolio{"TQBR:AFKS": 100}, infos); ok {
+		t.Error("expected no bonds in portfolio of shares")
+	}
+}
+
+func TestDescribeBond(t *testing.T) {
+	b := &moex.BondInfo{
+		Yield:      7.91,
+		YieldAt:    time.Date(2021, 11, 2, 0, 0, 0, 0, moex.Moscow),
+		Duration:   1573,
+		DurationAt: time.Date(2021, 11, 3, 18, 39, 58, 0, moex.Moscow),
+		MatDate:    time.Date(2027, 2, 3, 0, 0, 0, 0, moex.Moscow),
+	}
+	want := "доходность 7.91% на 02.11.2021, дюрация 4.3 г. на 03.11.2021, погашение 03.02.2027"
+	if got := describeBond(b, moex.CurrencyRUB); got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
@@ -4,11 +4,14 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/pechorka/whattobuy/moex"
+	"github.com/pechorka/whattobuy/rebalance"
 	"github.com/pechorka/whattobuy/store"
 	"github.com/pkg/errors"
 
@@ -19,6 +22,15 @@ type Bot struct {
 	telebot *tb.Bot
 	store   *store.Store
 	mapi    *moex.API
+
+	// notifyMu makes notifications of concurrent price updates wait for each other
+	notifyMu sync.Mutex
+	limiter  *rateLimiter
+
+	// ctx is canceled on Stop, schedulerDone is closed when scheduler exits
+	ctx           context.Context
+	cancel        context.CancelFunc
+	schedulerDone chan struct{}
 }
 
 type Opts struct {
@@ -56,12 +68,17 @@ func NewBot(opts *Opts) (*Bot, error) {
 	if err != nil {
 		return nil, err
 	}
+	ctx, cancel := context.WithCancel(context.Background())
 	b := &Bot{
+		ctx:     ctx,
+		cancel:  cancel,
 		telebot: telebot,
 		store:   opts.Store,
 		mapi:    opts.MoexAPI,
+		limiter: newRateLimiter(notificationsLimit, notificationsWindow),
 	}
 	b.handle()
+	b.mapi.OnUpdate(b.notify)
 	return b, nil
 }
 
@@ -69,8 +86,13 @@ func (b *Bot) Start() {
 	b.telebot.Start()
 }
 
+// Stop stops receiving updates and waits for scheduler if it was started
 func (b *Bot) Stop() {
 	b.telebot.Stop()
+	b.cancel()
+	if b.schedulerDone != nil {
+		<-b.schedulerDone
+	}
 }
 
 func (b *Bot) handle() {
@@ -80,18 +102,46 @@ func (b *Bot) handle() {
 	b.telebot.Handle("/buy", b.onBuy)
 	b.telebot.Handle("/finish", b.onFinish)
 	b.telebot.Handle("/restart", b.onRestart)
+	b.telebot.Handle("/hold", b.onHold)
+	b.telebot.Handle("/rebalance", b.onRebalance)
+	b.telebot.Handle("/new", b.onNewPortfolio)
+	b.telebot.Handle("/switch", b.onSwitchPortfolio)
+	b.telebot.Handle("/list", b.onListPortfolios)
+	b.telebot.Handle("/delete", b.onDeletePortfolio)
+	b.telebot.Handle("/history", b.onHistory)
+	b.telebot.Handle("/undo", b.onUndo)
+	b.telebot.Handle("/performance", b.onPerformance)
+	b.telebot.Handle("/calendar", b.onCalendar)
+	b.telebot.Handle("/cashflow", b.onCashflow)
+	b.telebot.Handle("/alert", b.onAlert)
+	b.telebot.Handle("/drift", b.onDrift)
+	b.telebot.Handle("/schedule", b.onSchedule)
+	b.telebot.Handle("/export", b.onExport)
+	b.telebot.Handle(tb.OnDocument, b.onImport)
+	b.telebot.Handle(&btnPickTarget, b.onPickTarget)
+	b.telebot.Handle(&btnPickHolding, b.onPickHolding)
+	b.telebot.Handle(&btnConfirmBuy, b.onConfirmBuy)
+	b.telebot.Handle(&btnPickBoard, b.onPickBoard)
 }
 
 func (b *Bot) onStart(m *tb.Message) {
-	if b.isUserFinished(m) {
+	portfolio, ok := b.activePortfolio(m)
+	if !ok {
+		return
+	}
+	if b.isUserFinished(m, portfolio) {
 		b.reply(m, "У вас уже заполнен портфель. Для ввода портфеля заново воспользуйтесь командой /restart")
 		return
 	}
-	b.reply(m, "Начните вводить желаемую структуру вашего портфеля сообщениями вида: 'тикер процент'.  Например, FXMM 30 или RU000A0JS1W0 10. В одном сообщении может быть несколько позиций - каждая на новой строчке. Когда закончите ввод, введите /finish. Проценты должны суммироваться в 100. Если где-то ошиблись, то введите эту позицию заново - процент заменится. Для удаления позиции обнулите её. Для глобальных изменнкний есть команда /restart :)")
+	b.reply(m, "Начните вводить желаемую структуру вашего портфеля сообщениями вида: 'тикер процент'.  Например, FXMM 30 или RU000A0JS1W0 10. В одном сообщении может быть несколько позиций - каждая на новой строчке. Когда закончите ввод, введите /finish. Проценты должны суммироваться в 100. Если где-то ошиблись, то введите эту позицию заново - процент заменится. Для удаления позиции обнулите её. Для глобальных изменнкний есть команда /restart :) Если у вас несколько счетов, заведите для каждого свой портфель командой /new")
 }
 
 func (b *Bot) onText(m *tb.Message) {
-	if b.isUserFinished(m) {
+	portfolio, ok := b.activePortfolio(m)
+	if !ok {
+		return
+	}
+	if b.isUserFinished(m, portfolio) {
 		b.reply(m, "У вас уже заполнен портфель. Для ввода портфеля заново воспользуйтесь командой /restart")
 		return
 	}
@@ -111,87 +161,58 @@ func (b *Bot) onText(m *tb.Message) {
 		return input[0], percent, nil
 	}
 
-	var (
-		userInput  = make(map[string]float64)
-		sumPercent float64
-		notFound   []string
-	)
+	var input []targetInput
 	for _, s := range strings.Split(m.Text, "\n") {
 		secid, percent, err := readInput(s)
 		if err != nil {
 			b.onInvalidInput(m, err)
 			return
 		}
-		secid = strings.ToUpper(secid)
-		_, err = b.mapi.Get(context.TODO(), secid)
-		if err == moex.ErrNotFound {
-			_, err = b.mapi.Get(context.TODO(), secid+"-RM")
-			if err == nil {
-				secid += "-RM"
-			}
-		}
-		if err != nil {
-			log.Printf("[ERROR] while fetching data from moex: %v\n", err)
-			notFound = append(notFound, secid)
-			continue
-		}
-		userInput[secid] = percent
-		sumPercent += percent
+		input = append(input, targetInput{ticker: secid, percent: percent})
 	}
 
-	partfolio, err := b.store.GetPartfolio(m.Sender.ID)
-	if err != nil {
-		b.onError(m, errors.Wrap(err, "error while retriving portfolio"))
+	u, ok := b.prepareTargets(context.TODO(), m, portfolio, input, false)
+	if !ok {
 		return
 	}
-
-	var sp float64
-	for secid, p := range partfolio {
-		if _, ok := userInput[secid]; ok { // we replace current value, no need to count it
-			continue
+	if len(u.changes) > 0 {
+		if err := b.store.AddToPartfolio(m.Sender.ID, portfolio, u.changes); err != nil {
+			b.onError(m, errors.Wrap(err, "error while upadating portfolio"))
+			return
 		}
-		sp += p
-	}
-
-	if sp+sumPercent > 100 {
-		b.onInvalidInput(m, errors.Errorf("Нельзя добавить такой процент, будет больше 100. Доступно для ввода %.2f, а сейчас есть %.2f", 100-sp, sp))
-		return
 	}
-
-	if err = b.store.AddToPartfolio(m.Sender.ID, userInput); err != nil {
-		b.onError(m, errors.Wrap(err, "error while upadating portfolio"))
-		return
-	}
-	if len(notFound) > 0 {
-		found := make([]string, 0, len(userInput))
-		for secid := range userInput {
-			found = append(found, secid)
+	if len(u.notFound) > 0 {
+		if len(u.changes) > 0 {
+			found := make([]string, 0, len(u.changes))
+			for secid := range u.changes {
+				found = append(found, tickerOf(secid))
+			}
+			sort.Strings(found)
+			b.reply(m, "Были добавлены бумаги:\n"+strings.Join(found, "\n"))
 		}
-		var reply string
-		if len(found) > 0 {
-			reply = "Были добавлены бумаги:\n" + strings.Join(found, "\n") + "\n"
+		for _, in := range u.notFound {
+			b.suggest(m, in.ticker, in.percent, in.err, btnPickTarget)
 		}
-		reply += "Часть бумаг была не найдена:\n" + strings.Join(notFound, "\n")
-		b.reply(m, reply)
 		return
 	}
 	b.reply(m, "Успешно изменено")
 
-	if sp+sumPercent == 100 {
+	if u.total() == 100 {
 		var reply strings.Builder
 		reply.WriteString("Сумма долей достигла 100%. Хотите завершить ввод портфеля - нажмите /finish. Портфель на данный момент выглядит так:\n")
-		for secid, percent := range partfolio {
-			reply.WriteString(fmt.Sprintf("%s - %.2f%%\n", noRM(secid), percent))
-		}
-		for secid, percent := range userInput {
-			reply.WriteString(fmt.Sprintf("%s - %.2f%%\n", noRM(secid), percent))
+		for _, secid := range secids(u.result) {
+			reply.WriteString(fmt.Sprintf("%s - %.2f%%\n", tickerOf(secid), u.result[secid]))
 		}
 		b.reply(m, reply.String())
 	}
 }
 
 func (b *Bot) onFinish(m *tb.Message) {
-	partfolio, err := b.store.GetPartfolio(m.Sender.ID)
+	portfolio, ok := b.activePortfolio(m)
+	if !ok {
+		return
+	}
+	partfolio, err := b.store.GetPartfolio(m.Sender.ID, portfolio)
 	if err != nil {
 		b.onError(m, errors.Wrap(err, "error while retriving portfolio"))
 		return
@@ -204,39 +225,59 @@ func (b *Bot) onFinish(m *tb.Message) {
 		b.onInvalidInput(m, errors.Errorf("В вашем портфель доли складываются не в 100%%, а в %.2f%%", sp))
 		return
 	}
-	if err := b.store.Finish(m.Sender.ID); err != nil {
+	if err := b.store.Finish(m.Sender.ID, portfolio); err != nil {
 		b.onError(m, errors.Wrap(err, "error while finishing user portfolio"))
 		return
 	}
 	b.reply(m, `Ваш портфель успешно сохранен.
 Для просмотра его содержимого введите команду /view.
-Для того чтобы узнать, что купить на заданную сумму, введите '/buy сумма'`)
+Для того чтобы узнать, что купить на заданную сумму, введите '/buy сумма'.
+Чтобы покупки учитывали уже имеющиеся бумаги, укажите их командой '/hold тикер количество'`)
 }
 
 func (b *Bot) onRestart(m *tb.Message) {
-	partfolio, err := b.store.GetPartfolio(m.Sender.ID)
+	portfolio, ok := b.activePortfolio(m)
+	if !ok {
+		return
+	}
+	partfolio, err := b.store.GetPartfolio(m.Sender.ID, portfolio)
 	if err != nil {
 		b.onError(m, errors.Wrap(err, "error while retriving portfolio"))
 		return
 	}
-	if err := b.store.ClearData(m.Sender.ID); err != nil {
+	if err := b.store.ClearData(m.Sender.ID, portfolio); err != nil {
 		b.onError(m, errors.Wrap(err, "error while deleting portfolio"))
 		return
 	}
 	var reply strings.Builder
-	reply.WriteString("Ваш портфель удалён. На случай, если вы сделали это случайно, скопируйте это сообщение:\n")
+	reply.WriteString("Ваш портфель удалён. Если вы сделали это случайно, верните его командой /undo. Удалённый портфель:\n")
 	for secid, percent := range partfolio {
-		reply.WriteString(fmt.Sprintf("%s %.2f\n", noRM(secid), percent))
+		reply.WriteString(fmt.Sprintf("%s %.2f\n", tickerOf(secid), percent))
 	}
 	b.reply(m, reply.String())
 }
 
-func noRM(secid string) string {
-	return strings.TrimSuffix(secid, "-RM")
+// findSecurity returns ref to the security user typed. Ticker may be SECID, ISIN, registration number or "BOARD:SECID",
+// *moex.AmbiguousError is returned when security is traded on several boards.
+func (b *Bot) findSecurity(ctx context.Context, ticker string) (moex.SecurityRef, error) {
+	info, err := b.mapi.Get(ctx, moex.ParseSecurityRef(strings.ToUpper(ticker)))
+	if err != nil {
+		return moex.SecurityRef{}, err
+	}
+	return info.Ref(), nil
+}
+
+// tickerOf returns SECID of security stored under the key, board is not shown to user
+func tickerOf(key string) string {
+	return moex.ParseSecurityRef(key).SecID
 }
 
 func (b *Bot) onView(m *tb.Message) {
-	partfolio, err := b.store.GetPartfolio(m.Sender.ID)
+	portfolio, ok := b.activePortfolio(m)
+	if !ok {
+		return
+	}
+	partfolio, err := b.store.GetPartfolio(m.Sender.ID, portfolio)
 	if err != nil {
 		b.onError(m, errors.Wrap(err, "error while retriving portfolio"))
 		return
@@ -255,13 +296,28 @@ func (b *Bot) onView(m *tb.Message) {
 	var reply strings.Builder
 	reply.WriteString("содержимое вашего портфеля\n")
 	for secid, percent := range partfolio {
-		reply.WriteString(fmt.Sprintf("(%.2f%%) %s - %q\n", percent, noRM(secid), infos[secid].ShortName))
+		info := infos[secid]
+		reply.WriteString(fmt.Sprintf("(%.2f%%) %s - %q\n", percent, tickerOf(secid), info.ShortName))
+		if info.Bond != nil {
+			reply.WriteString("    " + describeBond(info.Bond, info.FaceUnit) + "\n")
+		}
+	}
+	if s, ok := summarizeBonds(partfolio, infos); ok && s.Yield != 0 {
+		summary := fmt.Sprintf("\nОблигации (%.2f%% портфеля): средневзвешенная доходность %.2f%%", s.Percent, s.Yield)
+		if s.Duration != 0 {
+			summary += ", дюрация " + formatDuration(s.Duration)
+		}
+		reply.WriteString(summary + "\n")
 	}
 	b.reply(m, reply.String())
 }
 
 func (b *Bot) onBuy(m *tb.Message) {
-	if !b.isUserFinished(m) {
+	portfolio, ok := b.activePortfolio(m)
+	if !ok {
+		return
+	}
+	if !b.isUserFinished(m, portfolio) {
 		b.reply(m, "У вас еще не заполнен портфель или вы не ввели команду /finish")
 		return
 	}
@@ -270,49 +326,238 @@ func (b *Bot) onBuy(m *tb.Message) {
 		b.onInvalidInput(m, errors.Wrapf(err, "Сумма на покупку не число, а %s\n", m.Payload))
 		return
 	}
-	partfolio, err := b.store.GetPartfolio(m.Sender.ID)
+	text, _, err := b.buyBreakdown(context.TODO(), m, m.Sender.ID, portfolio, capital)
 	if err != nil {
-		b.onError(m, errors.Wrap(err, "error while retriving portfolio"))
+		b.onError(m, err)
 		return
 	}
-	infos, err := b.loadSecurityPrices(context.TODO(), m, partfolio)
+	b.reply(m, text)
+}
+
+// buyBreakdown tells how to spend capital on the portfolio with current prices.
+// Suggested purchase is returned along with the text, so that it can be recorded to holdings later.
+// M is the message of user who asked for it, nil for scheduled purchases.
+func (b *Bot) buyBreakdown(ctx context.Context, m *tb.Message, userID int, portfolio string, capital float64) (string, store.Purchase, error) {
+	partfolio, err := b.store.GetPartfolio(userID, portfolio)
 	if err != nil {
-		b.onError(m, errors.Wrap(err, "error while retriving prices"))
-		return
+		return "", store.Purchase{}, errors.Wrap(err, "error while retriving portfolio")
 	}
+	holdings, err := b.store.GetHoldings(userID, portfolio)
+	if err != nil {
+		return "", store.Purchase{}, errors.Wrap(err, "error while retriving holdings")
+	}
+	infos, err := b.loadSecurityPrices(ctx, m, partfolio, holdings)
+	if err != nil {
+		return "", store.Purchase{}, errors.Wrap(err, "error while retriving prices")
+	}
+	positions, rates, missing, err := b.rubPositions(ctx, partfolio, holdings, infos)
+	if err != nil {
+		return "", store.Purchase{}, err
+	}
+	// share of targets without price is not spent on other securities, it is left over
 	var (
-		reply      strings.Builder
-		totalSpend float64
+		reservedWeight float64
+		reservedFor    []string
 	)
-	for secid, percent := range partfolio {
-		info := infos[secid]
-		sum := capital * percent / 100
-		lots := sum / info.Price
-		if lots < info.LotSize {
-			if lots == 0 {
-				reply.WriteString(fmt.Sprintf("💩 %s - %.2f%% суммы недостаточно, чтобы купить 1 ценную бумагу. Она стоит %.2f, что меньше %.2f. \n", secid, percent, info.Price, sum))
-				continue
+	for _, secid := range secids(partfolio) {
+		if _, ok := infos[secid]; !ok {
+			reservedWeight += partfolio[secid]
+			reservedFor = append(reservedFor, tickerOf(secid))
+		}
+	}
+	reserve := capital * reservedWeight / 100
+	if reservedWeight > 0 && reservedWeight < 100 {
+		for i := range positions {
+			positions[i].Weight *= 100 / (100 - reservedWeight)
+		}
+	}
+	alloc := rebalance.Allocate(positions, capital-reserve)
+	alloc.Left += reserve
+
+	var totalAfter float64
+	for _, p := range positions {
+		totalAfter += (p.Held + float64(alloc.Lots[p.ID])*p.LotSize) * p.Price
+	}
+
+	var (
+		reply    strings.Builder
+		purchase = store.Purchase{Quantities: make(map[string]float64), Prices: make(map[string]float64)}
+	)
+	if len(holdings) > 0 {
+		reply.WriteString("С учётом уже купленных бумаг в первую очередь докупаются те, доля которых меньше целевой\n")
+	}
+	for _, p := range positions {
+		if p.Weight == 0 { // held, but not a part of target portfolio
+			continue
+		}
+		info := infos[p.ID]
+		lots := alloc.Lots[p.ID]
+		weight := partfolio[p.ID]
+		if lots == 0 {
+			reply.WriteString(fmt.Sprintf("💩 %s - %.2f%% не нужно или не на что докупать. Лот стоит %.2f рублей (в одном лоте %.0f ценных бумаг)", tickerOf(p.ID), weight, p.Price*p.LotSize, p.LotSize))
+		} else {
+			spendMoney := float64(lots) * p.Price * p.LotSize
+			purchase.Quantities[p.ID] = float64(lots) * p.LotSize
+			purchase.Prices[p.ID] = p.Price
+			if info.Currency != moex.CurrencyRUB {
+				reply.WriteString(fmt.Sprintf("%s - %d лотов (на %.2f рублей, это %.2f %s)", tickerOf(p.ID), lots, spendMoney, spendMoney/rates[info.Currency], info.Currency))
 			} else {
-				reply.WriteString(fmt.Sprintf("💩 %s - %.2f%% суммы недостаточно, чтобы купить 1 лот (можно купить %.0f ценных бумаг, а в одном лоте %.0f ценных бумаг)\n", secid, percent, lots, info.LotSize))
-				continue
+				reply.WriteString(fmt.Sprintf("%s - %d лотов (на %.2f рублей)", tickerOf(p.ID), lots, spendMoney))
 			}
 		}
-		lots /= info.LotSize
-		lots = float64(int(lots))
-		spendMoney := lots * info.Price * info.LotSize
-		reply.WriteString(fmt.Sprintf("%s - %.0f лотов (на %.2f у.е.)\n", secid, lots, spendMoney))
-		totalSpend += spendMoney
+		if len(holdings) > 0 && totalAfter > 0 {
+			after := (p.Held + float64(lots)*p.LotSize) * p.Price / totalAfter * 100
+			reply.WriteString(fmt.Sprintf(", доля станет %.2f%% при цели %.2f%%", after, weight))
+		}
+		reply.WriteString("\n")
 	}
-	reply.WriteString(fmt.Sprintf("\n🥳Итого на покупку уйдет %.2f рублей", totalSpend))
-	b.reply(m, reply.String())
+	reply.WriteString(fmt.Sprintf("\n🥳Итого на покупку уйдет %.2f рублей, останется %.2f рублей", alloc.Spent, alloc.Left))
+	if reserve > 0 {
+		reply.WriteString(fmt.Sprintf("\nИз них %.2f рублей (%.2f%%) отложено на бумаги без цены: %s", reserve, reservedWeight, strings.Join(reservedFor, ", ")))
+	}
+	for currency, rate := range rates {
+		reply.WriteString(fmt.Sprintf("\nКурс %s: %.4f рублей", currency, rate))
+	}
+	reply.WriteString("\n" + describeMissing(missing) + describePrices(infos))
+	return reply.String(), purchase, nil
+}
+
+// rubPositions converts portfolio and holdings into positions sorted by secid with prices in rubles.
+// Exchange rates of used foreign currencies are returned too.
+// Securities without price are skipped, their tickers are returned as missing.
+func (b *Bot) rubPositions(ctx context.Context, partfolio store.Partfolio, holdings store.Holdings, infos map[string]moex.StockInfo) (positions []rebalance.Position, rates map[string]float64, missing []string, err error) {
+	positions = make([]rebalance.Position, 0, len(partfolio))
+	rates = make(map[string]float64)
+	for _, secid := range secids(partfolio, holdings) {
+		info, ok := infos[secid]
+		if !ok {
+			missing = append(missing, tickerOf(secid))
+			continue
+		}
+		rate, err := b.mapi.Rate(ctx, info.Currency)
+		if err != nil {
+			return nil, nil, nil, errors.Wrapf(err, "error while converting %s price to rubles", secid)
+		}
+		if info.Currency != moex.CurrencyRUB {
+			rates[info.Currency] = rate
+		}
+		positions = append(positions, rebalance.Position{
+			ID:      secid,
+			Weight:  partfolio[secid],
+			Price:   info.DirtyPrice() * rate,
+			LotSize: info.LotSize,
+			Held:    holdings[secid],
+		})
+	}
+	return positions, rates, missing, nil
 }
 
-func (b *Bot) loadSecurityPrices(ctx context.Context, m *tb.Message, partfolio store.Partfolio) (map[string]moex.StockInfo, error) {
-	secids := make([]string, 0, len(partfolio))
-	for secid := range partfolio {
-		secids = append(secids, secid)
+// describeMissing tells which securities are not taken into account because they have no price
+func describeMissing(missing []string) string {
+	if len(missing) == 0 {
+		return ""
 	}
-	return b.mapi.GetMultiple(ctx, secids...)
+	return fmt.Sprintf("Нет цен бумаг %s, они не учтены\n", strings.Join(missing, ", "))
+}
+
+var priceSourceNames = map[moex.PriceSource]string{
+	moex.PriceSourcePrevClose: "цена закрытия",
+	moex.PriceSourceLast:      "цена последней сделки",
+	moex.PriceSourceWAPrice:   "средневзвешенная цена",
+	moex.PriceSourceMid:       "середина между спросом и предложением",
+	moex.PriceSourceMarket:    "рыночная цена",
+}
+
+// describePrices tells which price sources were used and how old the oldest price of each source is
+func describePrices(infos map[string]moex.StockInfo) string {
+	oldest := make(map[moex.PriceSource]time.Time)
+	for _, info := range infos {
+		t, ok := oldest[info.PriceSource]
+		if !ok || info.UpdatedAt.Before(t) {
+			oldest[info.PriceSource] = info.UpdatedAt
+		}
+	}
+	sources := make([]string, 0, len(oldest))
+	for source, updatedAt := range oldest {
+		name, ok := priceSourceNames[source]
+		if !ok {
+			name = "неизвестный источник"
+		}
+		switch {
+		case updatedAt.IsZero():
+		case source == moex.PriceSourcePrevClose:
+			name += " за " + updatedAt.Format("02.01.2006")
+		default:
+			name += " (" + formatAge(time.Since(updatedAt)) + " назад)"
+		}
+		sources = append(sources, name)
+	}
+	sort.Strings(sources)
+	return "Цены: " + strings.Join(sources, ", ")
+}
+
+func formatAge(d time.Duration) string {
+	switch {
+	case d < time.Minute:
+		return "меньше минуты"
+	case d < time.Hour:
+		return fmt.Sprintf("%d мин.", int(d.Minutes()))
+	case d < 24*time.Hour:
+		return fmt.Sprintf("%d ч.", int(d.Hours()))
+	default:
+		return fmt.Sprintf("%d дн.", int(d.Hours()/24))
+	}
+}
+
+// loadSecurityPrices returns prices of securities keyed like in store, store is not changed.
+// When m is set, user is asked to pick the board of bare secids stored before boards were known
+// which became ambiguous. Securities without price are left out.
+func (b *Bot) loadSecurityPrices(ctx context.Context, m *tb.Message, partfolio store.Partfolio, holdings ...store.Holdings) (map[string]moex.StockInfo, error) {
+	sets := []map[string]float64{partfolio}
+	for _, h := range holdings {
+		sets = append(sets, h)
+	}
+	keys := secids(sets...)
+	refs := make([]moex.SecurityRef, 0, len(keys))
+	for _, key := range keys {
+		refs = append(refs, moex.ParseSecurityRef(key))
+	}
+	found, err := b.mapi.GetMultiple(ctx, refs...)
+	if err != nil {
+		return nil, err
+	}
+	// prices are keyed like in store, bare secids of old data are found on any board while they are unambiguous
+	infos := make(map[string]moex.StockInfo, len(found))
+	for i, key := range keys {
+		info, ok := found[refs[i]]
+		if ok {
+			infos[key] = info
+		}
+		if ok || refs[i].IsExact() || m == nil {
+			continue
+		}
+		var ambiguous *moex.AmbiguousError
+		if _, err := b.mapi.Get(ctx, refs[i]); errors.As(err, &ambiguous) {
+			b.askBoard(m, key, ambiguous)
+		}
+	}
+	return infos, nil
+}
+
+// secids returns sorted unique keys of all maps
+func secids(sets ...map[string]float64) []string {
+	unique := make(map[string]struct{})
+	for _, set := range sets {
+		for secid := range set {
+			unique[secid] = struct{}{}
+		}
+	}
+	res := make([]string, 0, len(unique))
+	for secid := range unique {
+		res = append(res, secid)
+	}
+	sort.Strings(res)
+	return res
 }
 
 func (b *Bot) onError(m *tb.Message, err error) {
@@ -331,8 +576,19 @@ func (b *Bot) reply(m *tb.Message, msg string) {
 	}
 }
 
-func (b *Bot) isUserFinished(m *tb.Message) bool {
-	finished, err := b.store.IsUserFinished(m.Sender.ID)
+// activePortfolio returns name of the portfolio commands work with.
+// False is returned when user is already notified about an error.
+func (b *Bot) activePortfolio(m *tb.Message) (string, bool) {
+	portfolio, err := b.store.ActivePortfolio(m.Sender.ID)
+	if err != nil {
+		b.onError(m, errors.Wrap(err, "error while retriving active portfolio"))
+		return "", false
+	}
+	return portfolio, true
+}
+
+func (b *Bot) isUserFinished(m *tb.Message, portfolio string) bool {
+	finished, err := b.store.IsUserFinished(m.Sender.ID, portfolio)
 	if err != nil {
 		b.onError(m, errors.Wrap(err, "error while checking user state"))
 		return false
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/pechorka/whattobuy/moex"
+	"github.com/pechorka/whattobuy/rebalance"
+	"github.com/pechorka/whattobuy/store"
+)
+
+func TestBot_rubPositions_missing(t *testing.T) {
+	b := &Bot{mapi: moex.New(moex.Opts{})}
+	partfolio := store.Partfolio{"TQBR:SBER": 60, "TQBR:DELISTED": 40}
+	holdings := store.Holdings{"TQBR:SBER": 10, "FQBR:AAPL-RM": 1}
+	infos := map[string]moex.StockInfo{
+		"TQBR:SBER": {SecID: "SBER", Price: 300, LotSize: 10, Currency: moex.CurrencyRUB},
+	}
+
+	positions, rates, missing, err := b.rubPositions(context.Background(), partfolio, holdings, infos)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []rebalance.Position{{ID: "TQBR:SBER", Weight: 60, Price: 300, LotSize: 10, Held: 10}}
+	if !reflect.DeepEqual(positions, want) {
+		t.Errorf("expected positions %+v, got %+v", want, positions)
+	}
+	if len(rates) != 0 {
+		t.Errorf("expected no foreign rates, got %v", rates)
+	}
+	if wantMissing := []string{"AAPL-RM", "DELISTED"}; !reflect.DeepEqual(missing, wantMissing) {
+		t.Errorf("expected missing %v, got %v", wantMissing, missing)
+	}
+}
+
+// sharesResp is a TQBR board with SBER priced and DELISTED without price
+const sharesResp = `{
+"securities": {"columns": ["SECID", "SHORTNAME", "LOTSIZE", "PREVADMITTEDQUOTE", "PREVDATE", "CURRENCYID"], "data": [
+	["SBER", "Сбербанк", 1, 300, "2021-06-01", "SUR"],
+	["DELISTED", "Делистинг", 1, null, "2021-06-01", "SUR"]
+]},
+"marketdata": {"columns": ["SECID", "BOARDID"], "data": []}
+}`
+
+const emptyBoardResp = `{"securities": {"columns": ["SECID", "SHORTNAME", "LOTSIZE", "PREVADMITTEDQUOTE"], "data": []}, "marketdata": {"columns": ["SECID", "BOARDID"], "data": []}}`
+
+func TestBot_buyBreakdown_missingPrice(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "/boards/"+moex.BoardStock+"/") {
+			w.Write([]byte(sharesResp))
+			return
+		}
+		w.Write([]byte(emptyBoardResp))
+	}))
+	defer server.Close()
+	s, err := store.New("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	b := &Bot{store: s, mapi: moex.New(moex.Opts{Client: server.Client(), BaseURL: server.URL})}
+
+	if err := s.AddToPartfolio(1, store.DefaultPortfolio, map[string]float64{"TQBR:SBER": 60, "TQBR:DELISTED": 40}); err != nil {
+		t.Fatal(err)
+	}
+	text, purchase, err := b.buyBreakdown(context.Background(), nil, 1, store.DefaultPortfolio, 10000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 40% of capital is kept for security without price instead of being spent on SBER
+	if want := map[string]float64{"TQBR:SBER": 20}; !reflect.DeepEqual(purchase.Quantities, want) {
+		t.Errorf("expected purchase %v, got %v", want, purchase.Quantities)
+	}
+	if want := "4000.00 рублей (40.00%) отложено на бумаги без цены: DELISTED"; !strings.Contains(text, want) {
+		t.Errorf("expected reply to mention reserve %q, got:\n%s", want, text)
+	}
+}
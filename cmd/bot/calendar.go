@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pechorka/whattobuy/moex"
+	"github.com/pechorka/whattobuy/store"
+	"github.com/pkg/errors"
+
+	tb "gopkg.in/tucnak/telebot.v2"
+)
+
+// calendarMonths is how far ahead /calendar looks for payouts
+const calendarMonths = 12
+
+// payout is an expected payment of one security
+type payout struct {
+	secid string
+	// date is record date for dividends and payment date for coupons
+	date       time.Time
+	recordDate time.Time
+	coupon     bool
+	// value is paid per one security, it is zero when not announced yet
+	value    float64
+	currency string
+}
+
+func (b *Bot) onCalendar(m *tb.Message) {
+	portfolio, ok := b.activePortfolio(m)
+	if !ok {
+		return
+	}
+	partfolio, err := b.store.GetPartfolio(m.Sender.ID, portfolio)
+	if err != nil {
+		b.onError(m, errors.Wrap(err, "error while retriving portfolio"))
+		return
+	}
+	if len(partfolio) == 0 {
+		b.reply(m, "Портфель сейчас пуст. Добавляйте сообщения вида 'тикер процент'")
+		return
+	}
+	holdings, err := b.store.GetHoldings(m.Sender.ID, portfolio)
+	if err != nil {
+		b.onError(m, errors.Wrap(err, "error while retriving holdings"))
+		return
+	}
+	ctx := context.TODO()
+	infos, err := b.loadSecurityPrices(ctx, m, partfolio)
+	if err != nil {
+		b.onError(m, errors.Wrap(err, "error while retriving prices"))
+		return
+	}
+	now := time.Now().In(moex.Moscow)
+	from := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, moex.Moscow)
+	payouts, failed := b.upcomingPayouts(ctx, infos, from, from.AddDate(0, calendarMonths, 0))
+	b.reply(m, describeCalendar(portfolio, payouts, failed, infos, holdings))
+}
+
+func describeCalendar(portfolio string, payouts []payout, failed []string, infos map[string]moex.StockInfo, holdings store.Holdings) string {
+	var reply strings.Builder
+	if len(payouts) == 0 {
+		reply.WriteString(fmt.Sprintf("В ближайшие %d месяцев выплат по бумагам портфеля не ожидается\n", calendarMonths))
+	} else {
+		reply.WriteString(fmt.Sprintf("Выплаты по бумагам портфеля %s:\n", portfolio))
+	}
+	var hasDividends bool
+	for _, p := range payouts {
+		info := infos[p.secid]
+		kind := "дивиденд"
+		if p.coupon {
+			kind = "купон"
+		} else {
+			hasDividends = true
+		}
+		reply.WriteString(fmt.Sprintf("%s %s - %s", p.date.Format("02.01.2006"), tickerOf(p.secid), kind))
+		if p.value == 0 {
+			reply.WriteString(" (размер ещё не объявлен)")
+		} else {
+			reply.WriteString(fmt.Sprintf(" %.2f %s на лот", p.value*info.LotSize, p.currency))
+			if held := holdings[p.secid]; held > 0 {
+				reply.WriteString(fmt.Sprintf(", на ваши %.0f шт. - %.2f %s", held, p.value*held, p.currency))
+			}
+		}
+		if p.coupon && !p.recordDate.IsZero() {
+			reply.WriteString(fmt.Sprintf(", реестр %s", p.recordDate.Format("02.01.2006")))
+		}
+		reply.WriteString("\n")
+	}
+	if len(failed) > 0 {
+		reply.WriteString(fmt.Sprintf("\nНе удалось загрузить выплаты по бумагам %s, попробуйте позже\n", strings.Join(failed, ", ")))
+	}
+	if hasDividends {
+		reply.WriteString("\nДля дивидендов указана дата закрытия реестра: чтобы получить дивиденд, купите акции не позже, чем за один торговый день до неё")
+	}
+	return strings.TrimSuffix(reply.String(), "\n")
+}
+
+// upcomingPayouts returns dividends of shares and coupons of bonds between from and till dates inclusive,
+// sorted by date. Securities which payouts failed to load are returned as failed tickers.
+func (b *Bot) upcomingPayouts(ctx context.Context, infos map[string]moex.StockInfo, from, till time.Time) (payouts []payout, failed []string) {
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+	for secid, info := range infos {
+		secid, info := secid, info
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var (
+				loaded []payout
+				err    error
+			)
+			if info.IsBond() {
+				var coupons []moex.Coupon
+				coupons, err = b.mapi.Coupons(ctx, info.Ref())
+				for _, c := range coupons {
+					loaded = append(loaded, payout{secid: secid, date: c.Date, recordDate: c.RecordDate, coupon: true, value: c.Value, currency: c.FaceUnit})
+				}
+			} else {
+				var dividends []moex.Dividend
+				dividends, err = b.mapi.Dividends(ctx, info.Ref())
+				for _, d := range dividends {
+					loaded = append(loaded, payout{secid: secid, date: d.RecordDate, recordDate: d.RecordDate, value: d.Value, currency: d.Currency})
+				}
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				log.Printf("[ERROR] while retriving payouts of %s: %v", secid, err)
+				failed = append(failed, tickerOf(secid))
+				return
+			}
+			payouts = append(payouts, loaded...)
+		}()
+	}
+	wg.Wait()
+
+	sort.Strings(failed)
+	return payoutsBetween(payouts, from, till), failed
+}
+
+// payoutsBetween returns payouts between from and till dates inclusive sorted by date
+func payoutsBetween(payouts []payout, from, till time.Time) []payout {
+	var res []payout
+	for _, p := range payouts {
+		if !p.date.Before(from) && !p.date.After(till) {
+			res = append(res, p)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if !res[i].date.Equal(res[j].date) {
+			return res[i].date.Before(res[j].date)
+		}
+		return res[i].secid < res[j].secid
+	})
+	return res
+}
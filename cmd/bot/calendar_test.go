@@ -0,0 +1,93 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pechorka/whattobuy/moex"
+	"github.com/pechorka/whattobuy/store"
+)
+
+func TestPayoutsBetween(t *testing.T) {
+	date := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, 0, 0, 0, 0, moex.Moscow)
+	}
+	from, till := date(2021, 11, 10), date(2022, 11, 10)
+	payouts := []payout{
+		{secid: "TQBR:SBER", date: date(2022, 5, 11), value: 18.7},
+		{secid: "TQOB:SU26207RMFS9", date: date(2021, 11, 9), coupon: true, value: 40.64},
+		{secid: "TQOB:SU26207RMFS9", date: date(2022, 2, 9), coupon: true, value: 40.64},
+		{secid: "TQBR:AFKS", date: date(2022, 2, 9), value: 0.1},
+		{secid: "TQBR:GAZP", date: from, value: 0},
+		{secid: "TQBR:MTSS", date: till, value: 13.25},
+		{secid: "TQBR:LKOH", date: till.AddDate(0, 0, 1), value: 340},
+	}
+	got := payoutsBetween(payouts, from, till)
+	var gotIDs []string
+	for _, p := range got {
+		gotIDs = append(gotIDs, p.secid)
+	}
+	want := []string{"TQBR:GAZP", "TQBR:AFKS", "TQOB:SU26207RMFS9", "TQBR:SBER", "TQBR:MTSS"}
+	if !reflect.DeepEqual(gotIDs, want) {
+		t.Errorf("expected payouts %v, got %v", want, gotIDs)
+	}
+}
+
+func TestDescribeCalendar(t *testing.T) {
+	date := time.Date(2022, 2, 9, 0, 0, 0, 0, moex.Moscow)
+	infos := map[string]moex.StockInfo{
+		"TQBR:SBER":         {SecID: "SBER", LotSize: 10},
+		"TQBR:GAZP":         {SecID: "GAZP", LotSize: 10},
+		"TQOB:SU26207RMFS9": {SecID: "SU26207RMFS9", LotSize: 1},
+	}
+	holdings := store.Holdings{"TQBR:SBER": 30}
+
+	tests := []struct {
+		name    string
+		payouts []payout
+		failed  []string
+		want    []string
+		// unwanted must not be in reply
+		unwanted []string
+	}{
+		{
+			name:    "amount per lot and held",
+			payouts: []payout{{secid: "TQBR:SBER", date: date, recordDate: date, value: 18.7, currency: "RUB"}},
+			want:    []string{"09.02.2022 SBER - дивиденд 187.00 RUB на лот, на ваши 30 шт. - 561.00 RUB", "закрытия реестра"},
+		},
+		{
+			name:     "not held",
+			payouts:  []payout{{secid: "TQOB:SU26207RMFS9", date: date, recordDate: date.AddDate(0, 0, -1), coupon: true, value: 40.64, currency: "RUB"}},
+			want:     []string{"09.02.2022 SU26207RMFS9 - купон 40.64 RUB на лот, реестр 08.02.2022"},
+			unwanted: []string{"на ваши", "закрытия реестра"},
+		},
+		{
+			name:     "not announced",
+			payouts:  []payout{{secid: "TQBR:GAZP", date: date, recordDate: date}},
+			want:     []string{"09.02.2022 GAZP - дивиденд (размер ещё не объявлен)"},
+			unwanted: []string{"на лот"},
+		},
+		{
+			name:   "failed securities",
+			failed: []string{"AAPL-RM"},
+			want:   []string{"выплат по бумагам портфеля не ожидается", "Не удалось загрузить выплаты по бумагам AAPL-RM"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := describeCalendar(store.DefaultPortfolio, tt.payouts, tt.failed, infos, holdings)
+			for _, want := range tt.want {
+				if !strings.Contains(got, want) {
+					t.Errorf("expected %q in reply:\n%s", want, got)
+				}
+			}
+			for _, unwanted := range tt.unwanted {
+				if strings.Contains(got, unwanted) {
+					t.Errorf("expected no %q in reply:\n%s", unwanted, got)
+				}
+			}
+		})
+	}
+}
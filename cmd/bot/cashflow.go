@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pechorka/whattobuy/moex"
+	"github.com/pechorka/whattobuy/store"
+	"github.com/pkg/errors"
+
+	tb "gopkg.in/tucnak/telebot.v2"
+)
+
+const (
+	defaultCashflowMonths = 12
+	minCashflowMonths     = 12
+	maxCashflowMonths     = 36
+)
+
+// cashPayment is money in rubles that held bonds of one security pay on the date
+type cashPayment struct {
+	secid  string
+	date   time.Time
+	amount float64
+	// redemption is repayment of face value, maturity marks the last one
+	redemption bool
+	maturity   bool
+	// estimated coupon is not announced yet, the last known coupon is used instead
+	estimated bool
+}
+
+type monthCashflow struct {
+	month       time.Time
+	coupons     float64
+	redemptions float64
+	estimated   bool
+}
+
+func (b *Bot) onCashflow(m *tb.Message) {
+	portfolio, ok := b.activePortfolio(m)
+	if !ok {
+		return
+	}
+	months := defaultCashflowMonths
+	if payload := strings.TrimSpace(m.Payload); payload != "" {
+		n, err := strconv.Atoi(payload)
+		if err != nil || n < minCashflowMonths || n > maxCashflowMonths {
+			b.onInvalidInput(m, errors.Errorf("Количество месяцев должно быть числом от %d до %d, а сейчас %s", minCashflowMonths, maxCashflowMonths, payload))
+			return
+		}
+		months = n
+	}
+
+	holdings, err := b.store.GetHoldings(m.Sender.ID, portfolio)
+	if err != nil {
+		b.onError(m, errors.Wrap(err, "error while retriving holdings"))
+		return
+	}
+	partfolio, err := b.store.GetPartfolio(m.Sender.ID, portfolio)
+	if err != nil {
+		b.onError(m, errors.Wrap(err, "error while retriving portfolio"))
+		return
+	}
+	ctx := context.TODO()
+	infos, err := b.loadSecurityPrices(ctx, m, nil, holdings)
+	if err != nil {
+		b.onError(m, errors.Wrap(err, "error while retriving prices"))
+		return
+	}
+	bonds := make(map[string]moex.StockInfo)
+	for secid, info := range infos {
+		if info.IsBond() && holdings[secid] > 0 {
+			bonds[secid] = info
+		}
+	}
+	if len(bonds) == 0 {
+		b.reply(m, "Среди ваших бумаг нет облигаций. Добавляйте их командой '/hold тикер количество'")
+		return
+	}
+
+	now := time.Now().In(moex.Moscow)
+	from := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, moex.Moscow)
+	till := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, moex.Moscow).AddDate(0, months, -1)
+	payments, failed := b.bondPayments(ctx, bonds, holdings, from, till)
+	b.reply(m, describeCashflow(portfolio, monthlyCashflow(from, months, payments), payments, failed, partfolio))
+}
+
+// bondPayments returns coupons and amortizations of held bonds between from and till dates inclusive,
+// sorted by date. Bonds which payments failed to load are returned as failed tickers.
+func (b *Bot) bondPayments(ctx context.Context, bonds map[string]moex.StockInfo, holdings store.Holdings, from, till time.Time) (payments []cashPayment, failed []string) {
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+	for secid, info := range bonds {
+		secid, info := secid, info
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			loaded, err := b.loadBondPayments(ctx, secid, info, holdings[secid])
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				log.Printf("[ERROR] while retriving payments of %s: %v", secid, err)
+				failed = append(failed, tickerOf(secid))
+				return
+			}
+			for _, p := range loaded {
+				if p.date.Before(from) || p.date.After(till) || p.amount == 0 {
+					continue
+				}
+				payments = append(payments, p)
+			}
+		}()
+	}
+	wg.Wait()
+
+	sort.Slice(payments, func(i, j int) bool {
+		if !payments[i].date.Equal(payments[j].date) {
+			return payments[i].date.Before(payments[j].date)
+		}
+		return payments[i].secid < payments[j].secid
+	})
+	sort.Strings(failed)
+	return payments, failed
+}
+
+// loadBondPayments returns all coupons and amortizations of held bonds of one security
+func (b *Bot) loadBondPayments(ctx context.Context, secid string, info moex.StockInfo, held float64) ([]cashPayment, error) {
+	coupons, err := b.mapi.Coupons(ctx, info.Ref())
+	if err != nil {
+		return nil, errors.Wrap(err, "error while retriving coupons")
+	}
+	amortizations, err := b.mapi.Amortizations(ctx, info.Ref())
+	if err != nil {
+		return nil, errors.Wrap(err, "error while retriving amortizations")
+	}
+
+	faceUnit := bondFaceUnit(info)
+	rates := make(map[string]float64)
+	addRate := func(unit string) error {
+		unit = paymentUnit(unit, faceUnit)
+		if _, ok := rates[unit]; ok {
+			return nil
+		}
+		rate, err := b.mapi.Rate(ctx, unit)
+		if err != nil {
+			return errors.Wrapf(err, "error while converting payments in %s to rubles", unit)
+		}
+		rates[unit] = rate
+		return nil
+	}
+	for _, c := range coupons {
+		if err := addRate(c.FaceUnit); err != nil {
+			return nil, err
+		}
+	}
+	for _, a := range amortizations {
+		if err := addRate(a.FaceUnit); err != nil {
+			return nil, err
+		}
+	}
+	return convertBondPayments(secid, held, faceUnit, coupons, amortizations, rates), nil
+}
+
+// convertBondPayments converts coupons and amortizations of held bonds to rubles.
+// Payments are made in their FaceUnit, which may differ from the currency bond is traded in,
+// rates are prices of the currencies in rubles. Payments without FaceUnit are made in faceUnit of the bond.
+func convertBondPayments(secid string, held float64, faceUnit string, coupons []moex.Coupon, amortizations []moex.Amortization, rates map[string]float64) []cashPayment {
+	var (
+		payments  []cashPayment
+		lastKnown float64
+	)
+	for _, c := range coupons {
+		perBond := held * rates[paymentUnit(c.FaceUnit, faceUnit)]
+		p := cashPayment{secid: secid, date: c.Date, amount: c.Value * perBond}
+		if c.Value != 0 {
+			lastKnown = c.Value
+		} else {
+			p.amount, p.estimated = lastKnown*perBond, true
+		}
+		payments = append(payments, p)
+	}
+	for i, a := range amortizations {
+		perBond := held * rates[paymentUnit(a.FaceUnit, faceUnit)]
+		payments = append(payments, cashPayment{secid: secid, date: a.Date, amount: a.Value * perBond, redemption: true, maturity: i == len(amortizations)-1})
+	}
+	return payments
+}
+
+// bondFaceUnit returns currency of bond face value, price currency is used if exchange does not publish it
+func bondFaceUnit(info moex.StockInfo) string {
+	if info.FaceUnit != "" {
+		return info.FaceUnit
+	}
+	if info.Currency != "" {
+		return info.Currency
+	}
+	return moex.CurrencyRUB
+}
+
+func paymentUnit(unit, faceUnit string) string {
+	if unit == "" {
+		return faceUnit
+	}
+	return unit
+}
+
+// monthlyCashflow sums payments by months, the first month is the month of from
+func monthlyCashflow(from time.Time, months int, payments []cashPayment) []monthCashflow {
+	res := make([]monthCashflow, months)
+	first := time.Date(from.Year(), from.Month(), 1, 0, 0, 0, 0, from.Location())
+	for i := range res {
+		res[i].month = first.AddDate(0, i, 0)
+	}
+	for _, p := range payments {
+		date := p.date.In(from.Location())
+		i := (date.Year()-first.Year())*12 + int(date.Month()-first.Month())
+		if i < 0 || i >= months {
+			continue
+		}
+		if p.redemption {
+			res[i].redemptions += p.amount
+		} else {
+			res[i].coupons += p.amount
+		}
+		res[i].estimated = res[i].estimated || p.estimated
+	}
+	return res
+}
+
+func describeCashflow(portfolio string, months []monthCashflow, payments []cashPayment, failed []string, partfolio store.Partfolio) string {
+	var (
+		reply                       strings.Builder
+		totalCoupons, totalRedeemed float64
+		estimated                   bool
+	)
+	reply.WriteString(fmt.Sprintf("Поступления по облигациям портфеля %s на %d мес.:\n", portfolio, len(months)))
+	for _, month := range months {
+		total := month.coupons + month.redemptions
+		totalCoupons += month.coupons
+		totalRedeemed += month.redemptions
+		reply.WriteString(fmt.Sprintf("%s - %.2f рублей", month.month.Format("01.2006"), total))
+		if month.redemptions != 0 {
+			reply.WriteString(fmt.Sprintf(" (из них погашения %.2f)", month.redemptions))
+		}
+		if month.estimated {
+			reply.WriteString("*")
+			estimated = true
+		}
+		reply.WriteString("\n")
+	}
+	reply.WriteString(fmt.Sprintf("\nВсего %.2f рублей: купоны %.2f, погашения %.2f\n", totalCoupons+totalRedeemed, totalCoupons, totalRedeemed))
+	if estimated {
+		reply.WriteString("* размер части купонов ещё не объявлен, взят размер последнего известного купона\n")
+	}
+	if len(failed) > 0 {
+		reply.WriteString(fmt.Sprintf("\nНе удалось загрузить выплаты по облигациям %s, они не учтены. Попробуйте позже\n", strings.Join(failed, ", ")))
+	}
+
+	var maturing []string
+	for _, p := range payments {
+		if !p.maturity {
+			continue
+		}
+		line := fmt.Sprintf("%s %s - вернётся %.2f рублей", p.date.Format("02.01.2006"), tickerOf(p.secid), p.amount)
+		if weight := partfolio[p.secid]; weight > 0 {
+			line += fmt.Sprintf(", цель в портфеле %.2f%%: подберите облигацию на замену этой доли", weight)
+		} else {
+			line += ", бумаги нет в целях портфеля"
+		}
+		maturing = append(maturing, line+fmt.Sprintf(". Распределить деньги по целям можно командой /buy %.0f", p.amount))
+	}
+	if len(maturing) > 0 {
+		reply.WriteString("\nПогашаются, деньги нужно будет реинвестировать:\n")
+		reply.WriteString(strings.Join(maturing, "\n"))
+	}
+	return reply.String()
+}
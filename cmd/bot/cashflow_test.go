@@ -0,0 +1,83 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pechorka/whattobuy/moex"
+)
+
+func TestMonthlyCashflow(t *testing.T) {
+	date := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, 0, 0, 0, 0, moex.Moscow)
+	}
+	payments := []cashPayment{
+		{secid: "TQOB:A", date: date(2021, 11, 20), amount: 100},
+		{secid: "TQOB:B", date: date(2021, 11, 30), amount: 50, estimated: true},
+		{secid: "TQOB:A", date: date(2022, 1, 15), amount: 1000, redemption: true, maturity: true},
+		{secid: "TQOB:A", date: date(2022, 1, 15), amount: 100},
+		// after the last month
+		{secid: "TQOB:B", date: date(2022, 2, 1), amount: 50},
+	}
+	got := monthlyCashflow(date(2021, 11, 10), 3, payments)
+	want := []monthCashflow{
+		{month: date(2021, 11, 1), coupons: 150, estimated: true},
+		{month: date(2021, 12, 1)},
+		{month: date(2022, 1, 1), coupons: 100, redemptions: 1000},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("expected %d months, got %+v", len(want), got)
+	}
+	for i := range want {
+		if !got[i].month.Equal(want[i].month) || got[i].coupons != want[i].coupons ||
+			got[i].redemptions != want[i].redemptions || got[i].estimated != want[i].estimated {
+			t.Errorf("month %d: expected %+v, got %+v", i, want[i], got[i])
+		}
+	}
+}
+
+func TestConvertBondPayments(t *testing.T) {
+	date := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, 0, 0, 0, 0, moex.Moscow)
+	}
+	// replacement bond is traded in rubles, but pays in dollars
+	info := moex.StockInfo{Currency: moex.CurrencyRUB, FaceUnit: "USD"}
+	coupons := []moex.Coupon{
+		{Date: date(2022, 1, 10), Value: 15, FaceUnit: "USD"},
+		{Date: date(2022, 7, 10), FaceUnit: "USD"},
+		{Date: date(2023, 1, 10), Value: 2},
+	}
+	amortizations := []moex.Amortization{
+		{Date: date(2023, 1, 10), Value: 1000, FaceUnit: "USD"},
+	}
+	rates := map[string]float64{moex.CurrencyRUB: 1, "USD": 90}
+
+	got := convertBondPayments("TQCB:RU000A105A95", 2, bondFaceUnit(info), coupons, amortizations, rates)
+	want := []cashPayment{
+		{secid: "TQCB:RU000A105A95", date: date(2022, 1, 10), amount: 2700},
+		{secid: "TQCB:RU000A105A95", date: date(2022, 7, 10), amount: 2700, estimated: true},
+		// without currency payment is in face unit of the bond
+		{secid: "TQCB:RU000A105A95", date: date(2023, 1, 10), amount: 360},
+		{secid: "TQCB:RU000A105A95", date: date(2023, 1, 10), amount: 180000, redemption: true, maturity: true},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("expected %d payments, got %+v", len(want), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("payment %d: expected %+v, got %+v", i, want[i], got[i])
+		}
+	}
+}
+
+func TestDescribeCashflow_failed(t *testing.T) {
+	from := time.Date(2021, 11, 10, 0, 0, 0, 0, moex.Moscow)
+	payments := []cashPayment{{secid: "TQOB:SU26207RMFS9", date: from, amount: 100}}
+	got := describeCashflow("main", monthlyCashflow(from, 12, payments), payments, []string{"RU000A105A95"}, nil)
+	for _, want := range []string{"11.2021 - 100.00 рублей", "Не удалось загрузить выплаты по облигациям RU000A105A95"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("expected %q in %q", want, got)
+		}
+	}
+}
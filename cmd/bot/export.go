@@ -0,0 +1,316 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pechorka/whattobuy/store"
+	"github.com/pkg/errors"
+
+	tb "gopkg.in/tucnak/telebot.v2"
+)
+
+const (
+	formatCSV  = "csv"
+	formatJSON = "json"
+
+	// maxImportSize is more than enough for any real portfolio
+	maxImportSize = 1 << 20
+)
+
+// portfolioFile is exported and imported portfolio. Keys are written like in store, "BOARD:SECID",
+// and may be plain tickers in imported file
+type portfolioFile struct {
+	Portfolio string             `json:"portfolio,omitempty"`
+	Targets   map[string]float64 `json:"targets"`
+	Holdings  map[string]float64 `json:"holdings,omitempty"`
+}
+
+func encodePortfolio(f portfolioFile, format string) ([]byte, error) {
+	if format == formatJSON {
+		return json.MarshalIndent(f, "", "  ")
+	}
+
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	if err := w.Write([]string{"secid", "target", "holding"}); err != nil {
+		return nil, err
+	}
+	for _, secid := range secids(f.Targets, f.Holdings) {
+		row := []string{secid, "", ""}
+		if p, ok := f.Targets[secid]; ok {
+			row[1] = strconv.FormatFloat(p, 'f', -1, 64)
+		}
+		if qty, ok := f.Holdings[secid]; ok {
+			row[2] = strconv.FormatFloat(qty, 'f', -1, 64)
+		}
+		if err := w.Write(row); err != nil {
+			return nil, err
+		}
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+// decodePortfolio reads file produced by encodePortfolio. CSV may be edited in a spreadsheet,
+// so header is optional and semicolon separated values with decimal commas are accepted too.
+// Values of both formats are checked the same way.
+func decodePortfolio(data []byte, format string) (portfolioFile, error) {
+	var f portfolioFile
+	if format == formatJSON {
+		if err := json.Unmarshal(data, &f); err != nil {
+			return f, errors.Wrap(err, "некорректный JSON")
+		}
+		return f, validatePortfolioFile(f)
+	}
+
+	r := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, []byte("\xEF\xBB\xBF"))))
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+	semicolon := bytes.Count(data, []byte(";")) > bytes.Count(data, []byte(","))
+	if semicolon {
+		r.Comma = ';'
+	}
+	parseNumber := func(s string) (float64, error) {
+		if semicolon {
+			s = strings.Replace(s, ",", ".", 1)
+		}
+		return strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(s), "%"), 64)
+	}
+
+	f.Targets = make(map[string]float64)
+	f.Holdings = make(map[string]float64)
+	for line := 1; ; line++ {
+		row, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return f, errors.Wrap(err, "некорректный CSV")
+		}
+		if len(row) == 0 || strings.TrimSpace(row[0]) == "" {
+			continue
+		}
+		if line == 1 && strings.EqualFold(strings.TrimSpace(row[0]), "secid") {
+			continue
+		}
+		if len(row) < 2 || len(row) > 3 {
+			return f, errors.Errorf("строка %d: ожидается формат 'тикер,процент,количество'", line)
+		}
+		secid := strings.TrimSpace(row[0])
+		if strings.TrimSpace(row[1]) != "" {
+			p, err := parseNumber(row[1])
+			if err != nil {
+				return f, errors.Errorf("строка %d: некорректный процент %q", line, row[1])
+			}
+			f.Targets[secid] = p
+		}
+		if len(row) == 3 && strings.TrimSpace(row[2]) != "" {
+			qty, err := parseNumber(row[2])
+			if err != nil || qty < 0 {
+				return f, errors.Errorf("строка %d: некорректное количество %q", line, row[2])
+			}
+			f.Holdings[secid] = qty
+		}
+	}
+	return f, validatePortfolioFile(f)
+}
+
+// validatePortfolioFile checks that target percents are from 0 to 100 and holdings are not negative
+func validatePortfolioFile(f portfolioFile) error {
+	for _, secid := range secids(f.Targets) {
+		if p := f.Targets[secid]; !(p >= 0 && p <= 100) {
+			return errors.Errorf("%s: процент должен быть от 0 до 100, а сейчас %v", secid, p)
+		}
+	}
+	for _, secid := range secids(f.Holdings) {
+		if qty := f.Holdings[secid]; !(qty >= 0) || math.IsInf(qty, 0) {
+			return errors.Errorf("%s: количество не может быть отрицательным, а сейчас %v", secid, qty)
+		}
+	}
+	return nil
+}
+
+func (b *Bot) onExport(m *tb.Message) {
+	portfolio, ok := b.activePortfolio(m)
+	if !ok {
+		return
+	}
+	format := strings.ToLower(strings.TrimSpace(m.Payload))
+	switch format {
+	case "":
+		format = formatCSV
+	case formatCSV, formatJSON:
+	default:
+		b.onInvalidInput(m, errors.New("Ожидается формат '/export [csv|json]'"))
+		return
+	}
+	partfolio, err := b.store.GetPartfolio(m.Sender.ID, portfolio)
+	if err != nil {
+		b.onError(m, errors.Wrap(err, "error while retriving portfolio"))
+		return
+	}
+	holdings, err := b.store.GetHoldings(m.Sender.ID, portfolio)
+	if err != nil {
+		b.onError(m, errors.Wrap(err, "error while retriving holdings"))
+		return
+	}
+	if len(partfolio) == 0 && len(holdings) == 0 {
+		b.reply(m, "Портфель пустой, выгружать нечего")
+		return
+	}
+
+	f := portfolioFile{Portfolio: portfolio, Targets: make(map[string]float64, len(partfolio))}
+	for secid, p := range partfolio {
+		f.Targets[secid] = p
+	}
+	if len(holdings) > 0 {
+		f.Holdings = make(map[string]float64, len(holdings))
+		for secid, qty := range holdings {
+			f.Holdings[secid] = qty
+		}
+	}
+	data, err := encodePortfolio(f, format)
+	if err != nil {
+		b.onError(m, errors.Wrap(err, "error while encoding portfolio"))
+		return
+	}
+	doc := &tb.Document{
+		File:     tb.FromReader(bytes.NewReader(data)),
+		FileName: portfolio + "." + format,
+		Caption:  fmt.Sprintf("Портфель %s. Чтобы загрузить его обратно, отправьте этот файл боту", portfolio),
+	}
+	if format == formatJSON {
+		doc.MIME = "application/json"
+	} else {
+		doc.MIME = "text/csv"
+	}
+	if _, err := b.telebot.Reply(m, doc); err != nil {
+		b.onError(m, errors.Wrap(err, "error while sending portfolio file"))
+	}
+}
+
+// onImport replaces active portfolio with uploaded file, or merges file into it when caption is 'merge'
+func (b *Bot) onImport(m *tb.Message) {
+	portfolio, ok := b.activePortfolio(m)
+	if !ok {
+		return
+	}
+	if b.isUserFinished(m, portfolio) {
+		b.reply(m, "У вас уже заполнен портфель. Для загрузки портфеля из файла сначала воспользуйтесь командой /restart")
+		return
+	}
+	if m.Document.FileSize > maxImportSize {
+		b.onInvalidInput(m, errors.New("Файл слишком большой"))
+		return
+	}
+	caption := strings.ToLower(strings.TrimSpace(m.Caption))
+	replace := caption != "merge" && caption != "добавить"
+
+	rc, err := b.telebot.GetFile(&m.Document.File)
+	if err != nil {
+		b.onError(m, errors.Wrap(err, "error while downloading file"))
+		return
+	}
+	defer rc.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(rc, maxImportSize))
+	if err != nil {
+		b.onError(m, errors.Wrap(err, "error while downloading file"))
+		return
+	}
+	format := formatCSV
+	if strings.EqualFold(filepath.Ext(m.Document.FileName), ".json") || bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		format = formatJSON
+	}
+	f, err := decodePortfolio(data, format)
+	if err != nil {
+		b.onInvalidInput(m, err)
+		return
+	}
+
+	ctx := context.TODO()
+	input := make([]targetInput, 0, len(f.Targets))
+	for _, ticker := range secids(f.Targets) {
+		input = append(input, targetInput{ticker: ticker, percent: f.Targets[ticker]})
+	}
+	u, ok := b.prepareTargets(ctx, m, portfolio, input, replace)
+	if !ok {
+		return
+	}
+	holdings, notFound, err := b.resolveHoldings(ctx, f.Holdings)
+	if err != nil {
+		b.onError(m, errors.Wrap(err, "error while fetching data from moex"))
+		return
+	}
+	notFound = append(u.notFoundTickers(), notFound...)
+	if len(notFound) > 0 {
+		b.onInvalidInput(m, errors.Errorf("Не найдены бумаги: %s. Портфель не изменён", strings.Join(notFound, ", ")))
+		return
+	}
+	if replace && len(holdings) > 0 {
+		current, err := b.store.GetHoldings(m.Sender.ID, portfolio)
+		if err != nil {
+			b.onError(m, errors.Wrap(err, "error while retriving holdings"))
+			return
+		}
+		for secid := range current {
+			if _, ok := holdings[secid]; !ok {
+				holdings[secid] = 0
+			}
+		}
+	}
+
+	var prices map[string]float64
+	if len(holdings) > 0 {
+		prices = b.tradePrices(ctx, holdings)
+	}
+	if err := b.store.ImportPortfolio(m.Sender.ID, portfolio, u.changes, holdings, prices); err != nil {
+		b.onError(m, errors.Wrap(err, "error while importing portfolio"))
+		return
+	}
+
+	var reply strings.Builder
+	if replace {
+		reply.WriteString(fmt.Sprintf("Портфель %s заменён содержимым файла", portfolio))
+	} else {
+		reply.WriteString(fmt.Sprintf("Содержимое файла добавлено в портфель %s", portfolio))
+	}
+	reply.WriteString(fmt.Sprintf(", сумма долей %.2f%%:\n", u.total()))
+	for _, secid := range secids(u.result) {
+		reply.WriteString(fmt.Sprintf("%s - %.2f%%\n", tickerOf(secid), u.result[secid]))
+	}
+	if u.total() == 100 {
+		reply.WriteString("\nЧтобы завершить ввод портфеля, нажмите /finish")
+	}
+	reply.WriteString("\nОтменить изменение долей можно командой /undo")
+	b.reply(m, reply.String())
+}
+
+// resolveHoldings maps tickers to refs of securities known to moex, unknown and ambiguous tickers are returned separately
+func (b *Bot) resolveHoldings(ctx context.Context, tickers map[string]float64) (store.Holdings, []string, error) {
+	var (
+		holdings = make(store.Holdings, len(tickers))
+		notFound []string
+	)
+	for _, ticker := range secids(tickers) {
+		ref, err := b.findSecurity(ctx, ticker)
+		if isUnresolved(err) {
+			notFound = append(notFound, strings.ToUpper(ticker))
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		holdings[ref.String()] = tickers[ticker]
+	}
+	return holdings, notFound, nil
+}
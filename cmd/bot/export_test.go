@@ -0,0 +1,98 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPortfolioFile_roundTrip(t *testing.T) {
+	f := portfolioFile{
+		Targets:  map[string]float64{"AFKS": 60.5, "FXGD": 39.5},
+		Holdings: map[string]float64{"AFKS": 100, "SBER": 10},
+	}
+	for _, format := range []string{formatCSV, formatJSON} {
+		t.Run(format, func(t *testing.T) {
+			data, err := encodePortfolio(f, format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := decodePortfolio(data, format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got.Targets, f.Targets) || !reflect.DeepEqual(got.Holdings, f.Holdings) {
+				t.Errorf("got %+v, want %+v\n%s", got, f, data)
+			}
+		})
+	}
+}
+
+func TestDecodePortfolio_csv(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    portfolioFile
+		wantErr bool
+	}{
+		{
+			name: "spreadsheet with semicolons and decimal commas",
+			data: "\xEF\xBB\xBFSECID;TARGET;HOLDING\r\nAFKS;60,5%;100\r\nFXGD;39,5;\r\n",
+			want: portfolioFile{
+				Targets:  map[string]float64{"AFKS": 60.5, "FXGD": 39.5},
+				Holdings: map[string]float64{"AFKS": 100},
+			},
+		},
+		{
+			name: "no header and no holdings",
+			data: "AFKS,60\n\nFXGD,40\n",
+			want: portfolioFile{
+				Targets:  map[string]float64{"AFKS": 60, "FXGD": 40},
+				Holdings: map[string]float64{},
+			},
+		},
+		{
+			name:    "invalid percent",
+			data:    "AFKS,много\n",
+			wantErr: true,
+		},
+		{
+			name:    "too many columns",
+			data:    "AFKS,60,1,2\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodePortfolio([]byte(tt.data), formatCSV)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodePortfolio_validation(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   string
+		format string
+	}{
+		{name: "negative percent in csv", data: "AFKS,-10\nFXGD,110\n", format: formatCSV},
+		{name: "percent over 100 in csv", data: "AFKS,150\n", format: formatCSV},
+		{name: "not a number percent in csv", data: "AFKS,NaN\n", format: formatCSV},
+		{name: "negative holding in csv", data: "AFKS,60,-5\n", format: formatCSV},
+		{name: "negative percent in json", data: `{"targets":{"AFKS":-10,"FXGD":110}}`, format: formatJSON},
+		{name: "percent over 100 in json", data: `{"targets":{"AFKS":150}}`, format: formatJSON},
+		{name: "negative holding in json", data: `{"targets":{"AFKS":100},"holdings":{"AFKS":-5}}`, format: formatJSON},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if f, err := decodePortfolio([]byte(tt.data), tt.format); err == nil {
+				t.Errorf("expected error, got %+v", f)
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pechorka/whattobuy/moex"
+	"github.com/pechorka/whattobuy/store"
+	"github.com/pkg/errors"
+
+	tb "gopkg.in/tucnak/telebot.v2"
+)
+
+const historyLimit = 15
+
+func (b *Bot) onHistory(m *tb.Message) {
+	portfolio, ok := b.activePortfolio(m)
+	if !ok {
+		return
+	}
+	history, err := b.store.History(m.Sender.ID, portfolio, historyLimit)
+	if err != nil {
+		b.onError(m, errors.Wrap(err, "error while retriving history"))
+		return
+	}
+	if len(history) == 0 {
+		b.reply(m, "Портфель ещё не менялся")
+		return
+	}
+	var reply strings.Builder
+	reply.WriteString(fmt.Sprintf("Последние изменения портфеля %s:\n", portfolio))
+	for _, c := range history {
+		reply.WriteString(fmt.Sprintf("#%d %s - %s\n", c.Seq, c.Time.In(moex.Moscow).Format("02.01.2006 15:04"), describeChange(c)))
+	}
+	reply.WriteString("\nОтменить изменение и все следующие за ним: /undo номер, отменить последнее: /undo")
+	b.reply(m, reply.String())
+}
+
+func describeChange(c store.Change) string {
+	switch c.Action {
+	case store.ActionAdd:
+		changes := make([]string, 0, len(c.Targets))
+		for _, secid := range secids(c.Targets) {
+			if percent := c.Targets[secid]; percent != 0 {
+				changes = append(changes, fmt.Sprintf("%s %.2f%%", tickerOf(secid), percent))
+				continue
+			}
+			changes = append(changes, fmt.Sprintf("%s удалена", tickerOf(secid)))
+		}
+		return "изменение: " + strings.Join(changes, ", ")
+	case store.ActionFinish:
+		return "ввод завершён"
+	case store.ActionClear:
+		return "портфель удалён через /restart"
+	case store.ActionUndo:
+		return fmt.Sprintf("отмена изменений с #%d", c.UndoneTo)
+	default:
+		return string(c.Action)
+	}
+}
+
+func (b *Bot) onUndo(m *tb.Message) {
+	portfolio, ok := b.activePortfolio(m)
+	if !ok {
+		return
+	}
+	var seq int
+	if payload := strings.TrimSpace(m.Payload); payload != "" {
+		var err error
+		seq, err = strconv.Atoi(strings.TrimPrefix(payload, "#"))
+		if err != nil || seq <= 0 {
+			b.onInvalidInput(m, errors.Errorf("Номер изменения должен быть положительным числом, а сейчас %s. Номера можно посмотреть командой /history", payload))
+			return
+		}
+	} else {
+		history, err := b.store.History(m.Sender.ID, portfolio, 1)
+		if err != nil {
+			b.onError(m, errors.Wrap(err, "error while retriving history"))
+			return
+		}
+		if len(history) == 0 {
+			b.reply(m, "Портфель ещё не менялся, отменять нечего")
+			return
+		}
+		seq = history[0].Seq
+	}
+
+	restored, err := b.store.Undo(m.Sender.ID, portfolio, seq)
+	if err == store.ErrChangeNotFound {
+		b.onInvalidInput(m, errors.Errorf("Изменения #%d нет. Номера можно посмотреть командой /history", seq))
+		return
+	}
+	if err != nil {
+		b.onError(m, errors.Wrap(err, "error while undoing change"))
+		return
+	}
+
+	var reply strings.Builder
+	reply.WriteString(fmt.Sprintf("Портфель возвращён к состоянию до изменения #%d", seq))
+	if len(restored.Targets) == 0 {
+		reply.WriteString(", сейчас он пустой")
+		b.reply(m, reply.String())
+		return
+	}
+	if !restored.Finished {
+		reply.WriteString(", ввод не завершён - после проверки нажмите /finish")
+	}
+	reply.WriteString(":\n")
+	for _, secid := range secids(restored.Targets) {
+		reply.WriteString(fmt.Sprintf("%s - %.2f%%\n", tickerOf(secid), restored.Targets[secid]))
+	}
+	b.reply(m, reply.String())
+}
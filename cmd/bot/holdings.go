@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/pechorka/whattobuy/store"
+	"github.com/pkg/errors"
+
+	tb "gopkg.in/tucnak/telebot.v2"
+)
+
+func (b *Bot) onHold(m *tb.Message) {
+	portfolio, ok := b.activePortfolio(m)
+	if !ok {
+		return
+	}
+	if m.Payload == "" {
+		b.viewHoldings(m, portfolio)
+		return
+	}
+
+	input := strings.Fields(m.Payload)
+	if len(input) != 2 {
+		b.onInvalidInput(m, errors.New("Некорректный формат: ожидается формат '/hold тикер количество'"))
+		return
+	}
+	qty, err := strconv.ParseFloat(input[1], 64)
+	if err != nil || qty < 0 {
+		b.onInvalidInput(m, errors.Errorf("Количество бумаг должно быть неотрицательным числом, а сейчас %s", input[1]))
+		return
+	}
+	ref, err := b.findSecurity(context.TODO(), input[0])
+	if isUnresolved(err) {
+		b.suggest(m, strings.ToUpper(input[0]), qty, err, btnPickHolding)
+		return
+	}
+	if err != nil {
+		b.onError(m, errors.Wrap(err, "error while fetching data from moex"))
+		return
+	}
+
+	secid := ref.String()
+	change := map[string]float64{secid: qty}
+	if secid != ref.SecID { // data stored before boards were known is replaced
+		change[ref.SecID] = 0
+	}
+	if err := b.store.SetHoldings(m.Sender.ID, portfolio, change, b.tradePrices(context.TODO(), change)); err != nil {
+		b.onError(m, errors.Wrap(err, "error while updating holdings"))
+		return
+	}
+	if qty == 0 {
+		b.reply(m, fmt.Sprintf("%s удалена из ваших бумаг", tickerOf(secid)))
+		return
+	}
+	b.reply(m, fmt.Sprintf("Теперь у вас %.0f шт. %s. Команда /buy будет учитывать их при расчёте покупок", qty, tickerOf(secid)))
+}
+
+// tradePrices returns current prices in rubles of changed holdings, they are saved along with trades.
+// Trades are logged without price when it is unknown, so errors are only logged.
+func (b *Bot) tradePrices(ctx context.Context, holdings store.Holdings) map[string]float64 {
+	infos, err := b.loadSecurityPrices(ctx, nil, nil, holdings)
+	if err != nil {
+		log.Printf("[ERROR] while retriving prices of trades: %v", err)
+		return nil
+	}
+	prices := make(map[string]float64, len(infos))
+	for secid, info := range infos {
+		price, err := b.mapi.PriceRUB(ctx, info)
+		if err != nil {
+			log.Printf("[ERROR] while converting %s price to rubles: %v", secid, err)
+			continue
+		}
+		prices[secid] = price
+	}
+	return prices
+}
+
+func (b *Bot) viewHoldings(m *tb.Message, portfolio string) {
+	holdings, err := b.store.GetHoldings(m.Sender.ID, portfolio)
+	if err != nil {
+		b.onError(m, errors.Wrap(err, "error while retriving holdings"))
+		return
+	}
+	if len(holdings) == 0 {
+		b.reply(m, "Вы ещё не указали, какие бумаги у вас есть. Добавляйте их командой '/hold тикер количество'")
+		return
+	}
+	ctx := context.TODO()
+	infos, err := b.loadSecurityPrices(ctx, m, nil, holdings)
+	if err != nil {
+		b.onError(m, errors.Wrap(err, "error while retriving prices"))
+		return
+	}
+	positions, _, missing, err := b.rubPositions(ctx, nil, holdings, infos)
+	if err != nil {
+		b.onError(m, err)
+		return
+	}
+
+	var (
+		reply strings.Builder
+		total float64
+	)
+	reply.WriteString("Ваши бумаги:\n")
+	for _, p := range positions {
+		value := p.Held * p.Price
+		total += value
+		reply.WriteString(fmt.Sprintf("%s - %.0f шт. (на %.2f рублей)\n", tickerOf(p.ID), p.Held, value))
+	}
+	reply.WriteString(fmt.Sprintf("\nВсего на %.2f рублей\n", total))
+	reply.WriteString(describeMissing(missing))
+	reply.WriteString(describePrices(infos))
+	b.reply(m, reply.String())
+}
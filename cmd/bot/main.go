@@ -8,6 +8,7 @@ import (
 	"os"
 	"os/signal"
 	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
@@ -26,8 +27,26 @@ type config struct {
 	WebHookURL string `json:"web_hook_url"`
 	TLSKey     string `json:"tls_key"`
 	TLSCert    string `json:"tls_cert"`
+	// PriceSource is one of moex.PriceSource values, previous close by default
+	PriceSource string `json:"price_source"`
+	// Cache is where prices are cached: memory (default), badger or redis
+	Cache string `json:"cache"`
+	// CachePath is a directory of badger cache, it must differ from StorePath
+	CachePath string `json:"cache_path"`
+	// Holidays are weekdays when exchange doesn't work in 2006-01-02 format, prices are not refreshed on them
+	Holidays []string `json:"holidays"`
+	// Boards are MOEX boards prices are loaded from in engine/market/board format, moex.DefaultBoards by default
+	Boards []string `json:"boards"`
+	// DiscoverBoards loads all primary boards of stock and currency markets instead of Boards
+	DiscoverBoards bool `json:"discover_boards"`
 }
 
+const (
+	cacheMemory = "memory"
+	cacheBadger = "badger"
+	cacheRedis  = "redis"
+)
+
 func main() {
 	if err := run(); err != nil {
 		log.Println(err)
@@ -60,33 +79,48 @@ func run() error {
 	}()
 	log.Println("[INFO] opened storage")
 
-	redisCLI := redis.NewClient(&redis.Options{
-		Addr: cfg.RedisAddr,
-	})
+	priceCache, closeCache, err := newPriceCache(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer closeCache()
 
-	if err := redisCLI.Ping(ctx).Err(); err != nil {
-		return errors.Wrap(err, "error while pinging redis server")
+	schedule := moex.DefaultSchedule()
+	schedule.Holidays, err = moex.ParseHolidays(cfg.Holidays)
+	if err != nil {
+		return err
 	}
-	log.Println("[INFO] connected to redis on ", cfg.RedisAddr)
-	defer func() {
-		cerr := redisCLI.Close()
-		if cerr != nil {
-			log.Println("[ERROR] error while closing redis connection: ", cerr.Error())
-			return
-		}
-		log.Println("[INFO] closed redis connection")
-	}()
 
-	redisCache := cache.New(&cache.Options{
-		Redis: redisCLI,
+	priceSource := moex.PriceSource(cfg.PriceSource)
+	if priceSource != "" && !priceSource.IsValid() {
+		return errors.Errorf("unknown price source %q", cfg.PriceSource)
+	}
+	boards := make([]moex.Board, 0, len(cfg.Boards))
+	for _, s := range cfg.Boards {
+		b, err := moex.ParseBoard(s)
+		if err != nil {
+			return err
+		}
+		boards = append(boards, b)
+	}
+	api := moex.New(moex.Opts{
+		Cache:          priceCache,
+		PriceSource:    priceSource,
+		Boards:         boards,
+		DiscoverBoards: cfg.DiscoverBoards,
 	})
 
-	api := moex.New(moex.Opts{Cache: redisCache})
-
 	if err := api.UpdateCache(ctx); err != nil {
 		return errors.Wrap(err, "error while updating cache")
 	}
 
+	api.StartRefresher(schedule)
+	defer func() {
+		api.Stop()
+		log.Println("[INFO] stopped price refresher")
+	}()
+	log.Println("[INFO] started price refresher")
+
 	b, err := NewBot(&Opts{
 		Token:      cfg.Token,
 		Timeout:    time.Duration(cfg.TimeoutSec) * time.Second,
@@ -99,6 +133,7 @@ func run() error {
 		return errors.Wrap(err, "error while initializing bot")
 	}
 
+	b.StartScheduler()
 	go b.Start()
 	defer func() {
 		b.Stop()
@@ -115,6 +150,47 @@ func run() error {
 	return nil
 }
 
+// newPriceCache returns cache selected in config and function releasing its resources
+func newPriceCache(ctx context.Context, cfg *config) (moex.PriceCache, func(), error) {
+	switch cfg.Cache {
+	case "", cacheMemory:
+		return moex.NewMemoryCache(moex.DefaultMemoryCacheSize), func() {}, nil
+	case cacheBadger:
+		c, err := moex.OpenBadgerCache(cfg.CachePath)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "error while opening badger cache")
+		}
+		log.Println("[INFO] opened badger cache")
+		return c, func() {
+			if cerr := c.Close(); cerr != nil {
+				log.Println("[ERROR] error while closing badger cache: ", cerr.Error())
+				return
+			}
+			log.Println("[INFO] closed badger cache")
+		}, nil
+	case cacheRedis:
+		redisCLI := redis.NewClient(&redis.Options{
+			Addr: cfg.RedisAddr,
+		})
+		if err := redisCLI.Ping(ctx).Err(); err != nil {
+			return nil, nil, errors.Wrap(err, "error while pinging redis server")
+		}
+		log.Println("[INFO] connected to redis on ", cfg.RedisAddr)
+		redisCache := cache.New(&cache.Options{
+			Redis: redisCLI,
+		})
+		return moex.NewRedisCache(redisCache), func() {
+			if cerr := redisCLI.Close(); cerr != nil {
+				log.Println("[ERROR] error while closing redis connection: ", cerr.Error())
+				return
+			}
+			log.Println("[INFO] closed redis connection")
+		}, nil
+	default:
+		return nil, nil, errors.Errorf("unknown cache %q", cfg.Cache)
+	}
+}
+
 func readConfig(path string) (*config, error) {
 	// TODO use lib for configs
 	var cfg config
@@ -122,14 +198,24 @@ func readConfig(path string) (*config, error) {
 	case "":
 		timeout, _ := strconv.Atoi(os.Getenv("TIMEOUT_SECONDS"))
 		cfg = config{
-			Token:      os.Getenv("TOKEN"),
-			TimeoutSec: timeout,
-			StorePath:  os.Getenv("STORE_PATH"),
-			RedisAddr:  os.Getenv("REDIS_ADDR"),
-			WebHookURL: os.Getenv("WEBHOOKURL"),
-			TLSKey:     os.Getenv("TLSKEY"),
-			TLSCert:    os.Getenv("TLSCERT"),
+			Token:       os.Getenv("TOKEN"),
+			TimeoutSec:  timeout,
+			StorePath:   os.Getenv("STORE_PATH"),
+			RedisAddr:   os.Getenv("REDIS_ADDR"),
+			WebHookURL:  os.Getenv("WEBHOOKURL"),
+			TLSKey:      os.Getenv("TLSKEY"),
+			TLSCert:     os.Getenv("TLSCERT"),
+			PriceSource: os.Getenv("PRICE_SOURCE"),
+			Cache:       os.Getenv("CACHE"),
+			CachePath:   os.Getenv("CACHE_PATH"),
+		}
+		if holidays := os.Getenv("HOLIDAYS"); holidays != "" {
+			cfg.Holidays = strings.Split(holidays, ",")
+		}
+		if boards := os.Getenv("BOARDS"); boards != "" {
+			cfg.Boards = strings.Split(boards, ",")
 		}
+		cfg.DiscoverBoards, _ = strconv.ParseBool(os.Getenv("DISCOVER_BOARDS"))
 	default:
 		f, err := os.Open(path)
 		if err != nil {
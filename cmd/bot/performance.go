@@ -0,0 +1,312 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pechorka/whattobuy/moex"
+	"github.com/pechorka/whattobuy/performance"
+	"github.com/pechorka/whattobuy/store"
+	"github.com/pkg/errors"
+	"golang.org/x/sync/errgroup"
+
+	tb "gopkg.in/tucnak/telebot.v2"
+)
+
+// performancePeriods are periods /performance accepts, each one returns start of the period ending now
+var performancePeriods = map[string]func(now time.Time) time.Time{
+	"1M":  func(now time.Time) time.Time { return now.AddDate(0, -1, 0) },
+	"3M":  func(now time.Time) time.Time { return now.AddDate(0, -3, 0) },
+	"YTD": func(now time.Time) time.Time { return time.Date(now.Year(), 1, 1, 0, 0, 0, 0, now.Location()) },
+	"1Y":  func(now time.Time) time.Time { return now.AddDate(-1, 0, 0) },
+}
+
+const defaultPerformancePeriod = "1M"
+
+// historyMargin is how many days before the period prices are loaded to find the last trading day before it,
+// there are no trades during long holidays
+const historyMargin = 14
+
+// tradeDateLayout keeps dates of trading days comparable as strings
+const tradeDateLayout = "2006-01-02"
+
+// datedPrice is a price of one security in rubles at the end of a trading day
+type datedPrice struct {
+	Date  string
+	Price float64
+}
+
+func (b *Bot) onPerformance(m *tb.Message) {
+	portfolio, ok := b.activePortfolio(m)
+	if !ok {
+		return
+	}
+	period := strings.ToUpper(strings.TrimSpace(m.Payload))
+	if period == "" {
+		period = defaultPerformancePeriod
+	}
+	startOf, ok := performancePeriods[period]
+	if !ok {
+		b.onInvalidInput(m, errors.Errorf("Неизвестный период %s, доступны 1M, 3M, YTD и 1Y", m.Payload))
+		return
+	}
+	now := time.Now().In(moex.Moscow)
+	start := startOf(now)
+
+	holdings, err := b.store.GetHoldings(m.Sender.ID, portfolio)
+	if err != nil {
+		b.onError(m, errors.Wrap(err, "error while retriving holdings"))
+		return
+	}
+	trades, err := b.store.Trades(m.Sender.ID, portfolio, start)
+	if err != nil {
+		b.onError(m, errors.Wrap(err, "error while retriving trades"))
+		return
+	}
+	traded := make(store.Holdings)
+	for _, t := range trades {
+		traded[t.SecID] += t.Quantity
+	}
+	if len(holdings) == 0 && len(traded) == 0 {
+		b.reply(m, "Вы ещё не указали, какие бумаги у вас есть. Добавляйте их командой '/hold тикер количество'")
+		return
+	}
+
+	ctx := context.TODO()
+	infos, err := b.loadSecurityPrices(ctx, m, nil, holdings, traded)
+	if err != nil {
+		b.onError(m, errors.Wrap(err, "error while retriving prices"))
+		return
+	}
+	var missing []string
+	for _, secid := range secids(holdings, traded) {
+		if _, ok := infos[secid]; !ok {
+			missing = append(missing, tickerOf(secid))
+		}
+	}
+	prices, err := b.rubHistory(ctx, infos, start.AddDate(0, 0, -historyMargin), now)
+	if err != nil {
+		b.onError(m, err)
+		return
+	}
+
+	report, err := performance.Analyze(performanceDays(start, holdings, trades, prices))
+	switch {
+	case errors.Is(err, performance.ErrPeriodTooShort):
+		b.reply(m, "За этот период ещё нет цен, попробуйте период подлиннее")
+		return
+	case errors.Is(err, performance.ErrNoCapital):
+		b.reply(m, "За этот период в портфеле не было бумаг")
+		return
+	case err != nil:
+		b.onError(m, errors.Wrap(err, "error while calculating performance"))
+		return
+	}
+	b.reply(m, describePerformance(portfolio, period, report, missing))
+}
+
+// rubHistory loads daily prices of securities in rubles, prices in foreign currencies are converted
+// by the exchange rate of the same day
+func (b *Bot) rubHistory(ctx context.Context, infos map[string]moex.StockInfo, from, till time.Time) (map[string][]datedPrice, error) {
+	var (
+		mu     sync.Mutex
+		prices = make(map[string][]datedPrice, len(infos))
+		rates  = make(map[string][]datedPrice)
+	)
+	load := func(ctx context.Context, info moex.StockInfo, res map[string][]datedPrice, key string) error {
+		days, err := b.mapi.History(ctx, info.Ref(), from, till)
+		if err != nil {
+			return errors.Wrapf(err, "error while loading history of %s", info.Ref())
+		}
+		series := closePrices(info, days)
+		mu.Lock()
+		res[key] = series
+		mu.Unlock()
+		return nil
+	}
+
+	currencies := make(map[string]moex.SecurityRef)
+	for _, info := range infos {
+		if info.Currency == "" || info.Currency == moex.CurrencyRUB {
+			continue
+		}
+		ref, ok := moex.CurrencyRef(info.Currency)
+		if !ok {
+			return nil, errors.Errorf("no exchange rate for %s", info.Currency)
+		}
+		currencies[info.Currency] = ref
+	}
+
+	gr, gctx := errgroup.WithContext(ctx)
+	for secid, info := range infos {
+		secid, info := secid, info
+		gr.Go(func() error {
+			return load(gctx, info, prices, secid)
+		})
+	}
+	for currency, ref := range currencies {
+		currency, ref := currency, ref
+		gr.Go(func() error {
+			rate, err := b.mapi.Get(gctx, ref)
+			if err != nil {
+				return errors.Wrapf(err, "error while getting exchange rate for %s", currency)
+			}
+			return load(gctx, *rate, rates, currency)
+		})
+	}
+	if err := gr.Wait(); err != nil {
+		return nil, err
+	}
+
+	for secid, info := range infos {
+		series, ok := rates[info.Currency]
+		if !ok {
+			continue
+		}
+		converted := make([]datedPrice, 0, len(prices[secid]))
+		for _, p := range prices[secid] {
+			converted = append(converted, datedPrice{Date: p.Date, Price: p.Price * priceAt(series, p.Date)})
+		}
+		prices[secid] = converted
+	}
+	return prices, nil
+}
+
+// closePrices returns what one security cost at the end of trading days, days without price are skipped
+func closePrices(info moex.StockInfo, days []moex.HistoryDay) []datedPrice {
+	res := make([]datedPrice, 0, len(days))
+	for _, d := range days {
+		price := d.Close
+		if price == 0 {
+			price = d.LegalClose
+		}
+		if price == 0 {
+			price = d.WAPrice
+		}
+		if price == 0 {
+			continue
+		}
+		if info.IsBond() {
+			face := d.FaceValue
+			if face == 0 {
+				face = info.FaceValue
+			}
+			price = price*face/100 + d.AccruedInt
+		}
+		res = append(res, datedPrice{Date: d.TradeDate.In(moex.Moscow).Format(tradeDateLayout), Price: price})
+	}
+	return res
+}
+
+// priceAt returns the last price not after the date. The first price is used for dates before it.
+func priceAt(series []datedPrice, date string) float64 {
+	if len(series) == 0 {
+		return 0
+	}
+	i := sort.Search(len(series), func(i int) bool { return series[i].Date > date })
+	if i == 0 {
+		return series[0].Price
+	}
+	return series[i-1].Price
+}
+
+// performanceDays values positions at the end of trading days, starting with the last one not after start.
+// Quantities at start are holdings without trades made after it, each trade is a flow of the first trading day
+// not before it. Trades without price are valued by close of that day. Securities without prices are skipped.
+func performanceDays(start time.Time, holdings store.Holdings, trades []store.Trade, prices map[string][]datedPrice) []performance.Day {
+	unique := make(map[string]struct{})
+	for _, series := range prices {
+		for _, p := range series {
+			unique[p.Date] = struct{}{}
+		}
+	}
+	dates := make([]string, 0, len(unique))
+	for date := range unique {
+		dates = append(dates, date)
+	}
+	sort.Strings(dates)
+	startDate := start.In(moex.Moscow).Format(tradeDateLayout)
+	first := sort.SearchStrings(dates, startDate)
+	if first == len(dates) || dates[first] != startDate {
+		first--
+	}
+	if first < 0 { // nothing was traded before the period
+		first = 0
+	}
+	dates = dates[first:]
+	if len(dates) == 0 {
+		return nil
+	}
+
+	qty := make(map[string]float64)
+	for secid, q := range holdings {
+		if _, ok := prices[secid]; ok {
+			qty[secid] = q
+		}
+	}
+	byDay := make([][]store.Trade, len(dates))
+	for _, t := range trades {
+		if _, ok := prices[t.SecID]; !ok {
+			continue
+		}
+		qty[t.SecID] -= t.Quantity
+		i := sort.SearchStrings(dates, t.Time.In(moex.Moscow).Format(tradeDateLayout))
+		if i >= len(dates) { // no trades on exchange since then
+			i = len(dates) - 1
+		}
+		if i < 1 && len(dates) > 1 { // flows of the first day are not a part of the period
+			i = 1
+		}
+		byDay[i] = append(byDay[i], t)
+	}
+
+	days := make([]performance.Day, 0, len(dates))
+	for i, date := range dates {
+		day, _ := time.ParseInLocation(tradeDateLayout, date, moex.Moscow)
+		d := performance.Day{Date: day, Values: make(map[string]float64), Flows: make(map[string]float64)}
+		for _, t := range byDay[i] {
+			qty[t.SecID] += t.Quantity
+			price := t.Price
+			if price == 0 {
+				price = priceAt(prices[t.SecID], date)
+			}
+			d.Flows[t.SecID] += t.Quantity * price
+		}
+		for secid, q := range qty {
+			if q != 0 {
+				d.Values[secid] = q * priceAt(prices[secid], date)
+			}
+		}
+		days = append(days, d)
+	}
+	return days
+}
+
+func describePerformance(portfolio, period string, r performance.Report, missing []string) string {
+	var reply strings.Builder
+	reply.WriteString(fmt.Sprintf("Доходность портфеля %s за %s (с %s по %s):\n", portfolio, period, r.Start.Format("02.01.2006"), r.End.Format("02.01.2006")))
+	reply.WriteString(fmt.Sprintf("Стоимость: %.2f → %.2f рублей\n", r.StartValue, r.EndValue))
+	switch {
+	case r.NetFlow > 0:
+		reply.WriteString(fmt.Sprintf("Докуплено на %.2f рублей\n", r.NetFlow))
+	case r.NetFlow < 0:
+		reply.WriteString(fmt.Sprintf("Продано на %.2f рублей\n", -r.NetFlow))
+	}
+	reply.WriteString(fmt.Sprintf("Результат: %+.2f рублей (%+.2f%%)\n", r.Gain, r.Return*100))
+	reply.WriteString(fmt.Sprintf("Взвешенная по времени доходность (TWR): %+.2f%%\n", r.TWR*100))
+	reply.WriteString(fmt.Sprintf("Взвешенная по деньгам доходность (MWR): %+.2f%% (%+.2f%% годовых)\n", r.MWR*100, r.AnnualMWR*100))
+
+	reply.WriteString("\nВклад бумаг в результат:\n")
+	for _, c := range r.Contributions {
+		reply.WriteString(fmt.Sprintf("%s - %+.2f рублей (%+.2f%%)\n", tickerOf(c.ID), c.Gain, c.Contribution*100))
+	}
+	if len(missing) > 0 {
+		reply.WriteString(fmt.Sprintf("\nНет цен бумаг %s, они не учтены", strings.Join(missing, ", ")))
+	}
+	reply.WriteString("\nДивиденды и купоны в расчёте не учитываются")
+	return reply.String()
+}
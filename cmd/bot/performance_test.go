@@ -0,0 +1,46 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/pechorka/whattobuy/moex"
+	"github.com/pechorka/whattobuy/performance"
+	"github.com/pechorka/whattobuy/store"
+)
+
+func TestPerformanceDays(t *testing.T) {
+	at := func(day, hour int) time.Time {
+		return time.Date(2021, 11, day, hour, 0, 0, 0, moex.Moscow)
+	}
+	prices := map[string][]datedPrice{
+		"TQBR:A": {{"2021-11-01", 10}, {"2021-11-02", 11}, {"2021-11-05", 12}, {"2021-11-08", 13}},
+		// listed during the period
+		"TQBR:B": {{"2021-11-05", 100}, {"2021-11-08", 110}},
+	}
+	holdings := store.Holdings{"TQBR:A": 10, "TQBR:B": 1}
+	trades := []store.Trade{
+		// made on holiday without price, valued by close of the next trading day
+		{Time: at(4, 15), SecID: "TQBR:B", Quantity: 1},
+		{Time: at(6, 12), SecID: "TQBR:A", Quantity: 5, Price: 11.5},
+		// there are no prices of unknown security, it is skipped
+		{Time: at(6, 12), SecID: "TQBR:C", Quantity: 1, Price: 1},
+	}
+
+	got := performanceDays(at(3, 12), holdings, trades, prices)
+	want := []performance.Day{
+		// the last trading day before the period
+		{Date: at(2, 0), Values: map[string]float64{"TQBR:A": 55}, Flows: map[string]float64{}},
+		{Date: at(5, 0), Values: map[string]float64{"TQBR:A": 60, "TQBR:B": 100}, Flows: map[string]float64{"TQBR:B": 100}},
+		{Date: at(8, 0), Values: map[string]float64{"TQBR:A": 130, "TQBR:B": 110}, Flows: map[string]float64{"TQBR:A": 57.5}},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("expected %d days, got %+v", len(want), got)
+	}
+	for i := range want {
+		if !got[i].Date.Equal(want[i].Date) || !reflect.DeepEqual(got[i].Values, want[i].Values) || !reflect.DeepEqual(got[i].Flows, want[i].Flows) {
+			t.Errorf("day %d: expected %+v, got %+v", i, want[i], got[i])
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pechorka/whattobuy/store"
+	"github.com/pkg/errors"
+
+	tb "gopkg.in/tucnak/telebot.v2"
+)
+
+// readPortfolioName returns normalized portfolio name from command payload.
+// False is returned when user is already notified about invalid input.
+func (b *Bot) readPortfolioName(m *tb.Message, usage string) (string, bool) {
+	name := strings.ToLower(strings.TrimSpace(m.Payload))
+	if !store.ValidPortfolioName(name) {
+		b.onInvalidInput(m, errors.Errorf("Название портфеля может содержать до 32 букв, цифр или дефисов. Ожидается формат '%s'", usage))
+		return "", false
+	}
+	return name, true
+}
+
+func (b *Bot) onNewPortfolio(m *tb.Message) {
+	name, ok := b.readPortfolioName(m, "/new название")
+	if !ok {
+		return
+	}
+	err := b.store.CreatePortfolio(m.Sender.ID, name)
+	if err == store.ErrPortfolioExists {
+		b.onInvalidInput(m, errors.Errorf("Портфель %s уже есть, переключиться на него можно командой /switch %s", name, name))
+		return
+	}
+	if err != nil {
+		b.onError(m, errors.Wrap(err, "error while creating portfolio"))
+		return
+	}
+	b.reply(m, fmt.Sprintf("Создан портфель %s, теперь все команды работают с ним. Начните вводить его структуру сообщениями вида 'тикер процент'", name))
+}
+
+func (b *Bot) onSwitchPortfolio(m *tb.Message) {
+	name, ok := b.readPortfolioName(m, "/switch название")
+	if !ok {
+		return
+	}
+	err := b.store.SwitchPortfolio(m.Sender.ID, name)
+	if err == store.ErrPortfolioNotFound {
+		b.onInvalidInput(m, errors.Errorf("Портфеля %s нет. Список портфелей можно посмотреть командой /list", name))
+		return
+	}
+	if err != nil {
+		b.onError(m, errors.Wrap(err, "error while switching portfolio"))
+		return
+	}
+	b.reply(m, fmt.Sprintf("Теперь все команды работают с портфелем %s", name))
+}
+
+func (b *Bot) onListPortfolios(m *tb.Message) {
+	active, ok := b.activePortfolio(m)
+	if !ok {
+		return
+	}
+	portfolios, err := b.store.ListPortfolios(m.Sender.ID)
+	if err != nil {
+		b.onError(m, errors.Wrap(err, "error while listing portfolios"))
+		return
+	}
+	var reply strings.Builder
+	reply.WriteString("Ваши портфели:\n")
+	for _, name := range portfolios {
+		if name == active {
+			reply.WriteString(fmt.Sprintf("%s (активный)\n", name))
+			continue
+		}
+		reply.WriteString(name + "\n")
+	}
+	reply.WriteString("\nСоздать новый: /new название, переключиться: /switch название, удалить: /delete название")
+	b.reply(m, reply.String())
+}
+
+func (b *Bot) onDeletePortfolio(m *tb.Message) {
+	name, ok := b.readPortfolioName(m, "/delete название")
+	if !ok {
+		return
+	}
+	err := b.store.DeletePortfolio(m.Sender.ID, name)
+	switch err {
+	case nil:
+		b.reply(m, fmt.Sprintf("Портфель %s удалён", name))
+	case store.ErrPortfolioNotFound:
+		b.onInvalidInput(m, errors.Errorf("Портфеля %s нет. Список портфелей можно посмотреть командой /list", name))
+	case store.ErrPortfolioIsActive:
+		b.onInvalidInput(m, errors.Errorf("Портфель %s сейчас активный. Переключитесь на другой командой /switch, чтобы его удалить", name))
+	default:
+		b.onError(m, errors.Wrap(err, "error while deleting portfolio"))
+	}
+}
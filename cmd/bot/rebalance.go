@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pechorka/whattobuy/rebalance"
+	"github.com/pkg/errors"
+
+	tb "gopkg.in/tucnak/telebot.v2"
+)
+
+const rebalanceUsage = "Ожидается формат '/rebalance [допуск] [nosell] [сумма]'. Допуск в процентах: позиции, доля которых отличается от целевой не больше чем на допуск, не трогаются. nosell запрещает продажи, тогда на покупки тратится только сумма"
+
+// parseRebalanceOpts reads optional tolerance, nosell flag and additional cash in this order
+func parseRebalanceOpts(payload string) (rebalance.Opts, error) {
+	var (
+		opts    rebalance.Opts
+		numbers int
+	)
+	for _, arg := range strings.Fields(payload) {
+		if strings.EqualFold(arg, "nosell") {
+			opts.NoSells = true
+			continue
+		}
+		v, err := strconv.ParseFloat(strings.TrimSuffix(arg, "%"), 64)
+		if err != nil || v < 0 {
+			return opts, errors.Errorf("не понятно, что значит %q. %s", arg, rebalanceUsage)
+		}
+		switch numbers {
+		case 0:
+			opts.Tolerance = v
+		case 1:
+			opts.Cash = v
+		default:
+			return opts, errors.New(rebalanceUsage)
+		}
+		numbers++
+	}
+	return opts, nil
+}
+
+func (b *Bot) onRebalance(m *tb.Message) {
+	portfolio, ok := b.activePortfolio(m)
+	if !ok {
+		return
+	}
+	if !b.isUserFinished(m, portfolio) {
+		b.reply(m, "У вас еще не заполнен портфель или вы не ввели команду /finish")
+		return
+	}
+	opts, err := parseRebalanceOpts(m.Payload)
+	if err != nil {
+		b.onInvalidInput(m, err)
+		return
+	}
+	partfolio, err := b.store.GetPartfolio(m.Sender.ID, portfolio)
+	if err != nil {
+		b.onError(m, errors.Wrap(err, "error while retriving portfolio"))
+		return
+	}
+	holdings, err := b.store.GetHoldings(m.Sender.ID, portfolio)
+	if err != nil {
+		b.onError(m, errors.Wrap(err, "error while retriving holdings"))
+		return
+	}
+	if len(holdings) == 0 {
+		b.reply(m, "Для ребалансировки нужно знать, какие бумаги у вас есть. Укажите их командой '/hold тикер количество'")
+		return
+	}
+	ctx := context.TODO()
+	infos, err := b.loadSecurityPrices(ctx, m, partfolio, holdings)
+	if err != nil {
+		b.onError(m, errors.Wrap(err, "error while retriving prices"))
+		return
+	}
+	positions, _, missing, err := b.rubPositions(ctx, partfolio, holdings, infos)
+	if err != nil {
+		b.onError(m, err)
+		return
+	}
+	res := rebalance.Rebalance(positions, opts)
+
+	var reply strings.Builder
+	reply.WriteString(fmt.Sprintf("Ребалансировка с допуском %.2f%%", opts.Tolerance))
+	if opts.NoSells {
+		reply.WriteString(" без продаж")
+	}
+	reply.WriteString(":\n")
+	for _, trade := range res.Trades {
+		var action string
+		switch {
+		case trade.Lots > 0:
+			action = fmt.Sprintf("купить %d лотов", trade.Lots)
+		case trade.Lots < 0:
+			action = fmt.Sprintf("продать %d лотов", -trade.Lots)
+		default:
+			action = "без изменений"
+		}
+		reply.WriteString(fmt.Sprintf("%s - %s, доля %.2f%% → %.2f%% (цель %.2f%%)\n", tickerOf(trade.ID), action, trade.Before, trade.After, partfolio[trade.ID]))
+	}
+	reply.WriteString(fmt.Sprintf("\nОстанется %.2f рублей\n", res.Left))
+	reply.WriteString(describeMissing(missing))
+	reply.WriteString(describePrices(infos))
+	b.reply(m, reply.String())
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pechorka/whattobuy/moex"
+	"github.com/pechorka/whattobuy/store"
+	"github.com/pkg/errors"
+
+	tb "gopkg.in/tucnak/telebot.v2"
+)
+
+const (
+	// planHour is Moscow hour when plans are run, exchange is already open by then
+	planHour = 10
+	// planCheckInterval is how often scheduler looks for due plans
+	planCheckInterval = time.Minute
+	// failed plan is retried after planRetryDelay, the delay doubles with every attempt.
+	// Plan is skipped till the next month after planMaxAttempts, which all fit into the day of the run.
+	planRetryDelay  = 5 * time.Minute
+	planMaxAttempts = 5
+)
+
+const scheduleUsage = "Ожидается формат '/schedule monthly день сумма', например '/schedule monthly 15 30000'. Выключить: '/schedule off'"
+
+// btnConfirmBuy records suggested purchase to holdings, data of pressed button is id of store.Purchase
+var btnConfirmBuy = tb.Btn{Unique: "confirm_buy"}
+
+func (b *Bot) onSchedule(m *tb.Message) {
+	portfolio, ok := b.activePortfolio(m)
+	if !ok {
+		return
+	}
+	fields := strings.Fields(m.Payload)
+	switch {
+	case len(fields) == 0:
+		b.showPlan(m, portfolio)
+	case len(fields) == 1 && (strings.EqualFold(fields[0], "off") || strings.EqualFold(fields[0], "выключить")):
+		if err := b.store.DeletePlan(m.Sender.ID, portfolio); err != nil {
+			b.onError(m, errors.Wrap(err, "error while deleting plan"))
+			return
+		}
+		b.reply(m, fmt.Sprintf("Ежемесячные покупки в портфель %s выключены", portfolio))
+	case len(fields) == 3 && (strings.EqualFold(fields[0], "monthly") || strings.EqualFold(fields[0], "ежемесячно")):
+		b.setPlan(m, portfolio, fields[1], fields[2])
+	default:
+		b.onInvalidInput(m, errors.New(scheduleUsage))
+	}
+}
+
+func (b *Bot) setPlan(m *tb.Message, portfolio, dayStr, amountStr string) {
+	if !b.isUserFinished(m, portfolio) {
+		b.reply(m, "У вас еще не заполнен портфель или вы не ввели команду /finish")
+		return
+	}
+	day, err := strconv.Atoi(dayStr)
+	if err != nil || day < 1 || day > 31 {
+		b.onInvalidInput(m, errors.Errorf("День месяца должен быть числом от 1 до 31, а сейчас %s", dayStr))
+		return
+	}
+	amount, err := strconv.ParseFloat(strings.Replace(amountStr, ",", ".", 1), 64)
+	if err != nil || amount <= 0 {
+		b.onInvalidInput(m, errors.Errorf("Сумма на покупку должна быть положительным числом, а сейчас %s", amountStr))
+		return
+	}
+	plan := store.Plan{Day: day, Amount: amount, NextRun: nextMonthlyRun(day, time.Now())}
+	if err := b.store.SetPlan(m.Sender.ID, portfolio, plan); err != nil {
+		b.onError(m, errors.Wrap(err, "error while saving plan"))
+		return
+	}
+	b.reply(m, describePlan(portfolio, plan)+". В этот день пришлю, что купить по текущим ценам, и кнопку, чтобы записать покупку в портфель")
+}
+
+func (b *Bot) showPlan(m *tb.Message, portfolio string) {
+	plan, ok, err := b.store.GetPlan(m.Sender.ID, portfolio)
+	if err != nil {
+		b.onError(m, errors.Wrap(err, "error while retriving plan"))
+		return
+	}
+	if !ok {
+		b.reply(m, fmt.Sprintf("Ежемесячные покупки в портфель %s не запланированы. %s", portfolio, scheduleUsage))
+		return
+	}
+	b.reply(m, describePlan(portfolio, plan)+". Выключить: /schedule off")
+}
+
+func describePlan(portfolio string, plan store.Plan) string {
+	return fmt.Sprintf("Покупка в портфель %s на %.2f рублей %d числа каждого месяца, следующая %s",
+		portfolio, plan.Amount, plan.Day, plan.NextRun.In(moex.Moscow).Format("02.01.2006 15:04"))
+}
+
+// nextMonthlyRun returns the first run after the time. Day is moved to the last day of short months.
+func nextMonthlyRun(day int, after time.Time) time.Time {
+	after = after.In(moex.Moscow)
+	for i := 0; ; i++ {
+		month := time.Date(after.Year(), after.Month()+time.Month(i), 1, planHour, 0, 0, 0, moex.Moscow)
+		lastDay := month.AddDate(0, 1, -1).Day()
+		if day < lastDay {
+			lastDay = day
+		}
+		if run := month.AddDate(0, 0, lastDay-1); run.After(after) {
+			return run
+		}
+	}
+}
+
+// StartScheduler runs due plans until Stop is called.
+// Plans are kept in store, so runs missed while bot was down are made after start.
+func (b *Bot) StartScheduler() {
+	b.schedulerDone = make(chan struct{})
+	go func() {
+		defer close(b.schedulerDone)
+		ticker := time.NewTicker(planCheckInterval)
+		defer ticker.Stop()
+		for {
+			b.runDuePlans(b.ctx)
+			select {
+			case <-b.ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (b *Bot) runDuePlans(ctx context.Context) {
+	now := time.Now()
+	plans, err := b.store.DuePlans(now)
+	if err != nil {
+		log.Printf("[ERROR] while retriving due plans: %v", err)
+		return
+	}
+	for _, p := range plans {
+		if ctx.Err() != nil {
+			return
+		}
+		// attempt is saved first, so that plan is not sent twice if bot stops in the middle
+		if err := b.store.MarkPlanAttempt(p.UserID, p.Portfolio, now.Add(planRetryIn(p.Attempts))); err != nil {
+			log.Printf("[ERROR] while saving attempt of plan of user %d: %v", p.UserID, err)
+			continue
+		}
+		if err := b.runPlan(ctx, p); err != nil {
+			log.Printf("[ERROR] while running plan of user %d, attempt %d: %v", p.UserID, p.Attempts+1, err)
+			if p.Attempts+1 < planMaxAttempts {
+				continue
+			}
+			log.Printf("[ERROR] plan of user %d is skipped till the next month after %d attempts", p.UserID, planMaxAttempts)
+		}
+		if err := b.store.SetPlanNextRun(p.UserID, p.Portfolio, nextMonthlyRun(p.Day, now)); err != nil {
+			log.Printf("[ERROR] while moving plan of user %d: %v", p.UserID, err)
+		}
+	}
+}
+
+// planRetryIn returns delay before the next attempt to run the plan after the given number of failed ones
+func planRetryIn(attempts int) time.Duration {
+	return planRetryDelay << attempts
+}
+
+// runPlan sends what to buy for the plan amount with a button to confirm the purchase
+func (b *Bot) runPlan(ctx context.Context, p store.ScheduledPlan) error {
+	user := &tb.User{ID: p.UserID}
+	finished, err := b.store.IsUserFinished(p.UserID, p.Portfolio)
+	if err != nil {
+		return errors.Wrap(err, "error while checking user state")
+	}
+	if !finished {
+		_, err := b.telebot.Send(user, fmt.Sprintf("Сегодня запланирована покупка в портфель %s на %.2f рублей, но портфель не заполнен. Введите /finish, когда закончите ввод", p.Portfolio, p.Amount))
+		return err
+	}
+
+	text, purchase, err := b.buyBreakdown(ctx, nil, p.UserID, p.Portfolio, p.Amount)
+	if err != nil {
+		return err
+	}
+	text = fmt.Sprintf("Запланированная покупка в портфель %s на %.2f рублей по текущим ценам:\n\n%s", p.Portfolio, p.Amount, text)
+	if len(purchase.Quantities) == 0 {
+		_, err := b.telebot.Send(user, text)
+		return err
+	}
+
+	purchase.ID = time.Now().UnixNano()
+	if err := b.store.SetPendingPurchase(p.UserID, p.Portfolio, purchase); err != nil {
+		return errors.Wrap(err, "error while saving purchase")
+	}
+	markup := &tb.ReplyMarkup{}
+	markup.Inline(markup.Row(markup.Data("Купил, записать в портфель", btnConfirmBuy.Unique, strconv.FormatInt(purchase.ID, 10))))
+	_, err = b.telebot.Send(user, text, markup)
+	return err
+}
+
+func (b *Bot) onConfirmBuy(c *tb.Callback) {
+	if err := b.telebot.Respond(c); err != nil {
+		log.Printf("[ERROR] while responding to callback: %v", err)
+	}
+	if _, err := b.telebot.EditReplyMarkup(c.Message, nil); err != nil {
+		log.Printf("[ERROR] while removing confirmation: %v", err)
+	}
+	m := *c.Message
+	m.Sender = c.Sender
+
+	id, err := strconv.ParseInt(c.Data, 10, 64)
+	if err != nil {
+		log.Printf("[ERROR] %v", errors.Errorf("unexpected callback data %q", c.Data))
+		return
+	}
+	portfolio, purchase, err := b.store.ConfirmPurchase(c.Sender.ID, id)
+	if errors.Is(err, store.ErrNoPendingPurchase) {
+		b.reply(&m, "Эта покупка уже записана или устарела. Бумаги можно указать вручную командой /hold")
+		return
+	}
+	if err != nil {
+		b.onError(&m, errors.Wrap(err, "error while recording purchase"))
+		return
+	}
+
+	bought := make([]string, 0, len(purchase.Quantities))
+	for secid, qty := range purchase.Quantities {
+		bought = append(bought, fmt.Sprintf("%s +%.0f", tickerOf(secid), qty))
+	}
+	sort.Strings(bought)
+	b.reply(&m, fmt.Sprintf("Покупка записана в портфель %s: %s. Исправить количество можно командой /hold", portfolio, strings.Join(bought, ", ")))
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pechorka/whattobuy/moex"
+)
+
+func TestNextMonthlyRun(t *testing.T) {
+	at := func(year int, month time.Month, day, hour int) time.Time {
+		return time.Date(year, month, day, hour, 0, 0, 0, moex.Moscow)
+	}
+	tests := []struct {
+		name  string
+		day   int
+		after time.Time
+		want  time.Time
+	}{
+		{name: "later this month", day: 15, after: at(2021, 11, 10, 12), want: at(2021, 11, 15, planHour)},
+		{name: "earlier on the same day", day: 15, after: at(2021, 11, 15, 9), want: at(2021, 11, 15, planHour)},
+		{name: "run time passed", day: 15, after: at(2021, 11, 15, planHour), want: at(2021, 12, 15, planHour)},
+		{name: "next year", day: 1, after: at(2021, 12, 20, 0), want: at(2022, 1, 1, planHour)},
+		{name: "short month", day: 31, after: at(2022, 2, 1, 0), want: at(2022, 2, 28, planHour)},
+		{name: "after short month", day: 31, after: at(2022, 2, 28, 12), want: at(2022, 3, 31, planHour)},
+		{name: "other time zone", day: 15, after: time.Date(2021, 11, 15, 7, 30, 0, 0, time.UTC), want: at(2021, 12, 15, planHour)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := nextMonthlyRun(tt.day, tt.after); !got.Equal(tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestPlanRetryIn(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{attempts: 0, want: 5 * time.Minute},
+		{attempts: 1, want: 10 * time.Minute},
+		{attempts: 4, want: 80 * time.Minute},
+	}
+	for _, tt := range tests {
+		if got := planRetryIn(tt.attempts); got != tt.want {
+			t.Errorf("attempts %d: expected %v, got %v", tt.attempts, tt.want, got)
+		}
+	}
+
+	// all retries are made on the day of the run, so that they do not mix with the next month
+	var total time.Duration
+	for i := 0; i < planMaxAttempts-1; i++ {
+		total += planRetryIn(i)
+	}
+	if planHour*time.Hour+total >= 24*time.Hour {
+		t.Errorf("expected retries to fit into the day of the run, they take %v", total)
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/pechorka/whattobuy/moex"
+	"github.com/pkg/errors"
+
+	tb "gopkg.in/tucnak/telebot.v2"
+)
+
+const suggestionsLimit = 3
+
+// buttons offered for unknown tickers, data of pressed button is "BOARD:SECID|value"
+var (
+	btnPickTarget  = tb.Btn{Unique: "pick_target"}
+	btnPickHolding = tb.Btn{Unique: "pick_hold"}
+)
+
+// btnPickBoard is offered for stored secid without board which became ambiguous, data is "SECID|BOARD:SECID"
+var btnPickBoard = tb.Btn{Unique: "pick_board"}
+
+// suggest tells that ticker is not resolved and offers securities to pick from as buttons.
+// Ambiguous ticker is offered to be picked on one of its boards, unknown one is searched for.
+// Value is what user typed along with ticker, it is applied when button is pressed.
+func (b *Bot) suggest(m *tb.Message, ticker string, value float64, reason error, btn tb.Btn) {
+	ctx := context.TODO()
+	var (
+		text    string
+		options []suggestion
+	)
+	var ambiguous *moex.AmbiguousError
+	if errors.As(reason, &ambiguous) {
+		var err error
+		if options, err = b.boardOptions(ctx, ambiguous); err != nil {
+			b.onError(m, err)
+			return
+		}
+		text = fmt.Sprintf("Бумага %s торгуется в нескольких режимах, выберите нужный:", ticker)
+	} else {
+		results, err := b.mapi.Search(ctx, ticker, suggestionsLimit)
+		if err != nil {
+			log.Printf("[ERROR] while searching %q: %v\n", ticker, err)
+		}
+		for _, r := range results {
+			options = append(options, suggestion{ref: r.Ref, text: fmt.Sprintf("%s - %s (%s)", r.Ref.SecID, r.ShortName, r.Ref.Board)})
+		}
+		text = fmt.Sprintf("Бумага %s не найдена. Возможно, вы имели в виду:", ticker)
+	}
+	if len(options) == 0 {
+		b.reply(m, fmt.Sprintf("Бумага %s не найдена", ticker))
+		return
+	}
+
+	markup := &tb.ReplyMarkup{}
+	rows := make([]tb.Row, 0, len(options))
+	for _, o := range options {
+		rows = append(rows, markup.Row(markup.Data(o.text, btn.Unique, o.ref.String(), strconv.FormatFloat(value, 'f', -1, 64))))
+	}
+	markup.Inline(rows...)
+	if _, err := b.telebot.Reply(m, text, markup); err != nil {
+		log.Printf("[ERROR] while replying: %v", err)
+	}
+}
+
+type suggestion struct {
+	ref  moex.SecurityRef
+	text string
+}
+
+// boardOptions describes boards ambiguous ticker is traded on
+func (b *Bot) boardOptions(ctx context.Context, ambiguous *moex.AmbiguousError) ([]suggestion, error) {
+	infos, err := b.mapi.GetMultiple(ctx, ambiguous.Candidates...)
+	if err != nil {
+		return nil, errors.Wrap(err, "error while retriving candidates")
+	}
+	options := make([]suggestion, 0, len(ambiguous.Candidates))
+	for _, ref := range ambiguous.Candidates {
+		info := infos[ref]
+		options = append(options, suggestion{ref: ref, text: fmt.Sprintf("%s - %s (%s, %s)", ref.SecID, info.ShortName, ref.Board, info.Currency)})
+	}
+	return options, nil
+}
+
+// askBoard asks user to pick board of stored secid, it is left out of calculations until then
+func (b *Bot) askBoard(m *tb.Message, secid string, ambiguous *moex.AmbiguousError) {
+	options, err := b.boardOptions(context.TODO(), ambiguous)
+	if err != nil {
+		log.Printf("[ERROR] while asking board of %s: %v", secid, err)
+		return
+	}
+	markup := &tb.ReplyMarkup{}
+	rows := make([]tb.Row, 0, len(options))
+	for _, o := range options {
+		rows = append(rows, markup.Row(markup.Data(o.text, btnPickBoard.Unique, secid, o.ref.String())))
+	}
+	markup.Inline(rows...)
+	text := fmt.Sprintf("Бумага %s из ваших портфелей теперь торгуется в нескольких режимах и не учитывается в расчётах. Выберите нужный:", secid)
+	if _, err := b.telebot.Reply(m, text, markup); err != nil {
+		log.Printf("[ERROR] while replying: %v", err)
+	}
+}
+
+// isUnresolved reports whether user has to pick security because typed ticker is unknown or ambiguous
+func isUnresolved(err error) bool {
+	var ambiguous *moex.AmbiguousError
+	return err == moex.ErrNotFound || errors.As(err, &ambiguous)
+}
+
+func (b *Bot) onPickTarget(c *tb.Callback) {
+	m, secid, value, ok := b.pickedSecurity(c)
+	if !ok {
+		return
+	}
+	m.Text = secid + " " + value
+	b.onText(m)
+}
+
+func (b *Bot) onPickHolding(c *tb.Callback) {
+	m, secid, value, ok := b.pickedSecurity(c)
+	if !ok {
+		return
+	}
+	m.Payload = secid + " " + value
+	b.onHold(m)
+}
+
+func (b *Bot) onPickBoard(c *tb.Callback) {
+	m, secid, ref, ok := b.pickedSecurity(c)
+	if !ok {
+		return
+	}
+	if err := b.store.ResolveSecurity(m.Sender.ID, secid, ref); err != nil {
+		b.onError(m, errors.Wrap(err, "error while resolving security"))
+		return
+	}
+	b.reply(m, fmt.Sprintf("Бумага %s теперь указана как %s во всех ваших портфелях. Повторите команду, чтобы она учитывалась", secid, ref))
+}
+
+// pickedSecurity removes suggestions and returns message to pass to the command handler as if user typed picked security.
+// False is returned when callback data is broken.
+func (b *Bot) pickedSecurity(c *tb.Callback) (*tb.Message, string, string, bool) {
+	if err := b.telebot.Respond(c); err != nil {
+		log.Printf("[ERROR] while responding to callback: %v", err)
+	}
+	if _, err := b.telebot.EditReplyMarkup(c.Message, nil); err != nil {
+		log.Printf("[ERROR] while removing suggestions: %v", err)
+	}
+
+	parts := strings.Split(c.Data, "|")
+	if len(parts) != 2 {
+		log.Printf("[ERROR] %v", errors.Errorf("unexpected callback data %q", c.Data))
+		return nil, "", "", false
+	}
+	m := *c.Message
+	m.Sender = c.Sender
+	return &m, parts[0], parts[1], true
+}
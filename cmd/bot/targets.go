@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strings"
+
+	"github.com/pechorka/whattobuy/store"
+	"github.com/pkg/errors"
+
+	tb "gopkg.in/tucnak/telebot.v2"
+)
+
+// targetInput is a target percent as user typed it, ticker is not resolved yet
+type targetInput struct {
+	ticker  string
+	percent float64
+}
+
+// targetsUpdate is a checked change of portfolio targets
+type targetsUpdate struct {
+	// changes are passed to store.AddToPartfolio, zero percent removes security
+	changes map[string]float64
+	// result is the portfolio after changes are applied
+	result store.Partfolio
+	// notFound are inputs with unknown or ambiguous tickers
+	notFound []unresolved
+}
+
+// unresolved is an input which ticker is not found or matches several securities
+type unresolved struct {
+	targetInput
+	err error
+}
+
+func (u targetsUpdate) total() float64 {
+	var sum float64
+	for _, p := range u.result {
+		sum += p
+	}
+	return sum
+}
+
+// prepareTargets resolves tickers with moex and checks that portfolio stays within 100%.
+// When replace is set, current targets missing from input are removed.
+// False is returned when user is already notified about an error.
+func (b *Bot) prepareTargets(ctx context.Context, m *tb.Message, portfolio string, input []targetInput, replace bool) (targetsUpdate, bool) {
+	u := targetsUpdate{
+		changes: make(map[string]float64),
+		result:  make(store.Partfolio),
+	}
+	// bare are secids of resolved refs, data stored under them before boards were known is replaced
+	bare := make(map[string]bool)
+	for _, in := range input {
+		ref, err := b.findSecurity(ctx, in.ticker)
+		if err != nil {
+			log.Printf("[ERROR] while fetching data from moex: %v\n", err)
+			in.ticker = strings.ToUpper(in.ticker)
+			u.notFound = append(u.notFound, unresolved{targetInput: in, err: err})
+			continue
+		}
+		u.changes[ref.String()] = in.percent
+		if ref.IsExact() {
+			bare[ref.SecID] = true
+		}
+	}
+
+	partfolio, err := b.store.GetPartfolio(m.Sender.ID, portfolio)
+	if err != nil {
+		b.onError(m, errors.Wrap(err, "error while retriving portfolio"))
+		return u, false
+	}
+	var kept float64
+	for secid, p := range partfolio {
+		if _, ok := u.changes[secid]; ok { // we replace current value, no need to count it
+			continue
+		}
+		if replace || bare[secid] {
+			u.changes[secid] = 0
+			continue
+		}
+		u.result[secid] = p
+		kept += p
+	}
+	for secid, p := range u.changes {
+		if p != 0 {
+			u.result[secid] = p
+		}
+	}
+
+	if u.total() > 100 {
+		b.onInvalidInput(m, errors.Errorf("Нельзя добавить такой процент, будет больше 100. Доступно для ввода %.2f, а сейчас есть %.2f", 100-kept, kept))
+		return u, false
+	}
+	return u, true
+}
+
+func (u targetsUpdate) notFoundTickers() []string {
+	tickers := make([]string, 0, len(u.notFound))
+	for _, in := range u.notFound {
+		tickers = append(tickers, in.ticker)
+	}
+	return tickers
+}
@@ -0,0 +1,143 @@
+package moex
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Board is a trading board prices are downloaded from
+type Board struct {
+	Engine string
+	Market string
+	Board  string
+}
+
+// ParseBoard reads board in "engine/market/board" format, for example "stock/shares/TQBR"
+func ParseBoard(s string) (Board, error) {
+	parts := strings.Split(strings.TrimSpace(s), "/")
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+		return Board{}, errors.Errorf("invalid board %q, expected engine/market/board", s)
+	}
+	return Board{Engine: parts[0], Market: parts[1], Board: strings.ToUpper(parts[2])}, nil
+}
+
+func (b Board) String() string {
+	return b.Engine + "/" + b.Market + "/" + b.Board
+}
+
+// currencyBoard is always loaded, prices in foreign currencies are converted to rubles with it
+var currencyBoard = Board{EngineCurrency, MarketCurrency, BoardCurrency}
+
+// DefaultBoards are loaded unless other boards are configured
+var DefaultBoards = []Board{
+	{EngineStock, MarketShares, BoardStock},
+	{EngineStock, MarketBonds, BoardTreasuries},
+	{EngineStock, MarketBonds, BoardCorporateBonds},
+	{EngineStock, MarketShares, BoardIndex},
+	{EngineStock, MarketForeignShares, BoardForeignStock},
+	currencyBoard,
+}
+
+// DiscoverMarkets are markets which primary boards are loaded in discovery mode.
+// Other markets, like index or futures, have no prices of securities one can buy.
+var DiscoverMarkets = []Board{
+	{Engine: EngineStock, Market: MarketShares},
+	{Engine: EngineStock, Market: MarketBonds},
+	{Engine: EngineStock, Market: MarketForeignShares},
+	{Engine: EngineCurrency, Market: MarketCurrency},
+}
+
+// discoveryTTL is how often list of boards is requested in discovery mode, boards change very rarely
+const discoveryTTL = 24 * time.Hour
+
+// withCurrencyBoard appends currency board unless it is already listed
+func withCurrencyBoard(boards []Board) []Board {
+	for _, b := range boards {
+		if b == currencyBoard {
+			return boards
+		}
+	}
+	return append(boards[:len(boards):len(boards)], currencyBoard)
+}
+
+// boards returns boards to download prices from: configured ones or primary boards discovered in ISS
+func (api *API) boards(ctx context.Context) ([]Board, error) {
+	if !api.discoverBoards {
+		return api.configuredBoards, nil
+	}
+
+	api.mu.Lock()
+	discovered, discoveredAt := api.discovered, api.discoveredAt
+	api.mu.Unlock()
+	if discovered != nil && api.now().Sub(discoveredAt) < discoveryTTL {
+		return discovered, nil
+	}
+
+	boards, err := api.discoverPrimaryBoards(ctx)
+	if err != nil {
+		if discovered != nil {
+			log.Printf("[ERROR] while discovering boards, previous list is used: %v\n", err)
+			return discovered, nil
+		}
+		log.Printf("[ERROR] while discovering boards, configured boards are used: %v\n", err)
+		return api.configuredBoards, nil
+	}
+	boards = withCurrencyBoard(boards)
+
+	api.mu.Lock()
+	api.discovered, api.discoveredAt = boards, api.now()
+	api.mu.Unlock()
+	return boards, nil
+}
+
+// discoverPrimaryBoards returns traded primary boards of DiscoverMarkets listed in ISS index
+func (api *API) discoverPrimaryBoards(ctx context.Context) ([]Board, error) {
+	urlStr := api.baseURL + "/iss/index.json?iss.meta=off&iss.only=markets,boards"
+
+	var respBody struct {
+		Markets issTable `json:"markets"`
+		Boards  issTable `json:"boards"`
+	}
+	if err := api.get(ctx, urlStr, &respBody); err != nil {
+		return nil, errors.Wrap(err, "error while loading boards")
+	}
+
+	markets := respBody.Markets.index("id", "trade_engine_name", "market_name")
+	if markets == nil {
+		return nil, errors.New("unexpected columns of markets")
+	}
+	allowed := make(map[float64]Board)
+	for _, row := range respBody.Markets.Data {
+		m := Board{
+			Engine: optionalString(row, markets["trade_engine_name"]),
+			Market: optionalString(row, markets["market_name"]),
+		}
+		for _, d := range DiscoverMarkets {
+			if d == m {
+				allowed[optionalFloat(row, markets["id"])] = m
+			}
+		}
+	}
+
+	columns := respBody.Boards.index("market_id", "boardid", "is_traded", "is_primary")
+	if columns == nil {
+		return nil, errors.New("unexpected columns of boards")
+	}
+	var boards []Board
+	for _, row := range respBody.Boards.Data {
+		m, ok := allowed[optionalFloat(row, columns["market_id"])]
+		if !ok || optionalFloat(row, columns["is_traded"]) != 1 || optionalFloat(row, columns["is_primary"]) != 1 {
+			continue
+		}
+		m.Board = optionalString(row, columns["boardid"])
+		boards = append(boards, m)
+	}
+	if len(boards) == 0 {
+		return nil, errors.New("no primary boards found")
+	}
+	return boards, nil
+}
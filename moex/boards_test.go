@@ -0,0 +1,129 @@
+package moex
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestParseBoard(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Board
+		wantErr bool
+	}{
+		{"stock/shares/TQBR", Board{EngineStock, MarketShares, BoardStock}, false},
+		{" stock/shares/tqtd ", Board{EngineStock, MarketShares, "TQTD"}, false},
+		{"stock/shares", Board{}, true},
+		{"stock//TQBR", Board{}, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseBoard(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseBoard(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseBoard(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+// indexResp is a cut of /iss/index.json with markets and boards of several engines
+const indexResp = `{
+"markets": {
+    "columns": ["id", "trade_engine_id", "trade_engine_name", "trade_engine_title", "market_name", "market_title", "market_id", "marketplace"],
+    "data": [
+        [1, 1, "stock", "Фондовый рынок и рынок депозитов", "shares", "Рынок акций", 1, "MXSE"],
+        [2, 1, "stock", "Фондовый рынок и рынок депозитов", "bonds", "Рынок облигаций", 2, "MXSE"],
+        [5, 1, "stock", "Фондовый рынок и рынок депозитов", "index", "Индексы фондового рынка", 5, null],
+        [10, 3, "currency", "Валютный рынок", "selt", "Биржевые сделки с ЦК", 10, "MXCX"]
+    ]
+},
+"boards": {
+    "columns": ["id", "board_group_id", "engine_id", "market_id", "boardid", "board_title", "is_traded", "has_candles", "is_primary"],
+    "data": [
+        [129, 57, 1, 1, "TQBR", "Т+: Акции и ДР - безадрес.", 1, 1, 1],
+        [312, 57, 1, 1, "TQTD", "Т+: ETF (USD) - безадрес.", 1, 1, 1],
+        [130, 57, 1, 1, "SMAL", "Т+: Неполные лоты (акции) - безадрес.", 1, 1, 0],
+        [7, 57, 1, 1, "EQBR", "Основной режим: А1-Акции - безадрес.", 0, 1, 1],
+        [245, 58, 1, 2, "TQOB", "Т+: Гособлигации - безадрес.", 1, 1, 1],
+        [9, 9, 1, 5, "SNDX", "Индексы ФР", 1, 1, 1],
+        [13, 13, 3, 10, "CETS", "Системные сделки - безадрес.", 1, 1, 1]
+    ]
+}}`
+
+func TestMoexAPI_boards(t *testing.T) {
+	ctx := context.Background()
+	var (
+		mu        sync.Mutex
+		requested []string
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requested = append(requested, r.URL.Path)
+		mu.Unlock()
+		switch {
+		case r.URL.Path == "/iss/index.json":
+			w.Write([]byte(indexResp))
+		case strings.Contains(r.URL.Path, "/boards/"+BoardCurrency+"/"):
+			w.Write([]byte(getCurrencyPricesResp))
+		default:
+			w.Write([]byte(emptyBoardResp))
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	boardsOf := func(paths []string) []string {
+		var res []string
+		for _, p := range paths {
+			if i := strings.Index(p, "/boards/"); i >= 0 {
+				res = append(res, strings.Split(p[i+len("/boards/"):], "/")[0])
+			}
+		}
+		sort.Strings(res)
+		return res
+	}
+
+	t.Run("configured", func(t *testing.T) {
+		requested = nil
+		api := New(Opts{
+			Client:  server.Client(),
+			BaseURL: server.URL,
+			Boards:  []Board{{EngineStock, MarketShares, "TQTD"}, {EngineStock, MarketShares, "TQIF"}},
+		})
+		if err := api.UpdateCache(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := boardsOf(requested), []string{"CETS", "TQIF", "TQTD"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("expected boards %v to be loaded, got %v", want, got)
+		}
+	})
+
+	t.Run("discovered", func(t *testing.T) {
+		requested = nil
+		api := New(Opts{Client: server.Client(), BaseURL: server.URL, DiscoverBoards: true})
+		if err := api.UpdateCache(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := boardsOf(requested), []string{"CETS", "TQBR", "TQOB", "TQTD"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("expected boards %v to be loaded, got %v", want, got)
+		}
+
+		// list of boards is reused until it is outdated
+		requested = nil
+		if err := api.UpdateCache(ctx); err != nil {
+			t.Fatal(err)
+		}
+		for _, p := range requested {
+			if p == "/iss/index.json" {
+				t.Error("expected discovered boards to be reused")
+			}
+		}
+	})
+}
@@ -0,0 +1,57 @@
+package moex
+
+import (
+	"context"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+// BadgerCache keeps prices in Badger, so they survive bot restarts without external services
+type BadgerCache struct {
+	db *badger.DB
+}
+
+// OpenBadgerCache opens Badger db at path, empty path opens in-memory db.
+// Path must differ from the store path, Badger directory can't be shared between two dbs.
+func OpenBadgerCache(path string) (*BadgerCache, error) {
+	opts := badger.DefaultOptions(path)
+	if path == "" {
+		opts = opts.WithInMemory(true)
+	}
+	db, err := badger.Open(opts)
+	if err != nil {
+		return nil, err
+	}
+	return &BadgerCache{db: db}, nil
+}
+
+func (c *BadgerCache) Close() error {
+	return c.db.Close()
+}
+
+func (c *BadgerCache) Get(_ context.Context, key string) (value []byte, err error) {
+	err = c.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte(key))
+		if err != nil {
+			if err == badger.ErrKeyNotFound {
+				return ErrCacheMiss
+			}
+			return err
+		}
+		value, err = item.ValueCopy(nil)
+		return err
+	})
+	return value, err
+}
+
+// Set stores value for ttl, zero ttl means value never expires
+func (c *BadgerCache) Set(_ context.Context, key string, value []byte, ttl time.Duration) error {
+	return c.db.Update(func(txn *badger.Txn) error {
+		e := badger.NewEntry([]byte(key), value)
+		if ttl > 0 {
+			e = e.WithTTL(ttl)
+		}
+		return txn.SetEntry(e)
+	})
+}
@@ -0,0 +1,38 @@
+package moex
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-redis/cache/v8"
+	"github.com/pkg/errors"
+)
+
+// RedisCache adapts go-redis cache to PriceCache
+type RedisCache struct {
+	cache *cache.Cache
+}
+
+func NewRedisCache(c *cache.Cache) *RedisCache {
+	return &RedisCache{cache: c}
+}
+
+func (c *RedisCache) Get(ctx context.Context, key string) ([]byte, error) {
+	var v []byte
+	if err := c.cache.Get(ctx, key, &v); err != nil {
+		if errors.Is(err, cache.ErrCacheMiss) {
+			return nil, ErrCacheMiss
+		}
+		return nil, err
+	}
+	return v, nil
+}
+
+func (c *RedisCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return c.cache.Set(&cache.Item{
+		Ctx:   ctx,
+		Key:   key,
+		Value: value,
+		TTL:   ttl,
+	})
+}
@@ -0,0 +1,72 @@
+package moex
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemoryCache(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2021, 11, 3, 12, 0, 0, 0, Moscow)
+	c := NewMemoryCache(2)
+	c.now = func() time.Time { return now }
+
+	mustSet := func(key, value string, ttl time.Duration) {
+		t.Helper()
+		if err := c.Set(ctx, key, []byte(value), ttl); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expect := func(key, want string) {
+		t.Helper()
+		v, err := c.Get(ctx, key)
+		if want == "" {
+			if err != ErrCacheMiss {
+				t.Errorf("%s: expected cache miss, got %q, %v", key, v, err)
+			}
+			return
+		}
+		if err != nil || string(v) != want {
+			t.Errorf("%s: expected %q, got %q, %v", key, want, v, err)
+		}
+	}
+
+	mustSet("a", "1", time.Minute)
+	mustSet("b", "2", 0)
+	expect("a", "1") // a is used more recently than b now
+	mustSet("c", "3", time.Minute)
+	expect("b", "")
+	expect("a", "1")
+	expect("c", "3")
+
+	now = now.Add(time.Minute)
+	expect("a", "")
+	if c.Len() != 1 {
+		t.Errorf("expected expired entry to be removed, got %d entries", c.Len())
+	}
+
+	mustSet("c", "4", 0)
+	now = now.Add(24 * time.Hour)
+	expect("c", "4")
+}
+
+func TestBadgerCache(t *testing.T) {
+	ctx := context.Background()
+	c, err := OpenBadgerCache(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if _, err := c.Get(ctx, "AFKS"); err != ErrCacheMiss {
+		t.Errorf("expected cache miss, got %v", err)
+	}
+	if err := c.Set(ctx, "AFKS", []byte("27.764"), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	v, err := c.Get(ctx, "AFKS")
+	if err != nil || string(v) != "27.764" {
+		t.Errorf("expected cached value, got %q, %v", v, err)
+	}
+}
@@ -0,0 +1,258 @@
+package moex
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// CandleInterval is a length of one candle, values are ISS interval codes
+type CandleInterval int
+
+const (
+	IntervalMinute    CandleInterval = 1
+	Interval10Minutes CandleInterval = 10
+	IntervalHour      CandleInterval = 60
+	IntervalDay       CandleInterval = 24
+	IntervalWeek      CandleInterval = 7
+	IntervalMonth     CandleInterval = 31
+	IntervalQuarter   CandleInterval = 4
+)
+
+// Candle is OHLC of one interval, prices of bonds are percents of face value
+type Candle struct {
+	Begin time.Time
+	End   time.Time
+	Open  float64
+	Close float64
+	High  float64
+	Low   float64
+	// Value is turnover in currency of the board, Volume is number of traded securities
+	Value  float64
+	Volume float64
+}
+
+// HistoryDay is the result of one trading day. Prices are zero on days without trades,
+// prices of bonds are percents of face value.
+type HistoryDay struct {
+	TradeDate time.Time
+	Open      float64
+	Close     float64
+	High      float64
+	Low       float64
+	WAPrice   float64
+	// LegalClose is the official close price
+	LegalClose float64
+	Value      float64
+	Volume     float64
+	NumTrades  float64
+	// FaceValue and AccruedInt are set only for bonds
+	FaceValue  float64
+	AccruedInt float64
+}
+
+const (
+	// pastHistoryTTL is how long history that ended before today is cached, it never changes
+	pastHistoryTTL = 7 * 24 * time.Hour
+	// maxPages stops paging if ISS keeps sending data for some reason
+	maxPages = 1000
+)
+
+const (
+	issDateLayout     = "2006-01-02"
+	issDateTimeLayout = "2006-01-02 15:04:05"
+)
+
+// Candles returns candles of security between from and till dates inclusive, dates are taken in Moscow time.
+// Ref without board is resolved like in Get.
+func (api *API) Candles(ctx context.Context, ref SecurityRef, from, till time.Time, interval CandleInterval) ([]Candle, error) {
+	info, err := api.Get(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	key := fmt.Sprintf("%scandles:%s:%d:%s:%s", cacheKeyPrefix, info.Ref(), interval, issDate(from), issDate(till))
+
+	var candles []Candle
+	err = api.cachedJSON(ctx, key, api.historyTTL(till), &candles, func() error {
+		urlStr := api.baseURL + "/iss/engines/" + info.Engine + "/markets/" + info.Market + "/boards/" + info.Board +
+			"/securities/" + url.PathEscape(info.SecID) + "/candles.json?iss.meta=off&iss.only=candles" +
+			"&from=" + issDate(from) + "&till=" + issDate(till) + "&interval=" + strconv.Itoa(int(interval))
+		table, err := api.loadPages(ctx, urlStr, "candles")
+		if err != nil {
+			return errors.Wrapf(err, "error while loading candles of %s", info.Ref())
+		}
+		candles, err = parseCandles(table)
+		return err
+	})
+	return candles, err
+}
+
+// History returns daily results of security between from and till dates inclusive, dates are taken in Moscow time.
+// Ref without board is resolved like in Get.
+func (api *API) History(ctx context.Context, ref SecurityRef, from, till time.Time) ([]HistoryDay, error) {
+	info, err := api.Get(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	key := fmt.Sprintf("%shistory:%s:%s:%s", cacheKeyPrefix, info.Ref(), issDate(from), issDate(till))
+
+	var days []HistoryDay
+	err = api.cachedJSON(ctx, key, api.historyTTL(till), &days, func() error {
+		urlStr := api.baseURL + "/iss/history/engines/" + info.Engine + "/markets/" + info.Market + "/boards/" + info.Board +
+			"/securities/" + url.PathEscape(info.SecID) + ".json?iss.meta=off&iss.only=history,history.cursor" +
+			"&from=" + issDate(from) + "&till=" + issDate(till)
+		table, err := api.loadPages(ctx, urlStr, "history")
+		if err != nil {
+			return errors.Wrapf(err, "error while loading history of %s", info.Ref())
+		}
+		days, err = parseHistory(table)
+		return err
+	})
+	return days, err
+}
+
+// loadPages requests table with start= growing by the number of received rows until an empty page.
+// Cursor table, which some endpoints send along, stops paging without the extra request.
+func (api *API) loadPages(ctx context.Context, urlStr, name string) (issTable, error) {
+	var res issTable
+	start := 0
+	for page := 0; page < maxPages; page++ {
+		var respBody map[string]issTable
+		if err := api.get(ctx, urlStr+"&start="+strconv.Itoa(start), &respBody); err != nil {
+			return issTable{}, err
+		}
+		table := respBody[name]
+		if res.Columns == nil {
+			res.Columns = table.Columns
+		}
+		res.Data = append(res.Data, table.Data...)
+		start += len(table.Data)
+		if len(table.Data) == 0 {
+			return res, nil
+		}
+		if total, ok := cursorTotal(respBody[name+".cursor"]); ok && start >= total {
+			return res, nil
+		}
+	}
+	return issTable{}, errors.Errorf("%s has more than %d pages", name, maxPages)
+}
+
+// cursorTotal returns total number of rows from ISS cursor table
+func cursorTotal(cursor issTable) (int, bool) {
+	i := cursor.column("TOTAL")
+	if i < 0 || len(cursor.Data) == 0 {
+		return 0, false
+	}
+	total, ok := cursor.Data[0][i].(float64)
+	return int(total), ok
+}
+
+func parseCandles(table issTable) ([]Candle, error) {
+	columns := table.index("open", "close", "high", "low", "value", "volume", "begin", "end")
+	if columns == nil {
+		if len(table.Data) == 0 { // ISS sends no columns for security without candles
+			return nil, nil
+		}
+		return nil, errors.Errorf("unexpected columns of candles %v", table.Columns)
+	}
+	candles := make([]Candle, 0, len(table.Data))
+	for _, row := range table.Data {
+		candles = append(candles, Candle{
+			Begin:  parseDateTime(optionalString(row, columns["begin"])),
+			End:    parseDateTime(optionalString(row, columns["end"])),
+			Open:   optionalFloat(row, columns["open"]),
+			Close:  optionalFloat(row, columns["close"]),
+			High:   optionalFloat(row, columns["high"]),
+			Low:    optionalFloat(row, columns["low"]),
+			Value:  optionalFloat(row, columns["value"]),
+			Volume: optionalFloat(row, columns["volume"]),
+		})
+	}
+	return candles, nil
+}
+
+func parseHistory(table issTable) ([]HistoryDay, error) {
+	dateIndex := table.column("TRADEDATE")
+	if dateIndex < 0 {
+		if len(table.Data) == 0 {
+			return nil, nil
+		}
+		return nil, errors.Errorf("no TRADEDATE column in history %v", table.Columns)
+	}
+	// columns differ between markets, missing ones are left zero
+	col := make(map[string]int)
+	for _, name := range []string{"OPEN", "CLOSE", "HIGH", "LOW", "WAPRICE", "LEGALCLOSEPRICE", "VALUE", "VOLUME", "NUMTRADES", "FACEVALUE", "ACCINT"} {
+		col[name] = table.column(name)
+	}
+	days := make([]HistoryDay, 0, len(table.Data))
+	for _, row := range table.Data {
+		days = append(days, HistoryDay{
+			TradeDate:  parseDate(optionalString(row, dateIndex)),
+			Open:       optionalFloat(row, col["OPEN"]),
+			Close:      optionalFloat(row, col["CLOSE"]),
+			High:       optionalFloat(row, col["HIGH"]),
+			Low:        optionalFloat(row, col["LOW"]),
+			WAPrice:    optionalFloat(row, col["WAPRICE"]),
+			LegalClose: optionalFloat(row, col["LEGALCLOSEPRICE"]),
+			Value:      optionalFloat(row, col["VALUE"]),
+			Volume:     optionalFloat(row, col["VOLUME"]),
+			NumTrades:  optionalFloat(row, col["NUMTRADES"]),
+			FaceValue:  optionalFloat(row, col["FACEVALUE"]),
+			AccruedInt: optionalFloat(row, col["ACCINT"]),
+		})
+	}
+	return days, nil
+}
+
+// cachedJSON decodes cached value of key into v. On cache miss load fills v, which is then cached for ttl.
+// Cache errors are only logged, data is downloaded again.
+func (api *API) cachedJSON(ctx context.Context, key string, ttl time.Duration, v interface{}, load func() error) error {
+	data, err := api.cache.Get(ctx, key)
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(data, v); err == nil {
+			return nil
+		}
+		log.Printf("[ERROR] while decoding cached %s, it will be downloaded again: %v\n", key, err)
+	case !errors.Is(err, ErrCacheMiss):
+		log.Printf("[ERROR] while retriving %s from cache: %v\n", key, err)
+	}
+
+	if err := load(); err != nil {
+		return err
+	}
+	if data, err = json.Marshal(v); err != nil {
+		return errors.Wrapf(err, "error while encoding %s", key)
+	}
+	if err := api.cache.Set(ctx, key, data, ttl); err != nil {
+		log.Printf("[ERROR] while caching %s: %v\n", key, err)
+	}
+	return nil
+}
+
+// historyTTL returns how long history till the date may be cached. History of today changes with every trade.
+func (api *API) historyTTL(till time.Time) time.Duration {
+	if issDate(till) < issDate(api.now()) {
+		return pastHistoryTTL
+	}
+	return api.cacheTTL()
+}
+
+func issDate(t time.Time) string {
+	return t.In(Moscow).Format(issDateLayout)
+}
+
+// parseDateTime parses ISS timestamp column, zero time is returned for empty value
+func parseDateTime(s string) time.Time {
+	t, err := time.ParseInLocation(issDateTimeLayout, s, Moscow)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
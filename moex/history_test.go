@@ -0,0 +1,151 @@
+package moex
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+//go:embed test-candles.json
+var getCandlesResp string
+
+//go:embed test-history.json
+var getHistoryResp string
+
+// historyPageSize is small to make fixtures span several pages
+const historyPageSize = 2
+
+// newHistoryServer serves candles and history fixtures page by page, prices are served like by newBoardsServer.
+// Returned counter is incremented on every request of candles or history.
+func newHistoryServer(t *testing.T) (*httptest.Server, *int32) {
+	var requests int32
+	page := func(w http.ResponseWriter, r *http.Request, fixture, name string) {
+		atomic.AddInt32(&requests, 1)
+		var resp map[string]issTable
+		if err := json.Unmarshal([]byte(fixture), &resp); err != nil {
+			t.Error(err)
+			return
+		}
+		start, _ := strconv.Atoi(r.URL.Query().Get("start"))
+		table := resp[name]
+		if start > len(table.Data) {
+			start = len(table.Data)
+		}
+		end := start + historyPageSize
+		if end > len(table.Data) {
+			end = len(table.Data)
+		}
+		table.Data = table.Data[start:end]
+		resp[name] = table
+		json.NewEncoder(w).Encode(resp)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/securities/AFKS/candles.json"):
+			page(w, r, getCandlesResp, "candles")
+		case r.URL.Path == "/iss/history/engines/stock/markets/shares/boards/TQBR/securities/AFKS.json":
+			page(w, r, getHistoryResp, "history")
+		case strings.Contains(r.URL.Path, "/boards/"+BoardStock+"/"):
+			w.Write([]byte(getAllSecuritiesPricesResp))
+		case strings.Contains(r.URL.Path, "/boards/"+BoardCurrency+"/"):
+			w.Write([]byte(getCurrencyPricesResp))
+		default:
+			w.Write([]byte(emptyBoardResp))
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func TestMoexAPI_Candles(t *testing.T) {
+	ctx := context.Background()
+	server, requests := newHistoryServer(t)
+	api := New(Opts{Client: server.Client(), BaseURL: server.URL})
+	api.now = func() time.Time { return time.Date(2021, 11, 10, 12, 0, 0, 0, Moscow) }
+	from, till := time.Date(2021, 11, 1, 0, 0, 0, 0, Moscow), time.Date(2021, 11, 8, 0, 0, 0, 0, Moscow)
+
+	candles, err := api.Candles(ctx, SecurityRef{SecID: "AFKS"}, from, till, IntervalDay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(candles) != 5 {
+		t.Fatalf("expected 5 candles from 3 pages, got %d", len(candles))
+	}
+	// candles have no cursor, paging ends with an empty page
+	if got := atomic.LoadInt32(requests); got != 4 {
+		t.Errorf("expected 4 requests, got %d", got)
+	}
+	second := candles[1]
+	if second.Open != 27.7 || second.Close != 27.764 || second.High != 28.15 || second.Low != 27.53 || second.Volume != 47521400 {
+		t.Errorf("unexpected candle %+v", second)
+	}
+	if want := time.Date(2021, 11, 2, 0, 0, 0, 0, Moscow); !second.Begin.Equal(want) {
+		t.Errorf("expected candle to begin at %v, got %v", want, second.Begin)
+	}
+
+	// history in the past is cached
+	if _, err := api.Candles(ctx, SecurityRef{BoardStock, "AFKS"}, from, till, IntervalDay); err != nil {
+		t.Fatal(err)
+	}
+	if got := atomic.LoadInt32(requests); got != 4 {
+		t.Errorf("expected cached candles to be used, got %d requests", got-4)
+	}
+	if _, err := api.Candles(ctx, SecurityRef{SecID: "NOSUCHSECID"}, from, till, IntervalDay); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestMoexAPI_History(t *testing.T) {
+	ctx := context.Background()
+	server, requests := newHistoryServer(t)
+	api := New(Opts{Client: server.Client(), BaseURL: server.URL})
+	api.now = func() time.Time { return time.Date(2021, 11, 10, 12, 0, 0, 0, Moscow) }
+	from, till := time.Date(2021, 11, 1, 0, 0, 0, 0, Moscow), time.Date(2021, 11, 8, 0, 0, 0, 0, Moscow)
+
+	days, err := api.History(ctx, SecurityRef{SecID: "AFKS"}, from, till)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(days) != 5 {
+		t.Fatalf("expected 5 days, got %d", len(days))
+	}
+	// cursor tells when to stop, no request for an empty page
+	if got := atomic.LoadInt32(requests); got != 3 {
+		t.Errorf("expected 3 requests, got %d", got)
+	}
+	last := days[4]
+	if want := time.Date(2021, 11, 8, 0, 0, 0, 0, Moscow); !last.TradeDate.Equal(want) {
+		t.Errorf("expected last trade date %v, got %v", want, last.TradeDate)
+	}
+	if last.Close != 27.503 || last.LegalClose != 27.503 || last.WAPrice != 27.632 || last.NumTrades != 20113 {
+		t.Errorf("unexpected day %+v", last)
+	}
+
+	if _, err := api.History(ctx, SecurityRef{SecID: "AFKS"}, from, till); err != nil {
+		t.Fatal(err)
+	}
+	if got := atomic.LoadInt32(requests); got != 3 {
+		t.Errorf("expected cached history to be used, got %d requests", got-3)
+	}
+}
+
+func TestMoexAPI_historyTTL(t *testing.T) {
+	api := New(Opts{})
+	now := time.Date(2021, 11, 10, 1, 0, 0, 0, Moscow)
+	api.now = func() time.Time { return now }
+
+	if ttl := api.historyTTL(now.AddDate(0, 0, -1)); ttl != pastHistoryTTL {
+		t.Errorf("expected history till yesterday to be cached for %v, got %v", pastHistoryTTL, ttl)
+	}
+	// it is still yesterday in UTC, but today in Moscow
+	if ttl := api.historyTTL(now.UTC()); ttl != api.cacheTTL() {
+		t.Errorf("expected history till today to be cached like prices, got %v", ttl)
+	}
+}
@@ -0,0 +1,202 @@
+package moex
+
+import (
+	"time"
+)
+
+// PriceSource defines which ISS column is used as a security price
+type PriceSource string
+
+const (
+	// PriceSourcePrevClose is the admitted quote of previous trading day (PREVADMITTEDQUOTE)
+	PriceSourcePrevClose PriceSource = "prev_close"
+	// PriceSourceLast is the price of the last trade (LAST)
+	PriceSourceLast PriceSource = "last"
+	// PriceSourceWAPrice is the weighted average price of current session (WAPRICE)
+	PriceSourceWAPrice PriceSource = "waprice"
+	// PriceSourceMid is the middle between best bid and best offer (BID, OFFER)
+	PriceSourceMid PriceSource = "mid"
+	// PriceSourceMarket is the market price (MARKETPRICE), used as a fallback when there were no trades yet
+	PriceSourceMarket PriceSource = "market"
+)
+
+// Moscow is the time zone of the exchange and of all ISS timestamps. Russia does not observe DST since 2014.
+var Moscow = time.FixedZone("MSK", 3*60*60)
+
+// IsLive reports whether price is taken from marketdata block and changes during trading session.
+func (ps PriceSource) IsLive() bool {
+	return ps != "" && ps != PriceSourcePrevClose
+}
+
+// IsValid reports whether price source can be requested in Opts.
+// PriceSourceMarket is only used as a fallback.
+func (ps PriceSource) IsValid() bool {
+	switch ps {
+	case PriceSourcePrevClose, PriceSourceLast, PriceSourceWAPrice, PriceSourceMid:
+		return true
+	}
+	return false
+}
+
+// Quote is a price of security in trading session.
+// Source is PriceSourceLast, or PriceSourceMarket if there were no trades yet.
+type Quote struct {
+	Price     float64
+	Source    PriceSource
+	UpdatedAt time.Time
+}
+
+type marketQuote struct {
+	last, waprice, market, bid, offer float64
+	updatedAt                         time.Time
+	// yield and duration are set only for bonds
+	yield, duration float64
+}
+
+// price returns quote for requested source. If there is no such quote yet (e.g. no trades today)
+// it falls back to market price. Returned source is the one that was actually used.
+func (q marketQuote) price(source PriceSource) (float64, PriceSource) {
+	switch source {
+	case PriceSourceLast:
+		if q.last != 0 {
+			return q.last, PriceSourceLast
+		}
+	case PriceSourceWAPrice:
+		if q.waprice != 0 {
+			return q.waprice, PriceSourceWAPrice
+		}
+	case PriceSourceMid:
+		if q.bid != 0 && q.offer != 0 {
+			return (q.bid + q.offer) / 2, PriceSourceMid
+		}
+	}
+	if q.market != 0 {
+		return q.market, PriceSourceMarket
+	}
+	return 0, ""
+}
+
+type issTable struct {
+	Columns []string        `json:"columns"`
+	Data    [][]interface{} `json:"data"`
+}
+
+// index returns positions of required columns, nil is returned if any of them is missing
+func (t issTable) index(columns ...string) map[string]int {
+	res := make(map[string]int, len(columns))
+	for _, c := range columns {
+		res[c] = -1
+	}
+	for i, c := range t.Columns {
+		if _, ok := res[c]; ok {
+			res[c] = i
+		}
+	}
+	for _, i := range res {
+		if i < 0 {
+			return nil
+		}
+	}
+	return res
+}
+
+// column returns position of the column or -1 if it is missing
+func (t issTable) column(name string) int {
+	for i, c := range t.Columns {
+		if c == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// parseMarketdata returns quotes of the board by SECID
+func parseMarketdata(table issTable, board string) map[string]marketQuote {
+	var (
+		secidIndex   = -1
+		boardIndex   = -1
+		lastIndex    = -1
+		waIndex      = -1
+		marketIndex  = -1
+		bidIndex     = -1
+		offerIndex   = -1
+		updTimeIndex = -1
+		sysTimeIndex = -1
+		yieldIndex   = -1
+		durIndex     = -1
+	)
+	for i, column := range table.Columns {
+		switch column {
+		case "SECID":
+			secidIndex = i
+		case "BOARDID":
+			boardIndex = i
+		case "LAST":
+			lastIndex = i
+		case "WAPRICE":
+			waIndex = i
+		case "MARKETPRICE":
+			marketIndex = i
+		case "BID":
+			bidIndex = i
+		case "OFFER":
+			offerIndex = i
+		case "UPDATETIME":
+			updTimeIndex = i
+		case "SYSTIME":
+			sysTimeIndex = i
+		case "YIELD":
+			yieldIndex = i
+		case "DURATION":
+			durIndex = i
+		}
+	}
+
+	res := make(map[string]marketQuote, len(table.Data))
+	if secidIndex < 0 {
+		return res
+	}
+	for _, data := range table.Data {
+		if b := optionalString(data, boardIndex); b != "" && b != board {
+			continue
+		}
+		secid := optionalString(data, secidIndex)
+		res[secid] = marketQuote{
+			last:      optionalFloat(data, lastIndex),
+			waprice:   optionalFloat(data, waIndex),
+			market:    optionalFloat(data, marketIndex),
+			bid:       optionalFloat(data, bidIndex),
+			offer:     optionalFloat(data, offerIndex),
+			updatedAt: quoteTime(optionalString(data, sysTimeIndex), optionalString(data, updTimeIndex)),
+			yield:     optionalFloat(data, yieldIndex),
+			duration:  optionalFloat(data, durIndex),
+		}
+	}
+	return res
+}
+
+// quoteTime combines date of SYSTIME with UPDATETIME, because UPDATETIME has no date part
+func quoteTime(sysTime, updateTime string) time.Time {
+	sys, err := time.ParseInLocation("2006-01-02 15:04:05", sysTime, Moscow)
+	if err != nil {
+		return time.Time{}
+	}
+	upd, err := time.ParseInLocation("15:04:05", updateTime, Moscow)
+	if err != nil {
+		return sys
+	}
+	t := time.Date(sys.Year(), sys.Month(), sys.Day(), upd.Hour(), upd.Minute(), upd.Second(), 0, Moscow)
+	if t.After(sys) { // quote was updated before midnight
+		t = t.AddDate(0, 0, -1)
+	}
+	return t
+}
+
+// parseDate parses ISS date column, zero time is returned for empty or "0000-00-00" dates
+func parseDate(s string) time.Time {
+	t, err := time.ParseInLocation("2006-01-02", s, Moscow)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
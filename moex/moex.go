@@ -5,11 +5,11 @@ import (
 	"encoding/json"
 	"log"
 	"net/http"
+	"sync"
 	"time"
 
-	"github.com/go-redis/cache/v8"
 	"github.com/pkg/errors"
-	"golang.org/x/sync/errgroup"
+	"golang.org/x/sync/singleflight"
 )
 
 var (
@@ -29,6 +29,7 @@ const (
 	MarketBonds         = "bonds"
 	MarketIndex         = "index"
 	MarketForeignShares = "foreignshares"
+	MarketCurrency      = "selt"
 )
 
 const (
@@ -37,108 +38,499 @@ const (
 	BoardIndex          = "TQTF"
 	BoardTreasuries     = "TQOB"
 	BoardCorporateBonds = "TQCB"
+	BoardCurrency       = "CETS"
+)
+
+// CurrencyRUB is a ruble currency code. ISS uses both RUB and legacy SUR codes, the latter is replaced with RUB.
+const CurrencyRUB = "RUB"
+
+// currencyPairs maps currency code to CETS pair that is used to convert it to rubles.
+// Only pairs quoted per one unit of currency are listed here.
+var currencyPairs = map[string]string{
+	"USD": "USD000UTSTOM",
+	"EUR": "EUR_RUB__TOM",
+	"CNY": "CNYRUB_TOM",
+	"GBP": "GBPRUB_TOM",
+	"HKD": "HKDRUB_TOM",
+	"CHF": "CHFRUB_TOM",
+}
+
+const defaultBaseURL = "http://iss.moex.com"
+
+const (
+	defaultMinRefreshInterval = time.Minute
+	defaultNegativeTTL        = 10 * time.Minute
+	// refreshTimeout limits shared download, it doesn't depend on context of the caller that started it,
+	// only Stop cancels it
+	refreshTimeout = time.Minute
 )
 
 type API struct {
-	client *http.Client
-	cache  *cache.Cache
+	client             *http.Client
+	cache              PriceCache
+	baseURL            string
+	priceSource        PriceSource
+	minRefreshInterval time.Duration
+	negativeTTL        time.Duration
+	configuredBoards   []Board
+	discoverBoards     bool
+	now                func() time.Time
+
+	// ctx is canceled by Stop to abort downloads
+	ctx           context.Context
+	cancel        context.CancelFunc
+	refresherDone chan struct{}
+
+	// refreshes makes concurrent cache misses wait for one download
+	refreshes singleflight.Group
+
+	mu sync.Mutex
+	// lastRefresh is the end of the last download, lastRefreshErr is its result
+	lastRefresh    time.Time
+	lastRefreshErr error
+	// misses holds expiration time of refs that were not found after refresh
+	misses map[SecurityRef]time.Time
+	// schedule is set when refresher is started
+	schedule *Schedule
+	// snapshot is decoded snapshot from the cache, it is replaced as a whole on refresh
+	snapshot *snapshot
+	// discovered are primary boards found in discovery mode
+	discovered   []Board
+	discoveredAt time.Time
+	// onUpdate are called after every successful download
+	onUpdate []func()
 }
 
 type Opts struct {
 	Client *http.Client
-	Cache  *cache.Cache
+	// Cache is in-process MemoryCache by default
+	Cache   PriceCache
+	BaseURL string
+	// PriceSource is PriceSourcePrevClose by default
+	PriceSource PriceSource
+	// MinRefreshInterval is how often cache misses may download all boards, one minute by default
+	MinRefreshInterval time.Duration
+	// NegativeTTL is how long unknown security is answered with ErrNotFound without a download, ten minutes by default
+	NegativeTTL time.Duration
+	// Boards are DefaultBoards by default, currency board is always added to convert prices to rubles
+	Boards []Board
+	// DiscoverBoards loads primary boards of DiscoverMarkets listed in ISS instead of Boards.
+	// Boards are used only when discovery fails.
+	DiscoverBoards bool
 }
 
 func New(opts Opts) *API {
-	api := API{
-		client: opts.Client,
-		cache:  opts.Cache,
+	api := &API{
+		client:      opts.Client,
+		cache:       opts.Cache,
+		baseURL:     opts.BaseURL,
+		priceSource: opts.PriceSource,
+
+		minRefreshInterval: opts.MinRefreshInterval,
+		negativeTTL:        opts.NegativeTTL,
+		configuredBoards:   opts.Boards,
+		discoverBoards:     opts.DiscoverBoards,
+		now:                time.Now,
+		misses:             make(map[SecurityRef]time.Time),
 	}
+	api.ctx, api.cancel = context.WithCancel(context.Background())
 
 	if api.client == nil {
 		api.client = http.DefaultClient
 	}
+	if api.cache == nil {
+		api.cache = NewMemoryCache(DefaultMemoryCacheSize)
+	}
+	if api.baseURL == "" {
+		api.baseURL = defaultBaseURL
+	}
+	if api.priceSource == "" {
+		api.priceSource = PriceSourcePrevClose
+	}
+	if api.minRefreshInterval == 0 {
+		api.minRefreshInterval = defaultMinRefreshInterval
+	}
+	if api.negativeTTL == 0 {
+		api.negativeTTL = defaultNegativeTTL
+	}
+	if len(api.configuredBoards) == 0 {
+		api.configuredBoards = DefaultBoards
+	}
+	api.configuredBoards = withCurrencyBoard(api.configuredBoards)
 
-	return &api
+	return api
 }
 
 type StockInfo struct {
-	Price     float64
-	ShortName string
-	LotSize   float64
+	// Board and SecID identify security, they are the same whether it was found by SECID, ISIN or REGNUMBER
+	Board string
+	SecID string
+	// Engine is ISS engine of the Board, history of security is requested with it
+	Engine string
+	// Price for bonds is quoted as a percent of FaceValue, use CleanPrice or DirtyPrice to get money amount
+	Price      float64
+	ShortName  string
+	LatName    string
+	ISIN       string
+	RegNumber  string
+	LotSize    float64
+	Market     string
+	FaceValue  float64
+	FaceUnit   string
+	AccruedInt float64
+	// Currency is the currency of CleanPrice and DirtyPrice
+	Currency string
+	// PriceSource is the source Price was taken from, it may differ from requested one if there is no such quote
+	PriceSource PriceSource
+	// UpdatedAt is the time of the quote. For PriceSourcePrevClose it is the date of previous trading day
+	UpdatedAt time.Time
+	// Live is the current quote of trading session whatever PriceSource is, its Price is zero if there is none
+	Live Quote
+	// Bond is set only for bonds
+	Bond *BondInfo
+}
+
+// BondInfo is analytics of bond published by exchange
+type BondInfo struct {
+	// Yield is effective yield in percent, it is calculated to OfferDate if bond has an offer.
+	// For live price sources it is the yield at the last trade (YIELD). At previous close it is the yield
+	// at weighted average price of previous day (YIELDATPREVWAPRICE), exchange does not publish it for the admitted quote.
+	// Yield and Duration are zero if there were no trades.
+	Yield float64
+	// YieldAt is when Yield was measured: the time of the live quote or the previous trading day
+	YieldAt time.Time
+	// Duration is Macaulay duration in days. Exchange publishes it only in marketdata block,
+	// so it is taken from there whatever price source is. DurationAt is the time of that quote.
+	Duration   float64
+	DurationAt time.Time
+	MatDate    time.Time
+	OfferDate  time.Time
+	// CouponPercent is annual rate of the current coupon, CouponValue is paid per one bond in FaceUnit currency
+	CouponPercent float64
+	CouponValue   float64
+	// CouponPeriod is length of coupon period in days
+	CouponPeriod int
+	NextCoupon   time.Time
+}
+
+// Ref returns exact reference to the security, use it to refer to the security later
+func (s StockInfo) Ref() SecurityRef {
+	return SecurityRef{Board: s.Board, SecID: s.SecID}
+}
+
+// IsBond reports whether security is traded on bonds market and its Price is a percent of FaceValue.
+func (s StockInfo) IsBond() bool {
+	return s.Market == MarketBonds
+}
+
+// CleanPrice returns price of one security without accrued interest.
+func (s StockInfo) CleanPrice() float64 {
+	if !s.IsBond() {
+		return s.Price
+	}
+	return s.Price / 100 * s.FaceValue
 }
 
-func (api *API) Get(ctx context.Context, secid string) (*StockInfo, error) {
-	s, err := api.getFromCache(ctx, secid)
-	if err == nil {
-		return s, nil
+// DirtyPrice returns what one security actually costs to buy.
+// For bonds buyer pays accrued interest to seller on top of the clean price.
+func (s StockInfo) DirtyPrice() float64 {
+	if !s.IsBond() {
+		return s.Price
 	}
+	return s.CleanPrice() + s.AccruedInt
+}
 
-	return api.getFromMoex(ctx, secid)
+// Get returns security ref points to. Ref without board may hold SECID, ISIN or state registration number,
+// *AmbiguousError is returned if it matches several securities. Use Ref of returned info to refer to the security later.
+func (api *API) Get(ctx context.Context, ref SecurityRef) (*StockInfo, error) {
+	infos, err := api.GetMultiple(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	if s, ok := infos[ref]; ok {
+		return &s, nil
+	}
+	snap, err := api.currentSnapshot(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := snap.find(ref); err != nil && err != ErrNotFound {
+		return nil, err
+	}
+	return nil, ErrNotFound
 }
 
-func (api *API) GetMultiple(ctx context.Context, secids ...string) (map[string]StockInfo, error) {
-	res := make(map[string]StockInfo)
-	for _, secid := range secids {
-		s, err := api.Get(ctx, secid)
-		if err != nil {
-			if err == ErrNotFound {
-				continue
-			}
+// Rate returns how many rubles one unit of currency costs
+func (api *API) Rate(ctx context.Context, currency string) (float64, error) {
+	if currency == "" || currency == CurrencyRUB {
+		return 1, nil
+	}
+	ref, ok := CurrencyRef(currency)
+	if !ok {
+		return 0, errors.Wrapf(ErrNotFound, "no exchange rate for %s", currency)
+	}
+	info, err := api.Get(ctx, ref)
+	if err != nil {
+		return 0, errors.Wrapf(err, "error while getting exchange rate for %s", currency)
+	}
+	return info.Price, nil
+}
+
+// CurrencyRef returns ref of the pair Rate uses to convert currency to rubles, its history gives past rates.
+// False is returned for rubles and for currencies without known pair.
+func CurrencyRef(currency string) (SecurityRef, bool) {
+	pair, ok := currencyPairs[currency]
+	if !ok {
+		return SecurityRef{}, false
+	}
+	return SecurityRef{Board: BoardCurrency, SecID: pair}, true
+}
+
+// PriceRUB returns DirtyPrice converted to rubles
+func (api *API) PriceRUB(ctx context.Context, info StockInfo) (float64, error) {
+	rate, err := api.Rate(ctx, info.Currency)
+	if err != nil {
+		return 0, err
+	}
+	return info.DirtyPrice() * rate, nil
+}
+
+// GetMultiple returns found securities keyed by requested refs, unknown and ambiguous refs are skipped,
+// use Get to find out why. All securities are taken from the same snapshot of prices.
+// Error is returned only if none of refs is found.
+func (api *API) GetMultiple(ctx context.Context, refs ...SecurityRef) (map[SecurityRef]StockInfo, error) {
+	snap, err := api.currentSnapshot(ctx)
+	if err != nil {
+		return nil, err
+	}
+	res := make(map[SecurityRef]StockInfo, len(refs))
+	var missing []SecurityRef
+	for _, ref := range refs {
+		s, err := snap.find(ref)
+		if err == nil {
+			res[ref] = s
+			continue
+		}
+		if err == ErrNotFound && !api.isKnownMiss(ref) {
+			missing = append(missing, ref)
+		}
+	}
+	if len(missing) == 0 {
+		return res, nil
+	}
+
+	if err := api.refresh(ctx, false); err != nil {
+		// failed download must not hide securities that are already known
+		if len(res) == 0 {
 			return nil, err
 		}
-		res[secid] = *s
+		log.Printf("[ERROR] while refreshing prices for %d missing securities: %v", len(missing), err)
+		for _, ref := range missing {
+			api.rememberMiss(ref)
+		}
+		return res, nil
+	}
+	fresh, err := api.currentSnapshot(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if fresh != snap { // securities found in older snapshot have to be taken from the new one too
+		return api.fromSnapshot(fresh, refs), nil
+	}
+	for _, ref := range missing {
+		api.rememberMiss(ref)
 	}
 	return res, nil
 }
 
-func (api *API) getFromMoex(ctx context.Context, secid string) (*StockInfo, error) {
-	if err := api.UpdateCache(ctx); err != nil {
-		return nil, err
+// fromSnapshot returns securities found in snap and remembers missing ones
+func (api *API) fromSnapshot(snap *snapshot, refs []SecurityRef) map[SecurityRef]StockInfo {
+	res := make(map[SecurityRef]StockInfo, len(refs))
+	for _, ref := range refs {
+		s, err := snap.find(ref)
+		if err == nil {
+			res[ref] = s
+			continue
+		}
+		if err == ErrNotFound {
+			api.rememberMiss(ref)
+		}
 	}
+	return res
+}
 
-	return api.getFromCache(ctx, secid)
+func (api *API) rememberMiss(ref SecurityRef) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	api.misses[ref] = api.now().Add(api.negativeTTL)
 }
 
+func (api *API) isKnownMiss(ref SecurityRef) bool {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	expiresAt, ok := api.misses[ref]
+	if ok && !api.now().Before(expiresAt) {
+		delete(api.misses, ref)
+		return false
+	}
+	return ok
+}
+
+// UpdateCache downloads prices of all boards. Concurrent calls share one download.
 func (api *API) UpdateCache(ctx context.Context) error {
-	gr, ectx := errgroup.WithContext(ctx)
+	return api.refresh(ctx, true)
+}
 
-	loadAndCache := func(ctx context.Context, engine, market, board string) error {
-		data, err := api.loadSecuritiesPrices(ctx, engine, market, board)
-		if err != nil {
-			log.Printf("[ERROR] while loading for engine: %s, market: %s, board: %s, err: %v\n", engine, market, board, err)
-			return err
-		}
+// OnUpdate registers f to be called in its own goroutine after every successful download of prices
+func (api *API) OnUpdate(f func()) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	api.onUpdate = append(api.onUpdate, f)
+}
 
-		return api.cacheData(ctx, data)
+// refresh downloads prices of all boards unless forced or last download was less than minRefreshInterval ago.
+// Skipped refresh returns result of the last download.
+func (api *API) refresh(ctx context.Context, force bool) error {
+	api.mu.Lock()
+	if !force && !api.lastRefresh.IsZero() && api.now().Sub(api.lastRefresh) < api.minRefreshInterval {
+		err := api.lastRefreshErr
+		api.mu.Unlock()
+		return err
 	}
-	gr.Go(func() error {
-		return loadAndCache(ectx, EngineStock, MarketShares, BoardStock)
-	})
-	gr.Go(func() error {
-		return loadAndCache(ectx, EngineStock, MarketBonds, BoardTreasuries)
-	})
-	gr.Go(func() error {
-		return loadAndCache(ectx, EngineStock, MarketBonds, BoardCorporateBonds)
-	})
-	gr.Go(func() error {
-		return loadAndCache(ectx, EngineStock, MarketShares, BoardIndex)
-	})
-	gr.Go(func() error {
-		return loadAndCache(ectx, EngineStock, MarketForeignShares, BoardForeignStock)
+	api.mu.Unlock()
+
+	ch := api.refreshes.DoChan("refresh", func() (interface{}, error) {
+		ctx, cancel := context.WithTimeout(api.ctx, refreshTimeout)
+		defer cancel()
+		err := api.updateCache(ctx)
+
+		api.mu.Lock()
+		api.lastRefresh, api.lastRefreshErr = api.now(), err
+		var hooks []func()
+		if err == nil {
+			api.misses = make(map[SecurityRef]time.Time)
+			hooks = append(hooks, api.onUpdate...)
+		}
+		api.mu.Unlock()
+
+		for _, f := range hooks {
+			go f()
+		}
+		return nil, err
 	})
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case res := <-ch:
+		return res.Err
+	}
+}
+
+// updateCache downloads all boards and replaces cached prices. Boards that failed to load keep prices
+// of the previous snapshot. Refresh fails only if currency board or every board fails, prices of other
+// boards can't be converted to rubles without the former.
+func (api *API) updateCache(ctx context.Context) error {
+	boards, err := api.boards(ctx)
+	if err != nil {
+		return err
+	}
+
+	var (
+		wg     sync.WaitGroup
+		loaded = make([]map[string]StockInfo, len(boards))
+		errs   = make([]error, len(boards))
+	)
+	for i, b := range boards {
+		i, b := i, b
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			loaded[i], errs[i] = api.loadSecuritiesPrices(ctx, b.Engine, b.Market, b.Board)
+			if errs[i] != nil {
+				log.Printf("[ERROR] while loading for engine: %s, market: %s, board: %s, err: %v\n", b.Engine, b.Market, b.Board, errs[i])
+			}
+		}()
+	}
+	wg.Wait()
+
+	var (
+		failed   []Board
+		firstErr error
+	)
+	for i, b := range boards {
+		if errs[i] == nil {
+			continue
+		}
+		if b == currencyBoard {
+			return errors.Wrap(errs[i], "error while loading exchange rates")
+		}
+		failed = append(failed, b)
+		if firstErr == nil {
+			firstErr = errs[i]
+		}
+	}
+	if len(failed) == len(boards) {
+		return errors.Wrap(firstErr, "error while loading all boards")
+	}
+	if len(failed) > 0 {
+		loaded = append(loaded, api.previousPrices(ctx, failed))
+	}
 
-	return gr.Wait()
+	return api.cacheSnapshot(ctx, newSnapshot(api.now(), api.cacheTTL(), loaded))
 }
 
+// previousPrices returns securities of the boards from the last snapshot, even expired one
+func (api *API) previousPrices(ctx context.Context, boards []Board) map[string]StockInfo {
+	api.mu.Lock()
+	prev := api.snapshot
+	api.mu.Unlock()
+	if prev == nil {
+		var err error
+		if prev, err = api.currentSnapshot(ctx); err != nil {
+			log.Printf("[ERROR] while loading previous prices of failed boards: %v\n", err)
+			return nil
+		}
+	}
+
+	names := make(map[string]bool, len(boards))
+	for _, b := range boards {
+		names[b.Board] = true
+	}
+	res := make(map[string]StockInfo)
+	for key, info := range prev.Securities {
+		if names[info.Board] {
+			res[key] = info
+		}
+	}
+	log.Printf("[INFO] kept %d previous prices of %d failed boards\n", len(res), len(boards))
+	return res
+}
+
+func newSnapshot(now time.Time, ttl time.Duration, boards []map[string]StockInfo) *snapshot {
+	var size int
+	for _, data := range boards {
+		size += len(data)
+	}
+	snap := &snapshot{
+		Version:    now.UnixNano(),
+		ExpiresAt:  now.Add(ttl),
+		Securities: make(map[string]StockInfo, size),
+	}
+	for _, data := range boards {
+		for _, info := range data {
+			snap.Securities[info.Ref().String()] = info
+		}
+	}
+	return snap
+}
+
+// loadSecuritiesPrices returns securities of the board by SECID
 func (api *API) loadSecuritiesPrices(ctx context.Context, engine, market, board string) (map[string]StockInfo, error) {
-	urlStr := "http://iss.moex.com/iss/engines/" + engine + "/markets/" + market + "/boards/" + board + "/securities.json?iss.meta=off&iss.only=securities"
+	urlStr := api.baseURL + "/iss/engines/" + engine + "/markets/" + market + "/boards/" + board + "/securities.json?iss.meta=off&iss.only=securities,marketdata"
 
 	var respBody struct {
-		Securities struct {
-			Columns []string        `json:"columns"`
-			Data    [][]interface{} `json:"data"`
-		} `json:"securities"`
+		Securities issTable `json:"securities"`
+		Marketdata issTable `json:"marketdata"`
 	}
 
 	if err := api.get(ctx, urlStr, &respBody); err != nil {
@@ -146,44 +538,119 @@ func (api *API) loadSecuritiesPrices(ctx context.Context, engine, market, board
 	}
 
 	var (
-		secidIndex     int
-		shortNameIndex int
-		lotSizeIndex   int
-		priceIndex     int
+		secidIndex      int
+		boardIndex      = -1
+		shortNameIndex  int
+		lotSizeIndex    int
+		priceIndex      = -1
+		prevWAIndex     = -1
+		faceValueIndex  = -1
+		faceUnitIndex   = -1
+		accruedIntIndex = -1
+		prevDateIndex   = -1
+		currencyIndex   = -1
+		isinIndex       = -1
+		latNameIndex    = -1
+		regNumberIndex  = -1
+		yieldIndex      = -1
+		matDateIndex    = -1
+		offerDateIndex  = -1
+		couponPrcIndex  = -1
+		couponValIndex  = -1
+		couponPerIndex  = -1
+		nextCouponIndex = -1
 	)
 
 	for i, column := range respBody.Securities.Columns {
 		switch column {
 		case "SECID":
 			secidIndex = i
+		case "BOARDID":
+			boardIndex = i
 		case "SHORTNAME":
 			shortNameIndex = i
 		case "LOTSIZE":
 			lotSizeIndex = i
 		case "PREVADMITTEDQUOTE":
 			priceIndex = i
+		case "FACEVALUE":
+			faceValueIndex = i
+		case "FACEUNIT":
+			faceUnitIndex = i
+		case "ACCRUEDINT": // only bonds have it
+			accruedIntIndex = i
+		case "PREVDATE":
+			prevDateIndex = i
+		case "PREVWAPRICE":
+			prevWAIndex = i
+		case "CURRENCYID":
+			currencyIndex = i
+		case "ISIN":
+			isinIndex = i
+		case "LATNAME":
+			latNameIndex = i
+		case "REGNUMBER":
+			regNumberIndex = i
+		case "YIELDATPREVWAPRICE":
+			yieldIndex = i
+		case "MATDATE":
+			matDateIndex = i
+		case "OFFERDATE":
+			offerDateIndex = i
+		case "COUPONPERCENT":
+			couponPrcIndex = i
+		case "COUPONVALUE":
+			couponValIndex = i
+		case "COUPONPERIOD":
+			couponPerIndex = i
+		case "NEXTCOUPON":
+			nextCouponIndex = i
 		}
 	}
+	if priceIndex < 0 { // currency boards have no admitted quote
+		priceIndex = prevWAIndex
+	}
+	if priceIndex < 0 {
+		return nil, errors.Errorf("no price column in response for board %s", board)
+	}
+
+	quotes := parseMarketdata(respBody.Marketdata, board)
 
 	res := make(map[string]StockInfo, len(respBody.Securities.Data))
 	for i, data := range respBody.Securities.Data {
-		if data[priceIndex] == nil { //price not available
+		if b := optionalString(data, boardIndex); b != "" && b != board {
 			continue
 		}
-
 		secid, ok := data[secidIndex].(string)
 		if !ok {
 			return nil, errors.Errorf("SECID for data %d is not a string, got %T", i, data[secidIndex])
 		}
 
-		shortName, ok := data[shortNameIndex].(string)
-		if !ok {
-			return nil, errors.Errorf("SHORTNAME for data %d is not a string, got %T", i, data[shortNameIndex])
+		var (
+			price       float64
+			priceSource PriceSource
+			updatedAt   time.Time
+		)
+		q, quoted := quotes[secid]
+		if quoted && api.priceSource.IsLive() {
+			price, priceSource = q.price(api.priceSource)
+			updatedAt = q.updatedAt
+		}
+		if price == 0 {
+			if data[priceIndex] == nil { //price not available
+				continue
+			}
+			prevPrice, ok := data[priceIndex].(float64)
+			if !ok {
+				return nil, errors.Errorf("PREVADMITTEDQUOTE for data %d is not a number, got %T", i, data[priceIndex])
+			}
+			price, priceSource = prevPrice, PriceSourcePrevClose
+			updatedAt = parseDate(optionalString(data, prevDateIndex))
 		}
 
-		prevPrice, ok := data[priceIndex].(float64)
+		shortName, ok := data[shortNameIndex].(string)
 		if !ok {
-			return nil, errors.Errorf("PREVWAPRICE for data %d is not a number, got %T", i, data[priceIndex])
+			return nil, errors.Errorf("SHORTNAME for data %d is not a string, got %T", i, data[shortNameIndex])
 		}
 
 		lotSize, ok := data[lotSizeIndex].(float64)
@@ -191,43 +658,107 @@ func (api *API) loadSecuritiesPrices(ctx context.Context, engine, market, board
 			return nil, errors.Errorf("LOTSIZE for data %d is not a number, got %T", i, data[lotSizeIndex])
 		}
 
+		faceUnit := normalizeCurrency(optionalString(data, faceUnitIndex))
+		currency := normalizeCurrency(optionalString(data, currencyIndex))
+		if market == MarketBonds && faceUnit != "" { // bond price is a percent of face value
+			currency = faceUnit
+		}
+		if currency == "" {
+			currency = CurrencyRUB
+		}
+
+		var live Quote
+		if quoted {
+			live.Price, live.Source = q.price(PriceSourceLast)
+			live.UpdatedAt = q.updatedAt
+		}
+
+		var bond *BondInfo
+		if market == MarketBonds {
+			bond = &BondInfo{
+				MatDate:       parseDate(optionalString(data, matDateIndex)),
+				OfferDate:     parseDate(optionalString(data, offerDateIndex)),
+				CouponPercent: optionalFloat(data, couponPrcIndex),
+				CouponValue:   optionalFloat(data, couponValIndex),
+				CouponPeriod:  int(optionalFloat(data, couponPerIndex)),
+				NextCoupon:    parseDate(optionalString(data, nextCouponIndex)),
+			}
+			if q.duration != 0 {
+				bond.Duration, bond.DurationAt = q.duration, q.updatedAt
+			}
+			// yield is taken from the same block as the price
+			if priceSource.IsLive() {
+				if q.yield != 0 {
+					bond.Yield, bond.YieldAt = q.yield, q.updatedAt
+				}
+			} else if yield := optionalFloat(data, yieldIndex); yield != 0 {
+				bond.Yield, bond.YieldAt = yield, parseDate(optionalString(data, prevDateIndex))
+			}
+		}
+
 		res[secid] = StockInfo{
-			Price:     prevPrice,
-			ShortName: shortName,
-			LotSize:   lotSize,
+			Board:       board,
+			SecID:       secid,
+			Engine:      engine,
+			Price:       price,
+			ShortName:   shortName,
+			LatName:     optionalString(data, latNameIndex),
+			ISIN:        optionalString(data, isinIndex),
+			RegNumber:   optionalString(data, regNumberIndex),
+			LotSize:     lotSize,
+			Market:      market,
+			FaceValue:   optionalFloat(data, faceValueIndex),
+			FaceUnit:    faceUnit,
+			Currency:    currency,
+			AccruedInt:  optionalFloat(data, accruedIntIndex),
+			PriceSource: priceSource,
+			UpdatedAt:   updatedAt,
+			Live:        live,
+			Bond:        bond,
 		}
 	}
 
 	return res, nil
 }
 
-func (api *API) cacheData(ctx context.Context, data map[string]StockInfo) error {
-	for secid, info := range data {
-		item := cache.Item{
-			Ctx:   ctx,
-			Key:   secid,
-			Value: info,
-			TTL:   24 * time.Hour,
-		}
-		if err := api.cache.Set(&item); err != nil {
-			return err
-		}
+func normalizeCurrency(currency string) string {
+	if currency == "SUR" {
+		return CurrencyRUB
 	}
+	return currency
+}
 
-	return nil
+// optionalFloat returns zero if column is missing or value is null
+func optionalFloat(data []interface{}, index int) float64 {
+	if index < 0 {
+		return 0
+	}
+	v, _ := data[index].(float64)
+	return v
 }
 
-func (api *API) getFromCache(ctx context.Context, secID string) (*StockInfo, error) {
-	var s StockInfo
-	err := api.cache.Get(ctx, secID, &s)
-	if err != nil {
-		if errors.Is(err, cache.ErrCacheMiss) {
-			return nil, ErrNotFound
-		}
-		return nil, errors.Wrap(err, "error while retriving from cache")
+// optionalString returns empty string if column is missing or value is null
+func optionalString(data []interface{}, index int) string {
+	if index < 0 {
+		return ""
 	}
+	v, _ := data[index].(string)
+	return v
+}
+
+// cacheKeyPrefix separates prices from anything else kept in the same cache
+const cacheKeyPrefix = "moex:"
 
-	return &s, nil
+// cacheTTL returns how long prices stay valid. Live prices have to be reloaded during trading session.
+// When refresher is running prices stay valid until its next download.
+func (api *API) cacheTTL() time.Duration {
+	if ttl, ok := api.scheduledTTL(); ok {
+		return ttl
+	}
+	if api.priceSource.IsLive() {
+		return 5 * time.Minute
+	}
+	return 24 * time.Hour
 }
 
 func (api *API) get(ctx context.Context, urlStr string, respBody interface{}) error {
@@ -3,14 +3,26 @@ package moex
 import (
 	"context"
 	_ "embed"
+	"fmt"
+	"math"
 	"net/http"
 	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 //go:embed test-resp.json
 var getAllSecuritiesPricesResp string
 
+//go:embed test-resp-bonds.json
+var getBondsPricesResp string
+
+//go:embed test-resp-currency.json
+var getCurrencyPricesResp string
+
 func TestMoexAPI_loadSecuritiesPrices(t *testing.T) {
 	ctx := context.Background()
 
@@ -20,7 +32,7 @@ func TestMoexAPI_loadSecuritiesPrices(t *testing.T) {
 
 	t.Cleanup(server.Close)
 
-	api := New(Opts{Client: server.Client()})
+	api := New(Opts{Client: server.Client(), BaseURL: server.URL})
 
 	prices, err := api.loadSecuritiesPrices(ctx, EngineStock, MarketShares, BoardStock)
 	if err != nil {
@@ -29,7 +41,7 @@ func TestMoexAPI_loadSecuritiesPrices(t *testing.T) {
 
 	expected := map[string]StockInfo{
 		"AFKS": {
-			Price:     27.785,
+			Price:     27.764,
 			ShortName: "Система ао",
 			LotSize:   100,
 		},
@@ -50,3 +62,459 @@ func TestMoexAPI_loadSecuritiesPrices(t *testing.T) {
 		}
 	}
 }
+
+func TestMoexAPI_loadSecuritiesPrices_bonds(t *testing.T) {
+	ctx := context.Background()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte(getBondsPricesResp))
+	}))
+
+	t.Cleanup(server.Close)
+
+	api := New(Opts{Client: server.Client(), BaseURL: server.URL})
+
+	prices, err := api.loadSecuritiesPrices(ctx, EngineStock, MarketBonds, BoardTreasuries)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := prices["SU52002RMFS1"]; ok {
+		t.Errorf("expected bond without price to be skipped")
+	}
+
+	info, ok := prices["SU26207RMFS9"]
+	if !ok {
+		t.Fatal("expected to get secid SU26207RMFS9")
+	}
+	if !info.IsBond() {
+		t.Errorf("expected %q to be a bond", info.ShortName)
+	}
+	if info.FaceValue != 1000 || info.FaceUnit != CurrencyRUB {
+		t.Errorf("expected face value 1000 RUB, got %f %s", info.FaceValue, info.FaceUnit)
+	}
+	if info.Currency != CurrencyRUB {
+		t.Errorf("expected currency %s, got %s", CurrencyRUB, info.Currency)
+	}
+	if info.AccruedInt != 11.39 {
+		t.Errorf("expected accrued interest 11.39, got %f", info.AccruedInt)
+	}
+	if clean := info.CleanPrice(); math.Abs(clean-1015.17) > 1e-9 {
+		t.Errorf("expected clean price 1015.17, got %f", clean)
+	}
+	if dirty := info.DirtyPrice(); math.Abs(dirty-1026.56) > 1e-9 {
+		t.Errorf("expected dirty price 1026.56, got %f", dirty)
+	}
+
+	// live quote is parsed whatever price source is
+	wantLive := Quote{Price: 101.54, Source: PriceSourceLast, UpdatedAt: time.Date(2021, 11, 3, 18, 39, 58, 0, Moscow)}
+	if info.Live != wantLive {
+		t.Errorf("expected live quote %+v, got %+v", wantLive, info.Live)
+	}
+
+	if info.Bond == nil {
+		t.Fatal("expected bond info to be set")
+	}
+	quoteTime := time.Date(2021, 11, 3, 18, 39, 58, 0, Moscow)
+	want := BondInfo{
+		// yield is of previous day, like the price, duration is published only with the live quote
+		Yield:         7.91,
+		YieldAt:       time.Date(2021, 11, 2, 0, 0, 0, 0, Moscow),
+		Duration:      1573,
+		DurationAt:    quoteTime,
+		MatDate:       time.Date(2027, 2, 3, 0, 0, 0, 0, Moscow),
+		CouponPercent: 8.15,
+		CouponValue:   40.64,
+		CouponPeriod:  182,
+		NextCoupon:    time.Date(2022, 2, 9, 0, 0, 0, 0, Moscow),
+	}
+	if *info.Bond != want {
+		t.Errorf("expected bond info %+v, got %+v", want, *info.Bond)
+	}
+
+	live := New(Opts{Client: server.Client(), BaseURL: server.URL, PriceSource: PriceSourceLast})
+	prices, err = live.loadSecuritiesPrices(ctx, EngineStock, MarketBonds, BoardTreasuries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info = prices["SU26207RMFS9"]
+	if info.Price != 101.54 || info.PriceSource != PriceSourceLast {
+		t.Errorf("expected last price 101.54, got %f from %s", info.Price, info.PriceSource)
+	}
+	if info.Bond == nil {
+		t.Fatal("expected bond info to be set")
+	}
+	// both are at the last price
+	want.Yield, want.YieldAt = 7.93, quoteTime
+	if *info.Bond != want {
+		t.Errorf("expected bond info %+v, got %+v", want, *info.Bond)
+	}
+}
+
+func TestMoexAPI_loadSecuritiesPrices_priceSource(t *testing.T) {
+	ctx := context.Background()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte(getAllSecuritiesPricesResp))
+	}))
+
+	t.Cleanup(server.Close)
+
+	tests := []struct {
+		source         PriceSource
+		expectedPrice  float64
+		expectedSource PriceSource
+		expectedTime   time.Time
+	}{
+		{PriceSourcePrevClose, 27.764, PriceSourcePrevClose, time.Date(2021, 11, 2, 0, 0, 0, 0, Moscow)},
+		{PriceSourceLast, 28.14, PriceSourceLast, time.Date(2021, 11, 3, 23, 51, 34, 0, Moscow)},
+		{PriceSourceWAPrice, 27.977, PriceSourceWAPrice, time.Date(2021, 11, 3, 23, 51, 34, 0, Moscow)},
+		{PriceSourceMid, 27.79, PriceSourceMarket, time.Date(2021, 11, 3, 23, 51, 34, 0, Moscow)}, // no bids after session end
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.source), func(t *testing.T) {
+			api := New(Opts{Client: server.Client(), BaseURL: server.URL, PriceSource: tt.source})
+
+			prices, err := api.loadSecuritiesPrices(ctx, EngineStock, MarketShares, BoardStock)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			info, ok := prices["AFKS"]
+			if !ok {
+				t.Fatal("expected to get secid AFKS")
+			}
+			if info.Price != tt.expectedPrice {
+				t.Errorf("expected price %f, got %f", tt.expectedPrice, info.Price)
+			}
+			if info.PriceSource != tt.expectedSource {
+				t.Errorf("expected price source %q, got %q", tt.expectedSource, info.PriceSource)
+			}
+			if !info.UpdatedAt.Equal(tt.expectedTime) {
+				t.Errorf("expected quote time %s, got %s", tt.expectedTime, info.UpdatedAt)
+			}
+		})
+	}
+}
+
+func TestMoexAPI_loadSecuritiesPrices_currency(t *testing.T) {
+	ctx := context.Background()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte(getCurrencyPricesResp))
+	}))
+
+	t.Cleanup(server.Close)
+
+	api := New(Opts{Client: server.Client(), BaseURL: server.URL})
+
+	prices, err := api.loadSecuritiesPrices(ctx, EngineCurrency, MarketCurrency, BoardCurrency)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, ok := prices[currencyPairs["USD"]]
+	if !ok {
+		t.Fatal("expected to get USD pair")
+	}
+	if info.Price != 71.3562 {
+		t.Errorf("expected price 71.3562, got %f", info.Price)
+	}
+	if info.FaceUnit != "USD" || info.Currency != CurrencyRUB {
+		t.Errorf("expected USD quoted in RUB, got %s quoted in %s", info.FaceUnit, info.Currency)
+	}
+}
+
+// emptyBoardResp is a board without any traded securities
+const emptyBoardResp = `{"securities": {"columns": ["SECID", "SHORTNAME", "LOTSIZE", "PREVADMITTEDQUOTE"], "data": []}, "marketdata": {"columns": ["SECID", "BOARDID"], "data": []}}`
+
+// newBoardsServer serves fixtures for shares, treasuries and currency boards, other boards are empty.
+// Returned counter is incremented on every request.
+func newBoardsServer(t *testing.T) (*httptest.Server, *int32) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		switch {
+		case strings.Contains(r.URL.Path, "/boards/"+BoardStock+"/"):
+			w.Write([]byte(getAllSecuritiesPricesResp))
+		case strings.Contains(r.URL.Path, "/boards/"+BoardTreasuries+"/"):
+			w.Write([]byte(getBondsPricesResp))
+		case strings.Contains(r.URL.Path, "/boards/"+BoardCurrency+"/"):
+			w.Write([]byte(getCurrencyPricesResp))
+		default:
+			w.Write([]byte(emptyBoardResp))
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func TestMoexAPI_Get(t *testing.T) {
+	ctx := context.Background()
+	server, requests := newBoardsServer(t)
+	api := New(Opts{Client: server.Client(), BaseURL: server.URL})
+
+	info, err := api.Get(ctx, ParseSecurityRef("AFKS"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Price != 27.764 || info.LotSize != 100 || info.Market != MarketShares || info.Bond != nil {
+		t.Errorf("unexpected AFKS info %+v", info)
+	}
+	loaded := atomic.LoadInt32(requests)
+	if loaded == 0 {
+		t.Fatal("expected prices to be loaded from moex")
+	}
+
+	// everything is cached after the first miss
+	bond, err := api.Get(ctx, ParseSecurityRef("SU26207RMFS9"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bond.FaceValue != 1000 || !bond.IsBond() {
+		t.Errorf("unexpected bond info %+v", bond)
+	}
+	rate, err := api.Rate(ctx, "USD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rate != 71.3562 {
+		t.Errorf("expected USD rate 71.3562, got %f", rate)
+	}
+	if got := atomic.LoadInt32(requests); got != loaded {
+		t.Errorf("expected cached prices to be used, got %d requests instead of %d", got, loaded)
+	}
+
+	if _, err := api.Get(ctx, ParseSecurityRef("NOSUCHSECID")); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestMoexAPI_Get_aliases(t *testing.T) {
+	ctx := context.Background()
+	server, _ := newBoardsServer(t)
+	api := New(Opts{Client: server.Client(), BaseURL: server.URL})
+
+	tests := []struct {
+		id   string
+		want string
+	}{
+		{"SU26207RMFS9", "SU26207RMFS9"},
+		{"RU000A0JS1W0", "SU26207RMFS9"},
+		{"26207RMFS", "SU26207RMFS9"},
+		{"AFKS", "AFKS"},
+		{"RU000A0DQZE3", "AFKS"},
+	}
+	for _, tt := range tests {
+		info, err := api.Get(ctx, ParseSecurityRef(tt.id))
+		if err != nil {
+			t.Errorf("Get(%q): %v", tt.id, err)
+			continue
+		}
+		if info.SecID != tt.want {
+			t.Errorf("Get(%q) resolved to %s, want %s", tt.id, info.SecID, tt.want)
+		}
+	}
+
+	isin, secid := SecurityRef{SecID: "RU000A0JS1W0"}, SecurityRef{SecID: "SU26207RMFS9"}
+	infos, err := api.GetMultiple(ctx, isin, secid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if infos[isin] != infos[secid] {
+		t.Errorf("expected both identifiers to return the same security, got %+v", infos)
+	}
+}
+
+func TestMoexAPI_GetMultiple(t *testing.T) {
+	ctx := context.Background()
+	server, _ := newBoardsServer(t)
+	api := New(Opts{Client: server.Client(), BaseURL: server.URL, Cache: NewMemoryCache(DefaultMemoryCacheSize)})
+
+	afks, bond := SecurityRef{Board: BoardStock, SecID: "AFKS"}, SecurityRef{SecID: "SU26207RMFS9"}
+	infos, err := api.GetMultiple(ctx, afks, SecurityRef{SecID: "NOSUCHSECID"}, bond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 2 {
+		t.Fatalf("expected 2 securities, got %v", infos)
+	}
+	if infos[afks].ShortName != "Система ао" {
+		t.Errorf("unexpected AFKS info %+v", infos[afks])
+	}
+	if infos[bond].AccruedInt == 0 {
+		t.Errorf("expected accrued interest for bond, got %+v", infos[bond])
+	}
+}
+
+func TestMoexAPI_GetMultiple_failedRefresh(t *testing.T) {
+	ctx := context.Background()
+	var failing int32
+	boards, _ := newBoardsServer(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&failing) == 1 {
+			http.Error(w, "exchange is down", http.StatusBadGateway)
+			return
+		}
+		boards.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	api := New(Opts{Client: server.Client(), BaseURL: server.URL, MinRefreshInterval: time.Minute})
+	now := time.Date(2021, 11, 3, 12, 0, 0, 0, Moscow)
+	api.now = func() time.Time { return now }
+
+	if err := api.UpdateCache(ctx); err != nil {
+		t.Fatal(err)
+	}
+	atomic.StoreInt32(&failing, 1)
+	now = now.Add(2 * time.Minute)
+
+	afks := SecurityRef{Board: BoardStock, SecID: "AFKS"}
+	// the first lookup downloads and fails, the second one gets the same error within refresh interval
+	for _, unknown := range []string{"NOSUCHSECID", "OTHERSECID"} {
+		infos, err := api.GetMultiple(ctx, afks, SecurityRef{SecID: unknown})
+		if err != nil {
+			t.Fatalf("expected known securities despite failed refresh, got %v", err)
+		}
+		if _, ok := infos[afks]; !ok || len(infos) != 1 {
+			t.Errorf("expected only AFKS to be found, got %v", infos)
+		}
+	}
+	if _, err := api.GetMultiple(ctx, SecurityRef{SecID: "THIRDSECID"}); err == nil {
+		t.Error("expected error when nothing could be found")
+	}
+}
+
+func TestMoexAPI_Get_missStorm(t *testing.T) {
+	ctx := context.Background()
+	server, requests := newBoardsServer(t)
+	api := New(Opts{Client: server.Client(), BaseURL: server.URL, MinRefreshInterval: time.Minute, NegativeTTL: 10 * time.Minute})
+	now := time.Date(2021, 11, 3, 12, 0, 0, 0, Moscow)
+	api.now = func() time.Time { return now }
+
+	if err := api.UpdateCache(ctx); err != nil {
+		t.Fatal(err)
+	}
+	perRefresh := atomic.LoadInt32(requests)
+
+	// burst of unknown tickers right after refresh doesn't download anything
+	now = now.Add(time.Second)
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if _, err := api.Get(ctx, ParseSecurityRef(fmt.Sprintf("BAD%d", i%5))); err != ErrNotFound {
+				t.Errorf("expected ErrNotFound, got %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if got := atomic.LoadInt32(requests); got != perRefresh {
+		t.Errorf("expected no downloads within refresh interval, got %d requests", got-perRefresh)
+	}
+
+	// after refresh interval concurrent misses share one download
+	now = now.Add(time.Minute)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if _, err := api.Get(ctx, ParseSecurityRef(fmt.Sprintf("NEW%d", i%5))); err != ErrNotFound {
+				t.Errorf("expected ErrNotFound, got %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if got := atomic.LoadInt32(requests); got != 2*perRefresh {
+		t.Errorf("expected one shared download, got %d requests instead of %d", got-perRefresh, perRefresh)
+	}
+
+	// known misses are answered without download until they expire
+	now = now.Add(2 * time.Minute)
+	if _, err := api.Get(ctx, ParseSecurityRef("NEW1")); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+	if got := atomic.LoadInt32(requests); got != 2*perRefresh {
+		t.Errorf("expected negative lookup to be cached, got %d requests", got-2*perRefresh)
+	}
+	now = now.Add(10 * time.Minute)
+	if _, err := api.Get(ctx, ParseSecurityRef("NEW1")); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+	if got := atomic.LoadInt32(requests); got != 3*perRefresh {
+		t.Errorf("expected expired negative lookup to refresh, got %d requests", got-2*perRefresh)
+	}
+}
+
+func TestMoexAPI_OnUpdate(t *testing.T) {
+	ctx := context.Background()
+	server, _ := newBoardsServer(t)
+	api := New(Opts{Client: server.Client(), BaseURL: server.URL})
+	updates := make(chan struct{}, 2)
+	api.OnUpdate(func() { updates <- struct{}{} })
+
+	for i := 0; i < 2; i++ {
+		if err := api.UpdateCache(ctx); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case <-updates:
+		case <-time.After(time.Second):
+			t.Fatalf("expected hook to be called after update %d", i+1)
+		}
+	}
+}
+
+func TestMoexAPI_UpdateCache_failedBoard(t *testing.T) {
+	ctx := context.Background()
+	var failing atomic.Value
+	failing.Store("")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if board := failing.Load().(string); board != "" && strings.Contains(r.URL.Path, "/boards/"+board+"/") {
+			http.Error(w, "broken board", http.StatusInternalServerError)
+			return
+		}
+		switch {
+		case strings.Contains(r.URL.Path, "/boards/"+BoardStock+"/"):
+			w.Write([]byte(getAllSecuritiesPricesResp))
+		case strings.Contains(r.URL.Path, "/boards/"+BoardTreasuries+"/"):
+			w.Write([]byte(getBondsPricesResp))
+		case strings.Contains(r.URL.Path, "/boards/"+BoardCurrency+"/"):
+			w.Write([]byte(getCurrencyPricesResp))
+		default:
+			w.Write([]byte(emptyBoardResp))
+		}
+	}))
+	defer server.Close()
+	api := New(Opts{Client: server.Client(), BaseURL: server.URL})
+
+	if err := api.UpdateCache(ctx); err != nil {
+		t.Fatal(err)
+	}
+	before, err := api.Get(ctx, ParseSecurityRef("SU26207RMFS9"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	failing.Store(BoardTreasuries)
+	if err := api.UpdateCache(ctx); err != nil {
+		t.Fatalf("expected failed board to be skipped, got %v", err)
+	}
+	after, err := api.Get(ctx, ParseSecurityRef("SU26207RMFS9"))
+	if err != nil {
+		t.Fatalf("expected previous prices of failed board to be kept, got %v", err)
+	}
+	if after.Price != before.Price || !after.UpdatedAt.Equal(before.UpdatedAt) {
+		t.Errorf("expected previous bond info %+v, got %+v", before, after)
+	}
+	if _, err := api.Get(ctx, ParseSecurityRef("AFKS")); err != nil {
+		t.Errorf("expected other boards to be refreshed, got %v", err)
+	}
+
+	failing.Store(BoardCurrency)
+	if err := api.UpdateCache(ctx); err == nil {
+		t.Error("expected refresh to fail without exchange rates")
+	}
+}
@@ -0,0 +1,175 @@
+package moex
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Dividend is a dividend announced by issuer
+type Dividend struct {
+	// RecordDate is the date of registry closing, shareholders of that day receive the dividend
+	RecordDate time.Time
+	// Value is paid per one share
+	Value    float64
+	Currency string
+}
+
+// Coupon is a coupon of bond. Value of future floating coupons is zero until it is announced.
+type Coupon struct {
+	Date       time.Time
+	RecordDate time.Time
+	// StartDate is the beginning of the coupon period
+	StartDate time.Time
+	// Value is paid per one bond in FaceUnit currency, Percent is annual rate
+	Value     float64
+	Percent   float64
+	FaceValue float64
+	FaceUnit  string
+}
+
+// Amortization is a repayment of face value of bond, the last one is the redemption at maturity
+type Amortization struct {
+	Date time.Time
+	// Value is paid per one bond in FaceUnit currency, Percent is the part of initial face value
+	Value    float64
+	Percent  float64
+	FaceUnit string
+}
+
+// payoutsTTL is how long dividends, coupons and amortizations are cached, they are announced long before payment
+const payoutsTTL = 12 * time.Hour
+
+// Dividends returns dividends of shares, past ones included, oldest first.
+// Ref without board is resolved like in Get.
+func (api *API) Dividends(ctx context.Context, ref SecurityRef) ([]Dividend, error) {
+	info, err := api.Get(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	key := fmt.Sprintf("%sdividends:%s", cacheKeyPrefix, info.SecID)
+
+	var dividends []Dividend
+	err = api.cachedJSON(ctx, key, payoutsTTL, &dividends, func() error {
+		// all dividends are sent at once, the endpoint has no pages
+		urlStr := api.baseURL + "/iss/securities/" + url.PathEscape(info.SecID) + "/dividends.json?iss.meta=off&iss.only=dividends"
+		var respBody map[string]issTable
+		if err := api.get(ctx, urlStr, &respBody); err != nil {
+			return errors.Wrapf(err, "error while loading dividends of %s", info.SecID)
+		}
+		dividends, err = parseDividends(respBody["dividends"])
+		return err
+	})
+	return dividends, err
+}
+
+// Coupons returns all coupons of bond, paid ones included, oldest first.
+// Ref without board is resolved like in Get.
+func (api *API) Coupons(ctx context.Context, ref SecurityRef) ([]Coupon, error) {
+	info, err := api.Get(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	key := fmt.Sprintf("%scoupons:%s", cacheKeyPrefix, info.SecID)
+
+	var coupons []Coupon
+	err = api.cachedJSON(ctx, key, payoutsTTL, &coupons, func() error {
+		urlStr := api.baseURL + "/iss/statistics/engines/stock/markets/bonds/bondization/" + url.PathEscape(info.SecID) +
+			".json?iss.meta=off&iss.only=coupons,coupons.cursor"
+		table, err := api.loadPages(ctx, urlStr, "coupons")
+		if err != nil {
+			return errors.Wrapf(err, "error while loading coupons of %s", info.SecID)
+		}
+		coupons, err = parseCoupons(table)
+		return err
+	})
+	return coupons, err
+}
+
+// Amortizations returns repayments of bond face value, including the redemption at maturity, oldest first.
+// Ref without board is resolved like in Get.
+func (api *API) Amortizations(ctx context.Context, ref SecurityRef) ([]Amortization, error) {
+	info, err := api.Get(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	key := fmt.Sprintf("%samortizations:%s", cacheKeyPrefix, info.SecID)
+
+	var amortizations []Amortization
+	err = api.cachedJSON(ctx, key, payoutsTTL, &amortizations, func() error {
+		urlStr := api.baseURL + "/iss/statistics/engines/stock/markets/bonds/bondization/" + url.PathEscape(info.SecID) +
+			".json?iss.meta=off&iss.only=amortizations,amortizations.cursor"
+		table, err := api.loadPages(ctx, urlStr, "amortizations")
+		if err != nil {
+			return errors.Wrapf(err, "error while loading amortizations of %s", info.SecID)
+		}
+		amortizations, err = parseAmortizations(table)
+		return err
+	})
+	return amortizations, err
+}
+
+func parseDividends(table issTable) ([]Dividend, error) {
+	columns := table.index("registryclosedate", "value", "currencyid")
+	if columns == nil {
+		if len(table.Data) == 0 { // ISS sends no columns for securities without dividends
+			return nil, nil
+		}
+		return nil, errors.Errorf("unexpected columns of dividends %v", table.Columns)
+	}
+	dividends := make([]Dividend, 0, len(table.Data))
+	for _, row := range table.Data {
+		dividends = append(dividends, Dividend{
+			RecordDate: parseDate(optionalString(row, columns["registryclosedate"])),
+			Value:      optionalFloat(row, columns["value"]),
+			Currency:   normalizeCurrency(optionalString(row, columns["currencyid"])),
+		})
+	}
+	return dividends, nil
+}
+
+func parseCoupons(table issTable) ([]Coupon, error) {
+	columns := table.index("coupondate", "recorddate", "startdate", "value", "valueprc", "facevalue", "faceunit")
+	if columns == nil {
+		if len(table.Data) == 0 {
+			return nil, nil
+		}
+		return nil, errors.Errorf("unexpected columns of coupons %v", table.Columns)
+	}
+	coupons := make([]Coupon, 0, len(table.Data))
+	for _, row := range table.Data {
+		coupons = append(coupons, Coupon{
+			Date:       parseDate(optionalString(row, columns["coupondate"])),
+			RecordDate: parseDate(optionalString(row, columns["recorddate"])),
+			StartDate:  parseDate(optionalString(row, columns["startdate"])),
+			Value:      optionalFloat(row, columns["value"]),
+			Percent:    optionalFloat(row, columns["valueprc"]),
+			FaceValue:  optionalFloat(row, columns["facevalue"]),
+			FaceUnit:   normalizeCurrency(optionalString(row, columns["faceunit"])),
+		})
+	}
+	return coupons, nil
+}
+
+func parseAmortizations(table issTable) ([]Amortization, error) {
+	columns := table.index("amortdate", "value", "valueprc", "faceunit")
+	if columns == nil {
+		if len(table.Data) == 0 {
+			return nil, nil
+		}
+		return nil, errors.Errorf("unexpected columns of amortizations %v", table.Columns)
+	}
+	amortizations := make([]Amortization, 0, len(table.Data))
+	for _, row := range table.Data {
+		amortizations = append(amortizations, Amortization{
+			Date:     parseDate(optionalString(row, columns["amortdate"])),
+			Value:    optionalFloat(row, columns["value"]),
+			Percent:  optionalFloat(row, columns["valueprc"]),
+			FaceUnit: normalizeCurrency(optionalString(row, columns["faceunit"])),
+		})
+	}
+	return amortizations, nil
+}
@@ -0,0 +1,119 @@
+package moex
+
+import (
+	"context"
+	_ "embed"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+//go:embed test-dividends.json
+var getDividendsResp string
+
+//go:embed test-bondization.json
+var getBondizationResp string
+
+// newPayoutsServer serves dividends of AFKS and bondization of SU26238RMFS4, prices are served like by newBoardsServer.
+// Returned counter is incremented on every request of payouts.
+func newPayoutsServer(t *testing.T) (*httptest.Server, *int32) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/iss/securities/AFKS/dividends.json":
+			atomic.AddInt32(&requests, 1)
+			w.Write([]byte(getDividendsResp))
+		case r.URL.Path == "/iss/statistics/engines/stock/markets/bonds/bondization/SU26238RMFS4.json":
+			atomic.AddInt32(&requests, 1)
+			w.Write([]byte(getBondizationResp))
+		case strings.Contains(r.URL.Path, "/boards/"+BoardStock+"/"):
+			w.Write([]byte(getAllSecuritiesPricesResp))
+		case strings.Contains(r.URL.Path, "/boards/"+BoardTreasuries+"/"):
+			w.Write([]byte(getBondsPricesResp))
+		case strings.Contains(r.URL.Path, "/boards/"+BoardCurrency+"/"):
+			w.Write([]byte(getCurrencyPricesResp))
+		default:
+			w.Write([]byte(emptyBoardResp))
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func TestMoexAPI_Dividends(t *testing.T) {
+	ctx := context.Background()
+	server, requests := newPayoutsServer(t)
+	api := New(Opts{Client: server.Client(), BaseURL: server.URL})
+
+	dividends, err := api.Dividends(ctx, SecurityRef{SecID: "AFKS"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dividends) != 3 {
+		t.Fatalf("expected 3 dividends, got %d", len(dividends))
+	}
+	last := dividends[2]
+	if want := time.Date(2022, 7, 8, 0, 0, 0, 0, Moscow); !last.RecordDate.Equal(want) || last.Value != 0.45 || last.Currency != CurrencyRUB {
+		t.Errorf("unexpected dividend %+v", last)
+	}
+
+	if _, err := api.Dividends(ctx, SecurityRef{BoardStock, "AFKS"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := atomic.LoadInt32(requests); got != 1 {
+		t.Errorf("expected cached dividends to be used, got %d requests", got)
+	}
+	if _, err := api.Dividends(ctx, SecurityRef{SecID: "NOSUCHSECID"}); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestMoexAPI_Coupons(t *testing.T) {
+	ctx := context.Background()
+	server, requests := newPayoutsServer(t)
+	api := New(Opts{Client: server.Client(), BaseURL: server.URL})
+
+	coupons, err := api.Coupons(ctx, SecurityRef{SecID: "SU26238RMFS4"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(coupons) != 3 {
+		t.Fatalf("expected 3 coupons, got %d", len(coupons))
+	}
+	// cursor tells that all coupons are received
+	if got := atomic.LoadInt32(requests); got != 1 {
+		t.Errorf("expected 1 request, got %d", got)
+	}
+	second := coupons[1]
+	if want := time.Date(2022, 6, 1, 0, 0, 0, 0, Moscow); !second.Date.Equal(want) {
+		t.Errorf("expected coupon date %v, got %v", want, second.Date)
+	}
+	if want := time.Date(2022, 5, 31, 0, 0, 0, 0, Moscow); !second.RecordDate.Equal(want) {
+		t.Errorf("expected record date %v, got %v", want, second.RecordDate)
+	}
+	if second.Value != 35.4 || second.Percent != 7.1 || second.FaceValue != 1000 || second.FaceUnit != CurrencyRUB {
+		t.Errorf("unexpected coupon %+v", second)
+	}
+}
+
+func TestMoexAPI_Amortizations(t *testing.T) {
+	ctx := context.Background()
+	server, requests := newPayoutsServer(t)
+	api := New(Opts{Client: server.Client(), BaseURL: server.URL})
+
+	amortizations, err := api.Amortizations(ctx, SecurityRef{BoardTreasuries, "SU26238RMFS4"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := atomic.LoadInt32(requests); got != 1 {
+		t.Errorf("expected 1 request, got %d", got)
+	}
+	want := []Amortization{{Date: time.Date(2041, 5, 15, 0, 0, 0, 0, Moscow), Value: 1000, Percent: 100, FaceUnit: CurrencyRUB}}
+	if !reflect.DeepEqual(amortizations, want) {
+		t.Errorf("expected %+v, got %+v", want, amortizations)
+	}
+}
@@ -0,0 +1,62 @@
+package moex
+
+import (
+	"sort"
+	"strings"
+)
+
+// SecurityRef identifies security traded on a board. The same SECID may be traded on several boards
+// with different prices and lot sizes, so SECID alone is not enough.
+type SecurityRef struct {
+	Board string
+	SecID string
+}
+
+const refSep = ":"
+
+// ParseSecurityRef reads ref in "BOARD:SECID" format. String without board is parsed to ref with empty Board,
+// such ref matches security by SECID, ISIN or registration number on any board.
+func ParseSecurityRef(s string) SecurityRef {
+	s = strings.TrimSpace(s)
+	if i := strings.Index(s, refSep); i >= 0 {
+		return SecurityRef{Board: s[:i], SecID: s[i+len(refSep):]}
+	}
+	return SecurityRef{SecID: s}
+}
+
+// String returns ref in "BOARD:SECID" format, it is parsed back by ParseSecurityRef
+func (r SecurityRef) String() string {
+	if r.Board == "" {
+		return r.SecID
+	}
+	return r.Board + refSep + r.SecID
+}
+
+// IsExact reports whether ref points to the board
+func (r SecurityRef) IsExact() bool {
+	return r.Board != ""
+}
+
+// AmbiguousError is returned when ref without board matches securities on several boards
+type AmbiguousError struct {
+	Ref SecurityRef
+	// Candidates are sorted by board
+	Candidates []SecurityRef
+}
+
+func (e *AmbiguousError) Error() string {
+	candidates := make([]string, 0, len(e.Candidates))
+	for _, c := range e.Candidates {
+		candidates = append(candidates, c.String())
+	}
+	return e.Ref.String() + " is ambiguous, it matches " + strings.Join(candidates, ", ")
+}
+
+func sortRefs(refs []SecurityRef) {
+	sort.Slice(refs, func(i, j int) bool {
+		if refs[i].Board != refs[j].Board {
+			return refs[i].Board < refs[j].Board
+		}
+		return refs[i].SecID < refs[j].SecID
+	})
+}
@@ -0,0 +1,85 @@
+package moex
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestParseSecurityRef(t *testing.T) {
+	tests := []struct {
+		in   string
+		want SecurityRef
+	}{
+		{"TQBR:SBER", SecurityRef{BoardStock, "SBER"}},
+		{" FQBR:AAPL-RM ", SecurityRef{BoardForeignStock, "AAPL-RM"}},
+		{"RU000A0JS1W0", SecurityRef{SecID: "RU000A0JS1W0"}},
+	}
+	for _, tt := range tests {
+		got := ParseSecurityRef(tt.in)
+		if got != tt.want {
+			t.Errorf("ParseSecurityRef(%q) = %#v, want %#v", tt.in, got, tt.want)
+		}
+		if back := ParseSecurityRef(got.String()); back != got {
+			t.Errorf("expected %q to be parsed back to %#v, got %#v", got.String(), got, back)
+		}
+	}
+}
+
+func TestMoexAPI_Get_ambiguous(t *testing.T) {
+	ctx := context.Background()
+	// the fixture has shares of all boards, each board takes its own rows
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte(getAllSecuritiesPricesResp))
+	}))
+	t.Cleanup(server.Close)
+	api := New(Opts{
+		Client:  server.Client(),
+		BaseURL: server.URL,
+		Boards:  []Board{{EngineStock, MarketShares, BoardIndex}, {EngineStock, MarketShares, "TQTD"}, {EngineStock, MarketShares, BoardStock}},
+	})
+
+	// the same ETF is traded for rubles and for dollars
+	candidates := []SecurityRef{{"TQTD", "AKSP"}, {BoardIndex, "AKSP"}}
+	for _, id := range []string{"AKSP", "RU000A1006V3", "3691"} {
+		_, err := api.Get(ctx, ParseSecurityRef(id))
+		var ambiguous *AmbiguousError
+		if !errors.As(err, &ambiguous) {
+			t.Fatalf("expected %s to be ambiguous, got %v", id, err)
+		}
+		if !reflect.DeepEqual(ambiguous.Candidates, candidates) {
+			t.Errorf("expected candidates %v, got %v", candidates, ambiguous.Candidates)
+		}
+	}
+
+	tests := []struct {
+		ref      SecurityRef
+		currency string
+	}{
+		{SecurityRef{BoardIndex, "AKSP"}, CurrencyRUB},
+		{SecurityRef{"TQTD", "AKSP"}, "USD"},
+		{SecurityRef{"TQTD", "RU000A1006V3"}, "USD"},
+	}
+	for _, tt := range tests {
+		info, err := api.Get(ctx, tt.ref)
+		if err != nil {
+			t.Errorf("Get(%s): %v", tt.ref, err)
+			continue
+		}
+		if info.Ref() != (SecurityRef{tt.ref.Board, "AKSP"}) || info.Currency != tt.currency {
+			t.Errorf("Get(%s) = %s in %s, want %s", tt.ref, info.Ref(), info.Currency, tt.currency)
+		}
+	}
+
+	infos, err := api.GetMultiple(ctx, SecurityRef{SecID: "AKSP"}, SecurityRef{SecID: "AFKS"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 1 {
+		t.Errorf("expected ambiguous ref to be skipped, got %v", infos)
+	}
+}
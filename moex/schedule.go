@@ -0,0 +1,141 @@
+package moex
+
+import (
+	"log"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Session is a trading session, Open and Close are offsets from midnight in Moscow time
+type Session struct {
+	Open  time.Duration
+	Close time.Duration
+}
+
+// Schedule tells when prices change, so that they are downloaded only when needed
+type Schedule struct {
+	// Sessions are sorted by Open and don't overlap
+	Sessions []Session
+	// Interval between downloads during session, zero means prices are downloaded only at open and after close
+	Interval time.Duration
+	// CloseDelay is how long after session close final prices are downloaded
+	CloseDelay time.Duration
+	// Holidays are non-trading weekdays in "2006-01-02" format
+	Holidays map[string]bool
+}
+
+const dateLayout = "2006-01-02"
+
+// DefaultSchedule is the schedule of stock market: main session 10:00-18:50 and evening session 19:05-23:50
+func DefaultSchedule() Schedule {
+	return Schedule{
+		Sessions: []Session{
+			{Open: 10 * time.Hour, Close: 18*time.Hour + 50*time.Minute},
+			{Open: 19*time.Hour + 5*time.Minute, Close: 23*time.Hour + 50*time.Minute},
+		},
+		Interval:   5 * time.Minute,
+		CloseDelay: 5 * time.Minute,
+	}
+}
+
+// ParseHolidays reads dates in "2006-01-02" format
+func ParseHolidays(dates []string) (map[string]bool, error) {
+	holidays := make(map[string]bool, len(dates))
+	for _, d := range dates {
+		if _, err := time.Parse(dateLayout, d); err != nil {
+			return nil, errors.Wrapf(err, "invalid holiday %q", d)
+		}
+		holidays[d] = true
+	}
+	return holidays, nil
+}
+
+// IsTradingDay reports whether exchange works on the day of t in Moscow
+func (s Schedule) IsTradingDay(t time.Time) bool {
+	t = t.In(Moscow)
+	switch t.Weekday() {
+	case time.Saturday, time.Sunday:
+		return false
+	}
+	return !s.Holidays[t.Format(dateLayout)]
+}
+
+// maxIdleDays is more than any exchange holidays last
+const maxIdleDays = 30
+
+// Next returns time of the next download after now
+func (s Schedule) Next(now time.Time) time.Time {
+	now = now.In(Moscow)
+	for day := 0; day < maxIdleDays; day++ {
+		date := time.Date(now.Year(), now.Month(), now.Day()+day, 0, 0, 0, 0, Moscow)
+		if !s.IsTradingDay(date) {
+			continue
+		}
+		for _, session := range s.Sessions {
+			open, closed := date.Add(session.Open), date.Add(session.Close)
+			final := closed.Add(s.CloseDelay)
+			if now.Before(open) {
+				return open
+			}
+			if now.Before(closed) && s.Interval > 0 {
+				if next := now.Add(s.Interval); next.Before(final) {
+					return next
+				}
+			}
+			if now.Before(final) {
+				return final
+			}
+		}
+	}
+	return now.Add(24 * time.Hour)
+}
+
+// StartRefresher downloads prices in background according to schedule until Stop is called.
+// Previous close price doesn't change during session, so for it prices are downloaded only at open and after close.
+func (api *API) StartRefresher(schedule Schedule) {
+	if !api.priceSource.IsLive() {
+		schedule.Interval = 0
+	}
+	api.mu.Lock()
+	api.schedule = &schedule
+	api.mu.Unlock()
+
+	api.refresherDone = make(chan struct{})
+	go func() {
+		defer close(api.refresherDone)
+		for {
+			next := schedule.Next(api.now())
+			timer := time.NewTimer(time.Until(next))
+			select {
+			case <-api.ctx.Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+			if err := api.UpdateCache(api.ctx); err != nil && api.ctx.Err() == nil {
+				log.Printf("[ERROR] while refreshing prices: %v\n", err)
+			}
+		}
+	}()
+}
+
+// Stop stops refresher and cancels downloads in progress
+func (api *API) Stop() {
+	api.cancel()
+	if api.refresherDone != nil {
+		<-api.refresherDone
+	}
+}
+
+// scheduledTTL keeps prices until the next scheduled download, which may be days later on weekends
+func (api *API) scheduledTTL() (time.Duration, bool) {
+	api.mu.Lock()
+	schedule := api.schedule
+	api.mu.Unlock()
+	if schedule == nil {
+		return 0, false
+	}
+	now := api.now()
+	return schedule.Next(now).Sub(now) + refreshTimeout, true
+}
@@ -0,0 +1,85 @@
+package moex
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSchedule_Next(t *testing.T) {
+	schedule := DefaultSchedule()
+	schedule.Holidays = map[string]bool{"2021-11-04": true}
+
+	at := func(day, hour, min int) time.Time {
+		return time.Date(2021, 11, day, hour, min, 0, 0, Moscow)
+	}
+	tests := []struct {
+		name     string
+		now      time.Time
+		interval time.Duration
+		want     time.Time
+	}{
+		{"before main session", at(3, 7, 0), 5 * time.Minute, at(3, 10, 0)},
+		{"main session", at(3, 12, 0), 5 * time.Minute, at(3, 12, 5)},
+		{"main session end", at(3, 18, 48), 5 * time.Minute, at(3, 18, 53)},
+		{"waiting for final prices", at(3, 18, 52), 5 * time.Minute, at(3, 18, 55)},
+		{"after main close", at(3, 18, 56), 5 * time.Minute, at(3, 19, 5)},
+		{"evening session", at(3, 20, 0), 5 * time.Minute, at(3, 20, 5)},
+		{"after evening close", at(3, 23, 53), 5 * time.Minute, at(3, 23, 55)},
+		{"night before holiday", at(3, 23, 56), 5 * time.Minute, at(5, 10, 0)},
+		{"friday night", at(5, 23, 56), 5 * time.Minute, at(8, 10, 0)},
+		{"weekend", at(6, 12, 0), 5 * time.Minute, at(8, 10, 0)},
+		{"prev close during session", at(3, 12, 0), 0, at(3, 18, 55)},
+		{"utc time", time.Date(2021, 11, 3, 9, 0, 0, 0, time.UTC), 5 * time.Minute, at(3, 12, 5)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := schedule
+			s.Interval = tt.interval
+			if got := s.Next(tt.now); !got.Equal(tt.want) {
+				t.Errorf("expected next refresh at %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestParseHolidays(t *testing.T) {
+	holidays, err := ParseHolidays([]string{"2022-01-03", "2022-01-07"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !holidays["2022-01-07"] || len(holidays) != 2 {
+		t.Errorf("unexpected holidays %v", holidays)
+	}
+	if _, err := ParseHolidays([]string{"07.01.2022"}); err == nil {
+		t.Error("expected error for invalid date")
+	}
+}
+
+func TestMoexAPI_StartRefresher(t *testing.T) {
+	server, requests := newBoardsServer(t)
+	api := New(Opts{Client: server.Client(), BaseURL: server.URL, PriceSource: PriceSourceLast})
+
+	// pretend it is Wednesday noon whenever the test runs
+	start, base := time.Now(), time.Date(2021, 11, 3, 12, 0, 0, 0, Moscow)
+	api.now = func() time.Time { return base.Add(time.Since(start)) }
+
+	schedule := DefaultSchedule()
+	schedule.Interval = 20 * time.Millisecond
+	api.StartRefresher(schedule)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt32(requests) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	api.Stop()
+	stopped := atomic.LoadInt32(requests)
+	if stopped == 0 {
+		t.Fatal("expected refresher to download prices")
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if got := atomic.LoadInt32(requests); got != stopped {
+		t.Errorf("expected no downloads after Stop, got %d", got-stopped)
+	}
+}
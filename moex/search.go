@@ -0,0 +1,219 @@
+package moex
+
+import (
+	"context"
+	"log"
+	"net/url"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+// SearchResult is a security matching search query, only securities with known prices are returned
+type SearchResult struct {
+	Ref       SecurityRef
+	ShortName string
+	ISIN      string
+	// Score is from 0 to 1, exact SECID match has score 1
+	Score float64
+}
+
+// scores of different kinds of matches
+const (
+	scoreSecID       = 1
+	scoreISIN        = 0.95
+	scoreName        = 0.9
+	scoreSecIDPrefix = 0.8
+	scoreNamePrefix  = 0.75
+	scoreNamePart    = 0.7
+	scoreTypo        = 0.6
+	scoreTwoTypos    = 0.5
+	scoreNameTypo    = 0.4
+	scoreISS         = 0.3
+)
+
+// minISSQueryLen is the shortest query ISS search accepts
+const minISSQueryLen = 3
+
+// Search looks for securities by SECID, ISIN, registration number, short or latin name, tolerating small typos.
+// When nothing is found locally, ISS full text search is used. Results are sorted by Score.
+func (api *API) Search(ctx context.Context, query string, limit int) ([]SearchResult, error) {
+	q := normalizeQuery(query)
+	if q == "" {
+		return nil, nil
+	}
+	snap, err := api.currentSnapshot(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(snap.Securities) == 0 {
+		if err := api.refresh(ctx, false); err != nil {
+			return nil, err
+		}
+		if snap, err = api.currentSnapshot(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	results := snap.search(q)
+	if len(results) == 0 && utf8.RuneCountInString(q) >= minISSQueryLen {
+		results, err = api.searchISS(ctx, query, snap)
+		if err != nil {
+			log.Printf("[ERROR] while searching %q in ISS: %v\n", query, err)
+		}
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].Ref.String() < results[j].Ref.String()
+	})
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+	return results, nil
+}
+
+// searchEntry is a security prepared for matching
+type searchEntry struct {
+	key       string
+	secid     string
+	isin      string
+	regNumber string
+	names     []string
+	firstWord []string
+}
+
+func (s *snapshot) searchIndex() []searchEntry {
+	s.indexOnce.Do(func() {
+		s.index = make([]searchEntry, 0, len(s.Securities))
+		for key, info := range s.Securities {
+			e := searchEntry{key: key, secid: info.SecID, isin: normalizeQuery(info.ISIN), regNumber: normalizeQuery(info.RegNumber)}
+			for _, name := range []string{info.ShortName, info.LatName} {
+				if name = normalizeQuery(name); name != "" {
+					e.names = append(e.names, name)
+					e.firstWord = append(e.firstWord, strings.Fields(name)[0])
+				}
+			}
+			s.index = append(s.index, e)
+		}
+	})
+	return s.index
+}
+
+func (s *snapshot) search(q string) []SearchResult {
+	var results []SearchResult
+	for _, e := range s.searchIndex() {
+		if score := e.score(q); score > 0 {
+			info := s.Securities[e.key]
+			results = append(results, SearchResult{Ref: info.Ref(), ShortName: info.ShortName, ISIN: info.ISIN, Score: score})
+		}
+	}
+	return results
+}
+
+func (e searchEntry) score(q string) float64 {
+	switch {
+	case e.secid == q:
+		return scoreSecID
+	case e.isin != "" && e.isin == q, e.regNumber != "" && e.regNumber == q:
+		return scoreISIN
+	}
+	var best float64
+	for i, name := range e.names {
+		switch {
+		case name == q:
+			return scoreName
+		case strings.HasPrefix(name, q):
+			best = maxFloat(best, scoreNamePrefix)
+		case utf8.RuneCountInString(q) >= 2 && strings.Contains(name, q):
+			best = maxFloat(best, scoreNamePart)
+		case utf8.RuneCountInString(q) >= 4 && levenshtein(e.firstWord[i], q) <= 1:
+			best = maxFloat(best, scoreNameTypo)
+		}
+	}
+	if strings.HasPrefix(e.secid, q) {
+		best = maxFloat(best, scoreSecIDPrefix)
+	}
+	if n := utf8.RuneCountInString(q); n >= 3 {
+		switch d := levenshtein(e.secid, q); {
+		case d <= 1:
+			best = maxFloat(best, scoreTypo)
+		case d <= 2 && n >= 5:
+			best = maxFloat(best, scoreTwoTypos)
+		}
+	}
+	return best
+}
+
+// searchISS uses ISS full text search, which also matches full names of issuers
+func (api *API) searchISS(ctx context.Context, query string, snap *snapshot) ([]SearchResult, error) {
+	urlStr := api.baseURL + "/iss/securities.json?iss.meta=off&iss.only=securities&securities.columns=secid,shortname,isin&q=" + url.QueryEscape(query)
+
+	var respBody struct {
+		Securities issTable `json:"securities"`
+	}
+	if err := api.get(ctx, urlStr, &respBody); err != nil {
+		return nil, errors.Wrap(err, "error while searching securities")
+	}
+	columns := respBody.Securities.index("secid", "shortname", "isin")
+	if columns == nil {
+		return nil, errors.New("unexpected columns of securities")
+	}
+
+	var results []SearchResult
+	for _, row := range respBody.Securities.Data {
+		secid := optionalString(row, columns["secid"])
+		// security not traded on loaded boards can't be priced and is skipped
+		for _, ref := range snap.onBoards(secid) {
+			info := snap.Securities[ref.String()]
+			results = append(results, SearchResult{Ref: ref, ShortName: info.ShortName, ISIN: info.ISIN, Score: scoreISS})
+		}
+	}
+	return results, nil
+}
+
+func normalizeQuery(s string) string {
+	return strings.ReplaceAll(strings.ToUpper(strings.TrimSpace(s)), "Ё", "Е")
+}
+
+// levenshtein returns edit distance between a and b counted in runes
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = minInt(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
+
+func maxFloat(a, b float64) float64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func minInt(values ...int) int {
+	m := values[0]
+	for _, v := range values[1:] {
+		if v < m {
+			m = v
+		}
+	}
+	return m
+}
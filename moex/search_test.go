@@ -0,0 +1,83 @@
+package moex
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// issSearchResp is a cut of /iss/securities.json?q=ПАО Система
+const issSearchResp = `{"securities": {"columns": ["secid", "shortname", "isin"], "data": [
+    ["AFKS", "Система ао", "RU000A0DQZE3"],
+    ["RU000A0JXN05", "Система 1P7", "RU000A0JXN05"]
+]}}`
+
+func TestMoexAPI_Search(t *testing.T) {
+	ctx := context.Background()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/iss/securities.json":
+			w.Write([]byte(issSearchResp))
+		case strings.Contains(r.URL.Path, "/boards/"+BoardStock+"/"):
+			w.Write([]byte(getAllSecuritiesPricesResp))
+		case strings.Contains(r.URL.Path, "/boards/"+BoardTreasuries+"/"):
+			w.Write([]byte(getBondsPricesResp))
+		default:
+			w.Write([]byte(emptyBoardResp))
+		}
+	}))
+	t.Cleanup(server.Close)
+	api := New(Opts{Client: server.Client(), BaseURL: server.URL})
+
+	tests := []struct {
+		query string
+		want  string
+		score float64
+	}{
+		{"afks", "AFKS", scoreSecID},
+		{"RU000A0DQZE3", "AFKS", scoreISIN},
+		{"RU000A0JS1W0", "SU26207RMFS9", scoreISIN},
+		{"система", "AFKS", scoreNamePrefix},
+		{"afk sistema", "AFKS", scoreName},
+		{"afksd", "AFKS", scoreTypo},
+		{"сбербнк", "SBER", scoreNameTypo},
+		{"ПАО Система", "AFKS", scoreISS},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			results, err := api.Search(ctx, tt.query, 5)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(results) == 0 {
+				t.Fatal("expected to find something")
+			}
+			if results[0].Ref.SecID != tt.want || results[0].Score != tt.score {
+				t.Errorf("expected %s with score %.2f first, got %+v", tt.want, tt.score, results)
+			}
+			if len(results) > 5 {
+				t.Errorf("expected at most 5 results, got %d", len(results))
+			}
+		})
+	}
+}
+
+func TestLevenshtein(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"SBER", "SBER", 0},
+		{"SBER", "SBERP", 1},
+		{"SBER", "SEBR", 2},
+		{"СБЕРБАНК", "СБЕРБНК", 1},
+		{"", "GAZP", 4},
+	}
+	for _, tt := range tests {
+		if got := levenshtein(tt.a, tt.b); got != tt.want {
+			t.Errorf("levenshtein(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package moex
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// snapshotKey has format version in it, so blobs written by incompatible version are never read
+const snapshotKey = cacheKeyPrefix + "snapshot:v6"
+
+// snapshot is all prices downloaded by one refresh, boards failed to load keep prices of the previous one.
+// It is cached as one blob, so readers never see a partially written refresh.
+type snapshot struct {
+	// Version grows with every refresh, older snapshot never replaces newer one
+	Version   int64     `json:"version"`
+	ExpiresAt time.Time `json:"expires_at"`
+	// Securities are keyed by SecurityRef.String()
+	Securities map[string]StockInfo `json:"securities"`
+
+	// bySecID and byAlias are built on first lookup of ref without board
+	lookupOnce sync.Once
+	bySecID    map[string][]SecurityRef
+	byAlias    map[string][]SecurityRef
+
+	// index is built on first search
+	indexOnce sync.Once
+	index     []searchEntry
+}
+
+var emptySnapshot = &snapshot{}
+
+// find returns security ref points to. Ref without board is looked up by SECID on all boards,
+// then by ISIN and registration number. Several matches are reported with AmbiguousError.
+func (s *snapshot) find(ref SecurityRef) (StockInfo, error) {
+	if ref.IsExact() {
+		if info, ok := s.Securities[ref.String()]; ok {
+			return info, nil
+		}
+	}
+	s.lookupOnce.Do(s.buildLookup)
+	found := s.bySecID[ref.SecID]
+	if len(found) == 0 {
+		found = s.byAlias[ref.SecID]
+	}
+	if ref.IsExact() { // board is known, but security is referred by alias
+		var onBoard []SecurityRef
+		for _, f := range found {
+			if f.Board == ref.Board {
+				onBoard = append(onBoard, f)
+			}
+		}
+		found = onBoard
+	}
+	switch len(found) {
+	case 0:
+		return StockInfo{}, ErrNotFound
+	case 1:
+		return s.Securities[found[0].String()], nil
+	}
+	return StockInfo{}, &AmbiguousError{Ref: ref, Candidates: found}
+}
+
+// onBoards returns all refs of SECID
+func (s *snapshot) onBoards(secid string) []SecurityRef {
+	s.lookupOnce.Do(s.buildLookup)
+	return s.bySecID[secid]
+}
+
+func (s *snapshot) buildLookup() {
+	s.bySecID = make(map[string][]SecurityRef, len(s.Securities))
+	s.byAlias = make(map[string][]SecurityRef, 2*len(s.Securities))
+	for _, info := range s.Securities {
+		ref := info.Ref()
+		s.bySecID[info.SecID] = append(s.bySecID[info.SecID], ref)
+		s.byAlias[info.ISIN] = append(s.byAlias[info.ISIN], ref)
+		if info.RegNumber != info.ISIN {
+			s.byAlias[info.RegNumber] = append(s.byAlias[info.RegNumber], ref)
+		}
+	}
+	delete(s.byAlias, "")
+	for _, refs := range s.bySecID {
+		sortRefs(refs)
+	}
+	for _, refs := range s.byAlias {
+		sortRefs(refs)
+	}
+}
+
+func (s *snapshot) expired(now time.Time) bool {
+	return !now.Before(s.ExpiresAt)
+}
+
+// cacheSnapshot stores snapshot in cache and makes it visible to readers
+func (api *API) cacheSnapshot(ctx context.Context, snap *snapshot) error {
+	v, err := json.Marshal(snap)
+	if err != nil {
+		return errors.Wrap(err, "error while encoding prices")
+	}
+	if err := api.cache.Set(ctx, snapshotKey, v, snap.ExpiresAt.Sub(api.now())); err != nil {
+		return errors.Wrap(err, "error while caching prices")
+	}
+	api.swapSnapshot(snap)
+	return nil
+}
+
+func (api *API) swapSnapshot(snap *snapshot) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	if api.snapshot == nil || api.snapshot.Version <= snap.Version {
+		api.snapshot = snap
+	}
+}
+
+// currentSnapshot returns decoded snapshot kept in memory, or loads it from cache when it is expired.
+// The cache may be filled by previous run of the bot or by another instance sharing it.
+func (api *API) currentSnapshot(ctx context.Context) (*snapshot, error) {
+	now := api.now()
+	api.mu.Lock()
+	snap := api.snapshot
+	api.mu.Unlock()
+	if snap != nil && !snap.expired(now) {
+		return snap, nil
+	}
+
+	v, err := api.cache.Get(ctx, snapshotKey)
+	if err != nil {
+		if errors.Is(err, ErrCacheMiss) {
+			return emptySnapshot, nil
+		}
+		return nil, errors.Wrap(err, "error while retriving from cache")
+	}
+	var cached snapshot
+	if err := json.Unmarshal(v, &cached); err != nil {
+		log.Printf("[ERROR] while decoding cached prices, they will be downloaded again: %v\n", err)
+		return emptySnapshot, nil
+	}
+	if cached.expired(now) {
+		return emptySnapshot, nil
+	}
+	api.swapSnapshot(&cached)
+	return &cached, nil
+}
@@ -0,0 +1,160 @@
+package moex
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestMoexAPI_snapshot(t *testing.T) {
+	ctx := context.Background()
+	var failCurrency int32
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		switch {
+		case strings.Contains(r.URL.Path, "/boards/"+BoardStock+"/"):
+			w.Write([]byte(getAllSecuritiesPricesResp))
+		case strings.Contains(r.URL.Path, "/boards/"+BoardCurrency+"/"):
+			if atomic.LoadInt32(&failCurrency) == 1 {
+				w.Write([]byte("{"))
+				return
+			}
+			w.Write([]byte(getCurrencyPricesResp))
+		default:
+			w.Write([]byte(emptyBoardResp))
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	cache := NewMemoryCache(DefaultMemoryCacheSize)
+	api := New(Opts{Client: server.Client(), BaseURL: server.URL, Cache: cache})
+	if err := api.UpdateCache(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if cache.Len() != 1 {
+		t.Errorf("expected one cached blob, got %d entries", cache.Len())
+	}
+
+	// failed board keeps previous snapshot as a whole
+	atomic.StoreInt32(&failCurrency, 1)
+	if err := api.UpdateCache(ctx); err == nil {
+		t.Fatal("expected refresh to fail")
+	}
+	infos, err := api.GetMultiple(ctx, SecurityRef{SecID: "AFKS"}, SecurityRef{Board: BoardCurrency, SecID: currencyPairs["USD"]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 2 {
+		t.Errorf("expected previous snapshot to stay, got %v", infos)
+	}
+
+	// another instance sharing the cache doesn't download anything
+	before := atomic.LoadInt32(&requests)
+	other := New(Opts{Client: server.Client(), BaseURL: server.URL, Cache: cache})
+	info, err := other.Get(ctx, SecurityRef{SecID: "AFKS"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Price != 27.764 {
+		t.Errorf("expected cached AFKS price 27.764, got %f", info.Price)
+	}
+	if got := atomic.LoadInt32(&requests); got != before {
+		t.Errorf("expected cached snapshot to be used, got %d requests", got-before)
+	}
+}
+
+// loadFixtureSnapshot parses embedded shares board like refresh does
+func loadFixtureSnapshot(b *testing.B) *snapshot {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte(getAllSecuritiesPricesResp))
+	}))
+	b.Cleanup(server.Close)
+	api := New(Opts{Client: server.Client(), BaseURL: server.URL})
+	data, err := api.loadSecuritiesPrices(context.Background(), EngineStock, MarketShares, BoardStock)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return newSnapshot(api.now(), api.cacheTTL(), []map[string]StockInfo{data})
+}
+
+func BenchmarkMoexAPI_cacheSnapshot(b *testing.B) {
+	ctx := context.Background()
+	snap := loadFixtureSnapshot(b)
+	api := New(Opts{})
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := api.cacheSnapshot(ctx, snap); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkMoexAPI_cachePerSecurity is the previous layout with one cache entry per security, kept for comparison
+func BenchmarkMoexAPI_cachePerSecurity(b *testing.B) {
+	ctx := context.Background()
+	snap := loadFixtureSnapshot(b)
+	cache := NewMemoryCache(DefaultMemoryCacheSize)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for key, info := range snap.Securities {
+			v, err := json.Marshal(info)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if err := cache.Set(ctx, cacheKeyPrefix+key, v, 0); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkMoexAPI_GetMultiple(b *testing.B) {
+	ctx := context.Background()
+	snap := loadFixtureSnapshot(b)
+	api := New(Opts{})
+	if err := api.cacheSnapshot(ctx, snap); err != nil {
+		b.Fatal(err)
+	}
+	var refs []SecurityRef
+	for _, secid := range []string{"AFKS", "SBER", "GAZP", "LKOH", "MGNT", "MTSS", "NLMK", "ROSN", "VTBR", "YNDX"} {
+		refs = append(refs, SecurityRef{Board: BoardStock, SecID: secid})
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		infos, err := api.GetMultiple(ctx, refs...)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if len(infos) == 0 {
+			b.Fatal("expected securities to be found")
+		}
+	}
+}
+
+// BenchmarkMoexAPI_GetMultiple_coldCache decodes snapshot blob on every call, like a restarted bot does once
+func BenchmarkMoexAPI_GetMultiple_coldCache(b *testing.B) {
+	ctx := context.Background()
+	snap := loadFixtureSnapshot(b)
+	api := New(Opts{})
+	if err := api.cacheSnapshot(ctx, snap); err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		api.mu.Lock()
+		api.snapshot = nil
+		api.mu.Unlock()
+		if _, err := api.GetMultiple(ctx, SecurityRef{SecID: "AFKS"}, SecurityRef{SecID: "SBER"}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
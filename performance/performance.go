@@ -0,0 +1,208 @@
+package performance
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrNoCapital      = errors.New("no capital invested during the period")
+	ErrPeriodTooShort = errors.New("period is shorter than one day")
+	ErrNoSolution     = errors.New("internal rate of return not found")
+)
+
+// Day is the state of portfolio at the end of a day
+type Day struct {
+	Date time.Time
+	// Values are values of positions by ID at the end of the day, flows of the day included
+	Values map[string]float64
+	// Flows are money put into positions by ID during the day, negative when money is taken out
+	Flows map[string]float64
+}
+
+type Contribution struct {
+	ID string
+	// Gain is the change of position value not caused by flows
+	Gain float64
+	// Contribution is the part of portfolio Return made by the position
+	Contribution float64
+}
+
+type Report struct {
+	Start      time.Time
+	End        time.Time
+	StartValue float64
+	EndValue   float64
+	// NetFlow is money put into portfolio during the period, negative when more was taken out
+	NetFlow float64
+	// Gain is the change of portfolio value not caused by flows
+	Gain float64
+	// Return is Gain divided by average invested capital (Modified Dietz), contributions of positions add up to it
+	Return float64
+	// TWR is time-weighted return, it doesn't depend on size and timing of flows
+	TWR float64
+	// MWR is money-weighted return of the period, AnnualMWR is the same internal rate of return per year
+	MWR       float64
+	AnnualMWR float64
+	// Contributions are sorted by Contribution, biggest first
+	Contributions []Contribution
+}
+
+const daysInYear = 365
+
+// Analyze measures returns of the period from the first to the last day.
+// The first day is the starting point, its flows happened before the period and are ignored.
+func Analyze(days []Day) (Report, error) {
+	if len(days) < 2 {
+		return Report{}, ErrPeriodTooShort
+	}
+	first, last := days[0], days[len(days)-1]
+	length := daysBetween(first.Date, last.Date)
+	if length <= 0 {
+		return Report{}, ErrPeriodTooShort
+	}
+	r := Report{
+		Start:      first.Date,
+		End:        last.Date,
+		StartValue: sum(first.Values),
+		EndValue:   sum(last.Values),
+	}
+
+	var (
+		twr     = 1.0
+		prev    = r.StartValue
+		capital = r.StartValue
+		flows   = []Flow{{Time: first.Date, Amount: -r.StartValue}}
+		gains   = make(map[string]float64)
+	)
+	for id, v := range first.Values {
+		gains[id] -= v
+	}
+	for _, d := range days[1:] {
+		value, flow := sum(d.Values), sum(d.Flows)
+		if prev > 0 { // nothing was invested before, there is no return to chain
+			twr *= (value - flow) / prev
+		}
+		prev = value
+
+		r.NetFlow += flow
+		capital += flow * float64(length-daysBetween(first.Date, d.Date)) / float64(length)
+		if flow != 0 {
+			flows = append(flows, Flow{Time: d.Date, Amount: -flow})
+		}
+		for id, f := range d.Flows {
+			gains[id] -= f
+		}
+	}
+	for id, v := range last.Values {
+		gains[id] += v
+	}
+	flows = append(flows, Flow{Time: last.Date, Amount: r.EndValue})
+
+	if capital <= 0 {
+		return Report{}, ErrNoCapital
+	}
+	r.Gain = r.EndValue - r.StartValue - r.NetFlow
+	r.Return = r.Gain / capital
+	r.TWR = twr - 1
+	for id, g := range gains {
+		r.Contributions = append(r.Contributions, Contribution{ID: id, Gain: g, Contribution: g / capital})
+	}
+	sort.Slice(r.Contributions, func(i, j int) bool {
+		if r.Contributions[i].Contribution != r.Contributions[j].Contribution {
+			return r.Contributions[i].Contribution > r.Contributions[j].Contribution
+		}
+		return r.Contributions[i].ID < r.Contributions[j].ID
+	})
+
+	growth, err := logIRR(flows)
+	if err != nil {
+		return Report{}, err
+	}
+	r.AnnualMWR = math.Expm1(growth)
+	r.MWR = math.Expm1(growth * float64(length) / daysInYear)
+	return r, nil
+}
+
+// Flow is money movement of an investor: negative when money is invested, positive when it is received back
+type Flow struct {
+	Time   time.Time
+	Amount float64
+}
+
+// XIRR returns annual internal rate of return of flows, like spreadsheet function of the same name does.
+// Years are counted as 365 days from the first flow.
+func XIRR(flows []Flow) (float64, error) {
+	growth, err := logIRR(flows)
+	if err != nil {
+		return 0, err
+	}
+	return math.Expm1(growth), nil
+}
+
+// logIRR returns continuously compounded annual rate ln(1+IRR). Short periods with big losses have IRR
+// so close to -100% that it can't be found precisely, while its logarithm is an ordinary number.
+func logIRR(flows []Flow) (float64, error) {
+	var hasIn, hasOut bool
+	for _, f := range flows {
+		hasIn = hasIn || f.Amount < 0
+		hasOut = hasOut || f.Amount > 0
+	}
+	if !hasIn || !hasOut {
+		return 0, ErrNoSolution
+	}
+	start := flows[0].Time
+	for _, f := range flows {
+		if f.Time.Before(start) {
+			start = f.Time
+		}
+	}
+	years := make([]float64, len(flows))
+	maxYears := 1.0
+	for i, f := range flows {
+		years[i] = float64(daysBetween(start, f.Time)) / daysInYear
+		maxYears = math.Max(maxYears, years[i])
+	}
+	npv := func(growth float64) float64 {
+		var v float64
+		for i, f := range flows {
+			v += f.Amount * math.Exp(-growth*years[i])
+		}
+		return v
+	}
+
+	// bisection is slower than Newton's method, but it can't diverge.
+	// Bounds keep exponents within float64 range.
+	limit := 700 / maxYears
+	lo, hi := -limit, limit
+	if npv(lo)*npv(hi) > 0 {
+		return 0, ErrNoSolution
+	}
+	for i := 0; i < 200 && hi-lo > 1e-12; i++ {
+		mid := (lo + hi) / 2
+		if npv(lo)*npv(mid) <= 0 {
+			hi = mid
+		} else {
+			lo = mid
+		}
+	}
+	return (lo + hi) / 2, nil
+}
+
+// daysBetween counts calendar days, time of day is ignored
+func daysBetween(from, to time.Time) int {
+	from = time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, time.UTC)
+	to = time.Date(to.Year(), to.Month(), to.Day(), 0, 0, 0, 0, time.UTC)
+	return int(math.Round(to.Sub(from).Hours() / 24))
+}
+
+func sum(values map[string]float64) float64 {
+	var s float64
+	for _, v := range values {
+		s += v
+	}
+	return s
+}
@@ -0,0 +1,134 @@
+package performance
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+const eps = 1e-6
+
+func date(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+func TestXIRR(t *testing.T) {
+	// example from spreadsheet documentation
+	flows := []Flow{
+		{date(2008, 1, 1), -10000},
+		{date(2008, 3, 1), 2750},
+		{date(2008, 10, 30), 4250},
+		{date(2009, 2, 15), 3250},
+		{date(2009, 4, 1), 2750},
+	}
+	got, err := XIRR(flows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(got-0.373362535) > eps {
+		t.Errorf("expected 0.373362535, got %f", got)
+	}
+
+	if _, err := XIRR([]Flow{{date(2021, 1, 1), -100}, {date(2021, 2, 1), -100}}); err != ErrNoSolution {
+		t.Errorf("expected ErrNoSolution for flows without income, got %v", err)
+	}
+}
+
+func TestAnalyze(t *testing.T) {
+	tests := []struct {
+		name          string
+		days          []Day
+		gain          float64
+		ret           float64
+		twr           float64
+		mwr           float64
+		contributions map[string]float64
+	}{
+		{
+			name: "no flows",
+			days: []Day{
+				{Date: date(2021, 1, 1), Values: map[string]float64{"A": 60, "B": 40}},
+				{Date: date(2021, 1, 15), Values: map[string]float64{"A": 70, "B": 35}},
+				{Date: date(2021, 2, 1), Values: map[string]float64{"A": 72, "B": 38}},
+			},
+			gain:          10,
+			ret:           0.1,
+			twr:           0.1,
+			mwr:           0.1,
+			contributions: map[string]float64{"A": 0.12, "B": -0.02},
+		},
+		{
+			// the price doubles, then big money comes in right before it halves:
+			// time-weighted return is zero, but investor lost money
+			name: "bad timing",
+			days: []Day{
+				{Date: date(2021, 1, 1), Values: map[string]float64{"A": 100}},
+				{Date: date(2021, 1, 11), Values: map[string]float64{"A": 1200}, Flows: map[string]float64{"A": 1000}},
+				{Date: date(2021, 1, 21), Values: map[string]float64{"A": 600}},
+			},
+			gain:          -500,
+			ret:           -500.0 / 600,
+			twr:           0,
+			mwr:           1/math.Pow((1000+math.Sqrt(1240000))/1200, 2) - 1,
+			contributions: map[string]float64{"A": -500.0 / 600},
+		},
+		{
+			name: "position bought during the period",
+			days: []Day{
+				{Date: date(2021, 1, 1), Values: map[string]float64{"A": 100}},
+				{Date: date(2021, 1, 11), Values: map[string]float64{"A": 100, "B": 100}, Flows: map[string]float64{"B": 100}},
+				{Date: date(2021, 1, 21), Values: map[string]float64{"A": 110, "B": 120}},
+			},
+			gain:          30,
+			ret:           30.0 / 150,
+			twr:           1.15 - 1,
+			mwr:           1/math.Pow((100+math.Sqrt(102000))/460, 2) - 1,
+			contributions: map[string]float64{"A": 10.0 / 150, "B": 20.0 / 150},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := Analyze(tt.days)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if math.Abs(r.Gain-tt.gain) > eps {
+				t.Errorf("expected gain %f, got %f", tt.gain, r.Gain)
+			}
+			if math.Abs(r.Return-tt.ret) > eps {
+				t.Errorf("expected return %f, got %f", tt.ret, r.Return)
+			}
+			if math.Abs(r.TWR-tt.twr) > eps {
+				t.Errorf("expected TWR %f, got %f", tt.twr, r.TWR)
+			}
+			if math.Abs(r.MWR-tt.mwr) > eps {
+				t.Errorf("expected MWR %f, got %f", tt.mwr, r.MWR)
+			}
+			var total float64
+			for _, c := range r.Contributions {
+				if want := tt.contributions[c.ID]; math.Abs(c.Contribution-want) > eps {
+					t.Errorf("expected contribution of %s %f, got %f", c.ID, want, c.Contribution)
+				}
+				total += c.Contribution
+			}
+			if math.Abs(total-r.Return) > eps {
+				t.Errorf("contributions add up to %f, not to return %f", total, r.Return)
+			}
+			for i := 1; i < len(r.Contributions); i++ {
+				if r.Contributions[i-1].Contribution < r.Contributions[i].Contribution {
+					t.Errorf("contributions are not sorted: %+v", r.Contributions)
+				}
+			}
+		})
+	}
+}
+
+func TestAnalyze_errors(t *testing.T) {
+	if _, err := Analyze([]Day{{Date: date(2021, 1, 1)}}); err != ErrPeriodTooShort {
+		t.Errorf("expected ErrPeriodTooShort, got %v", err)
+	}
+	empty := []Day{{Date: date(2021, 1, 1)}, {Date: date(2021, 2, 1)}}
+	if _, err := Analyze(empty); err != ErrNoCapital {
+		t.Errorf("expected ErrNoCapital, got %v", err)
+	}
+}
@@ -0,0 +1,117 @@
+package rebalance
+
+import (
+	"math"
+)
+
+type Opts struct {
+	// Tolerance in percentage points. Positions which weight differs from target by no more than Tolerance are not touched
+	Tolerance float64
+	// NoSells forbids selling, so only Cash is spent on underweight positions
+	NoSells bool
+	// Cash is money that can be spent in addition to money from sold securities
+	Cash float64
+}
+
+type Trade struct {
+	ID string
+	// Lots to buy, negative for sells
+	Lots int
+	// Before and After are weights of the position in percents of held securities value
+	Before float64
+	After  float64
+}
+
+type Rebalancing struct {
+	// Trades for every position sorted by ID, untouched positions have zero Lots
+	Trades []Trade
+	// Left is money left after all trades
+	Left float64
+}
+
+// Rebalance returns buys and sells in whole lots that bring held securities back to target weights.
+//
+// Overweight positions are sold down to the nearest lot to their target, then cash and sold money are
+// distributed between underweight positions the same way Allocate does it.
+// Only whole lots are sold, so odd securities stay in portfolio.
+func Rebalance(positions []Position, opts Opts) Rebalancing {
+	positions = validPositions(positions)
+
+	var held float64
+	for _, p := range positions {
+		held += p.heldValue()
+	}
+	total := held + opts.Cash
+
+	var (
+		before = make(map[string]float64, len(positions))
+		frozen = make(map[string]bool)
+		sold   = make(map[string]int)
+		cash   = opts.Cash
+	)
+	for _, p := range positions {
+		if held > 0 {
+			before[p.ID] = p.heldValue() / held * 100
+		}
+		if math.Abs(before[p.ID]-p.Weight) <= opts.Tolerance+eps {
+			frozen[p.ID] = true
+		}
+	}
+
+	afterSells := make([]Position, len(positions))
+	copy(afterSells, positions)
+	for i, p := range afterSells {
+		if opts.NoSells || frozen[p.ID] {
+			continue
+		}
+		excess := p.heldValue() - total*p.Weight/100
+		if excess <= 0 {
+			continue
+		}
+		lots := math.Floor(excess/p.lotPrice() + 0.5)
+		if p.Weight <= 0 { // position is not a part of target portfolio anymore
+			lots = math.Inf(1)
+		}
+		lots = math.Min(lots, math.Floor(p.Held/p.LotSize+eps))
+		if lots <= 0 {
+			continue
+		}
+		sold[p.ID] = int(lots)
+		afterSells[i].Held -= lots * p.LotSize
+		cash += lots * p.lotPrice()
+	}
+
+	a := newAllocator(afterSells, cash)
+	a.frozen = frozen
+	lots := a.floor()
+	a.topUp(lots)
+	lots = a.improve(lots)
+
+	bought := make(map[string]int, len(lots))
+	for i, p := range a.positions {
+		bought[p.ID] = lots[i]
+	}
+
+	var (
+		after     = make(map[string]float64, len(positions))
+		heldAfter float64
+		res       Rebalancing
+	)
+	for _, p := range positions {
+		net := bought[p.ID] - sold[p.ID]
+		after[p.ID] = p.heldValue() + float64(net)*p.lotPrice()
+		heldAfter += after[p.ID]
+		res.Trades = append(res.Trades, Trade{
+			ID:     p.ID,
+			Lots:   net,
+			Before: before[p.ID],
+		})
+	}
+	for i := range res.Trades {
+		if heldAfter > 0 {
+			res.Trades[i].After = after[res.Trades[i].ID] / heldAfter * 100
+		}
+	}
+	res.Left = cash - a.spent(lots)
+	return res
+}
@@ -0,0 +1,96 @@
+package rebalance
+
+import (
+	"math"
+	"testing"
+)
+
+func TestRebalance(t *testing.T) {
+	tests := []struct {
+		name          string
+		positions     []Position
+		opts          Opts
+		expectedLots  map[string]int
+		expectedAfter map[string]float64
+	}{
+		{
+			name: "overweight is sold to buy underweight",
+			positions: []Position{
+				{ID: "A", Weight: 50, Price: 100, LotSize: 1, Held: 15},
+				{ID: "B", Weight: 50, Price: 100, LotSize: 1, Held: 5},
+			},
+			expectedLots:  map[string]int{"A": -5, "B": 5},
+			expectedAfter: map[string]float64{"A": 50, "B": 50},
+		},
+		{
+			name: "drift within tolerance is ignored",
+			positions: []Position{
+				{ID: "A", Weight: 50, Price: 100, LotSize: 1, Held: 11},
+				{ID: "B", Weight: 50, Price: 100, LotSize: 1, Held: 9},
+			},
+			opts:          Opts{Tolerance: 5},
+			expectedLots:  map[string]int{"A": 0, "B": 0},
+			expectedAfter: map[string]float64{"A": 55, "B": 45},
+		},
+		{
+			name: "only drifted positions are touched",
+			positions: []Position{
+				{ID: "A", Weight: 40, Price: 100, LotSize: 1, Held: 50},
+				{ID: "B", Weight: 30, Price: 100, LotSize: 1, Held: 31},
+				{ID: "C", Weight: 30, Price: 100, LotSize: 1, Held: 19},
+			},
+			opts:          Opts{Tolerance: 2},
+			expectedLots:  map[string]int{"A": -10, "B": 0, "C": 10},
+			expectedAfter: map[string]float64{"A": 40, "B": 31, "C": 29},
+		},
+		{
+			name: "no sells spends only cash",
+			positions: []Position{
+				{ID: "A", Weight: 50, Price: 100, LotSize: 1, Held: 15},
+				{ID: "B", Weight: 50, Price: 100, LotSize: 1, Held: 5},
+			},
+			opts:          Opts{NoSells: true, Cash: 1000},
+			expectedLots:  map[string]int{"A": 0, "B": 10},
+			expectedAfter: map[string]float64{"A": 50, "B": 50},
+		},
+		{
+			name: "position without target is sold",
+			positions: []Position{
+				{ID: "A", Weight: 100, Price: 100, LotSize: 1, Held: 5},
+				{ID: "C", Weight: 0, Price: 100, LotSize: 1, Held: 3},
+			},
+			expectedLots:  map[string]int{"A": 3, "C": -3},
+			expectedAfter: map[string]float64{"A": 100, "C": 0},
+		},
+		{
+			name: "only whole lots are sold",
+			positions: []Position{
+				{ID: "A", Weight: 50, Price: 10, LotSize: 10, Held: 25},
+				{ID: "B", Weight: 50, Price: 10, LotSize: 1},
+			},
+			expectedLots:  map[string]int{"A": -1, "B": 10},
+			expectedAfter: map[string]float64{"A": 60, "B": 40},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := Rebalance(tt.positions, tt.opts)
+
+			if len(res.Trades) != len(tt.expectedLots) {
+				t.Fatalf("expected %d trades, got %d", len(tt.expectedLots), len(res.Trades))
+			}
+			for _, trade := range res.Trades {
+				if trade.Lots != tt.expectedLots[trade.ID] {
+					t.Errorf("expected %d lots of %s, got %d", tt.expectedLots[trade.ID], trade.ID, trade.Lots)
+				}
+				if math.Abs(trade.After-tt.expectedAfter[trade.ID]) > 1e-9 {
+					t.Errorf("expected %s weight after rebalance %f, got %f", trade.ID, tt.expectedAfter[trade.ID], trade.After)
+				}
+			}
+			if res.Left < -1e-9 {
+				t.Errorf("spent more than had, left %f", res.Left)
+			}
+		})
+	}
+}
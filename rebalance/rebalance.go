@@ -0,0 +1,247 @@
+package rebalance
+
+import (
+	"math"
+	"sort"
+)
+
+// Position is a portfolio position capital is distributed between
+type Position struct {
+	ID string
+	// Weight is a target share of the position in percents, positions with zero weight are never bought
+	Weight float64
+	// Price of one security, all positions must use the same currency
+	Price   float64
+	LotSize float64
+	// Held is the number of securities that are already in portfolio
+	Held float64
+}
+
+func (p Position) heldValue() float64 {
+	return p.Held * p.Price
+}
+
+func (p Position) lotPrice() float64 {
+	return p.Price * p.LotSize
+}
+
+type Allocation struct {
+	// Lots to buy by position ID
+	Lots  map[string]int
+	Spent float64
+	Left  float64
+}
+
+// Allocate distributes capital between positions in whole lots without overspending.
+//
+// Result minimizes squared deviation of money in each position after the purchase from its target share of
+// the portfolio, which is held securities plus capital. Uninvested money counts as deviation too, so the leftover
+// is spread between positions while it gets closer to target. Each underweight position first gets as many lots
+// as fit into the gap to its share, the leftover is topped up lot by lot and then lots are exchanged between positions
+// while it improves the result: sometimes a few cheap lots have to be given up to afford one expensive lot.
+func Allocate(positions []Position, capital float64) Allocation {
+	a := newAllocator(positions, capital)
+
+	lots := a.floor()
+	a.topUp(lots)
+	lots = a.improve(lots)
+
+	res := Allocation{
+		Lots: make(map[string]int, len(lots)),
+	}
+	for i, p := range a.positions {
+		res.Lots[p.ID] = lots[i]
+	}
+	res.Spent = a.spent(lots)
+	res.Left = capital - res.Spent
+	return res
+}
+
+// eps protects money comparisons from floating point errors
+const eps = 1e-9
+
+type allocator struct {
+	positions  []Position
+	capital    float64
+	targets    []float64
+	cashTarget float64
+	// frozen positions are never bought
+	frozen map[string]bool
+}
+
+func newAllocator(positions []Position, capital float64) *allocator {
+	a := &allocator{
+		positions: validPositions(positions),
+		capital:   capital,
+	}
+	a.targets, a.cashTarget = targetValues(a.positions, capital)
+	return a
+}
+
+func (a *allocator) floor() []int {
+	var (
+		gaps     = make([]float64, len(a.positions))
+		totalGap float64
+	)
+	for i, p := range a.positions {
+		gap := a.targets[i] - p.heldValue()
+		if gap <= 0 || !a.canBuy(i) { // position is already overweight
+			continue
+		}
+		gaps[i] = gap
+		totalGap += gap
+	}
+	// held overweight positions can't be sold, so there may be not enough money to fill all gaps
+	scale := 1.0
+	if totalGap > a.capital {
+		scale = a.capital / totalGap
+	}
+
+	lots := make([]int, len(a.positions))
+	for i, p := range a.positions {
+		lots[i] = int(math.Floor(gaps[i]*scale/p.lotPrice() + eps))
+	}
+	return lots
+}
+
+// topUp buys one lot at a time while there is a lot that decreases the cost
+func (a *allocator) topUp(lots []int) {
+	spent := a.spent(lots)
+	for {
+		cash := a.capital - spent
+		best, bestDelta := -1, 0.0
+		for i, p := range a.positions {
+			price := p.lotPrice()
+			if price > cash+eps || !a.canBuy(i) {
+				continue
+			}
+			value := p.heldValue() + float64(lots[i])*price
+			// change of (v-t)^2 for the position and for uninvested cash after buying one more lot
+			delta := price*(2*(value-a.targets[i])+price) + price*(price-2*(cash-a.cashTarget))
+			if delta < bestDelta {
+				best, bestDelta = i, delta
+			}
+		}
+		if best < 0 {
+			return
+		}
+		lots[best]++
+		spent += a.positions[best].lotPrice()
+	}
+}
+
+// improve sells lots of one position to buy a lot of another one while it decreases the cost
+func (a *allocator) improve(lots []int) []int {
+	cost := a.cost(lots)
+	for {
+		var best []int
+		for to := range a.positions {
+			if !a.canBuy(to) {
+				continue
+			}
+			for from := range a.positions {
+				if from == to {
+					continue
+				}
+				candidate := a.exchange(lots, from, to)
+				if candidate == nil {
+					continue
+				}
+				if c := a.cost(candidate); c < cost-eps {
+					best, cost = candidate, c
+				}
+			}
+		}
+		if best == nil {
+			return lots
+		}
+		lots = best
+	}
+}
+
+// exchange returns copy of lots with one more lot of position to, paid by selling as few lots of position from as possible.
+// Nil is returned if there is not enough lots to sell.
+func (a *allocator) exchange(lots []int, from, to int) []int {
+	price := a.positions[to].lotPrice()
+	need := price - (a.capital - a.spent(lots))
+	sell := 1
+	if need > 0 {
+		sell = int(math.Ceil(need/a.positions[from].lotPrice() - eps))
+	}
+	if sell < 1 {
+		sell = 1
+	}
+	if lots[from] < sell {
+		return nil
+	}
+
+	candidate := make([]int, len(lots))
+	copy(candidate, lots)
+	candidate[from] -= sell
+	candidate[to]++
+	a.topUp(candidate)
+	return candidate
+}
+
+func (a *allocator) canBuy(i int) bool {
+	p := a.positions[i]
+	return p.Weight > 0 && !a.frozen[p.ID]
+}
+
+func (a *allocator) spent(lots []int) float64 {
+	var spent float64
+	for i, p := range a.positions {
+		spent += float64(lots[i]) * p.lotPrice()
+	}
+	return spent
+}
+
+// cost is the squared deviation of money in positions and uninvested money from their targets
+func (a *allocator) cost(lots []int) float64 {
+	var (
+		cost  float64
+		spent float64
+	)
+	for i, p := range a.positions {
+		value := float64(lots[i]) * p.lotPrice()
+		spent += value
+		value += p.heldValue()
+		cost += (value - a.targets[i]) * (value - a.targets[i])
+	}
+	cash := a.capital - spent
+	return cost + (cash-a.cashTarget)*(cash-a.cashTarget)
+}
+
+// targetValues returns money that should be invested in each position and money that should stay uninvested,
+// the latter is non zero only if weights sum is less than 100
+func targetValues(positions []Position, capital float64) ([]float64, float64) {
+	total := capital
+	for _, p := range positions {
+		total += p.heldValue()
+	}
+	targets := make([]float64, len(positions))
+	cashTarget := total
+	for i, p := range positions {
+		targets[i] = total * p.Weight / 100
+		cashTarget -= targets[i]
+	}
+	if cashTarget < 0 {
+		cashTarget = 0
+	}
+	return targets, cashTarget
+}
+
+// validPositions drops positions that can't be bought or held and sorts the rest, so that result does not depend on input order
+func validPositions(positions []Position) []Position {
+	res := make([]Position, 0, len(positions))
+	for _, p := range positions {
+		if p.Weight <= 0 && p.Held <= 0 || p.lotPrice() <= 0 {
+			continue
+		}
+		res = append(res, p)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].ID < res[j].ID
+	})
+	return res
+}
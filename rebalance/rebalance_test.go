@@ -0,0 +1,147 @@
+package rebalance
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestAllocate(t *testing.T) {
+	tests := []struct {
+		name         string
+		positions    []Position
+		capital      float64
+		expectedLots map[string]int
+	}{
+		{
+			name: "exact fit",
+			positions: []Position{
+				{ID: "A", Weight: 50, Price: 10, LotSize: 1},
+				{ID: "B", Weight: 50, Price: 25, LotSize: 2},
+			},
+			capital:      1000,
+			expectedLots: map[string]int{"A": 50, "B": 10},
+		},
+		{
+			name: "remainder buys one more lot",
+			positions: []Position{
+				{ID: "A", Weight: 45, Price: 100, LotSize: 1},
+				{ID: "B", Weight: 45, Price: 100, LotSize: 1},
+				{ID: "C", Weight: 10, Price: 100, LotSize: 1},
+			},
+			capital:      1000,
+			expectedLots: map[string]int{"A": 5, "B": 4, "C": 1},
+		},
+		{
+			name: "cheap lots are given up for an expensive one",
+			positions: []Position{
+				{ID: "A", Weight: 50, Price: 300, LotSize: 1},
+				{ID: "B", Weight: 50, Price: 100, LotSize: 1},
+			},
+			capital:      1000,
+			expectedLots: map[string]int{"A": 2, "B": 4},
+		},
+		{
+			name: "expensive lot is bought when it is closer to target",
+			positions: []Position{
+				{ID: "A", Weight: 60, Price: 350, LotSize: 1},
+				{ID: "B", Weight: 40, Price: 10, LotSize: 1},
+			},
+			capital:      1000,
+			expectedLots: map[string]int{"A": 2, "B": 30},
+		},
+		{
+			name: "floor leaves money for one more lot",
+			positions: []Position{
+				{ID: "A", Weight: 34, Price: 100, LotSize: 1},
+				{ID: "B", Weight: 33, Price: 100, LotSize: 1},
+				{ID: "C", Weight: 33, Price: 100, LotSize: 1},
+			},
+			capital:      1000,
+			expectedLots: map[string]int{"A": 4, "B": 3, "C": 3},
+		},
+		{
+			name: "lot is too expensive",
+			positions: []Position{
+				{ID: "A", Weight: 50, Price: 5000, LotSize: 1},
+				{ID: "B", Weight: 50, Price: 1, LotSize: 10},
+			},
+			capital:      1000,
+			expectedLots: map[string]int{"A": 0, "B": 75}, // half of unaffordable share is left uninvested
+		},
+		{
+			name: "money is kept when weights sum is less than 100",
+			positions: []Position{
+				{ID: "A", Weight: 50, Price: 100, LotSize: 1},
+			},
+			capital:      1000,
+			expectedLots: map[string]int{"A": 5},
+		},
+		{
+			name: "positions without price or weight are skipped",
+			positions: []Position{
+				{ID: "A", Weight: 100, Price: 100, LotSize: 1},
+				{ID: "B", Weight: 0, Price: 100, LotSize: 1},
+				{ID: "C", Weight: 10, Price: 0, LotSize: 1},
+			},
+			capital:      1000,
+			expectedLots: map[string]int{"A": 10},
+		},
+		{
+			name: "underweight position is bought first",
+			positions: []Position{
+				{ID: "A", Weight: 50, Price: 100, LotSize: 1, Held: 10},
+				{ID: "B", Weight: 50, Price: 100, LotSize: 1, Held: 2},
+			},
+			capital:      1000,
+			expectedLots: map[string]int{"A": 1, "B": 9},
+		},
+		{
+			name: "overweight position is not bought",
+			positions: []Position{
+				{ID: "A", Weight: 50, Price: 100, LotSize: 1, Held: 30},
+				{ID: "B", Weight: 50, Price: 100, LotSize: 1},
+			},
+			capital:      1000,
+			expectedLots: map[string]int{"A": 0, "B": 10},
+		},
+		{
+			name: "held position without target is never bought",
+			positions: []Position{
+				{ID: "A", Weight: 100, Price: 100, LotSize: 1},
+				{ID: "B", Weight: 0, Price: 10, LotSize: 1, Held: 10},
+			},
+			capital:      1050,
+			expectedLots: map[string]int{"A": 10, "B": 0},
+		},
+		{
+			name:         "empty portfolio",
+			capital:      1000,
+			expectedLots: map[string]int{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := Allocate(tt.positions, tt.capital)
+
+			if !reflect.DeepEqual(res.Lots, tt.expectedLots) {
+				t.Errorf("expected lots %v, got %v", tt.expectedLots, res.Lots)
+			}
+
+			var spent float64
+			for _, p := range tt.positions {
+				spent += float64(res.Lots[p.ID]) * p.Price * p.LotSize
+			}
+			if spent > tt.capital {
+				t.Errorf("spent %f is more than capital %f", spent, tt.capital)
+			}
+			if math.Abs(spent-res.Spent) > 1e-9 {
+				t.Errorf("expected spent %f, got %f", spent, res.Spent)
+			}
+			if math.Abs(res.Spent+res.Left-tt.capital) > 1e-9 {
+				t.Errorf("spent %f and left %f don't sum up to capital %f", res.Spent, res.Left, tt.capital)
+			}
+		})
+	}
+}
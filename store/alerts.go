@@ -0,0 +1,258 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/pkg/errors"
+)
+
+var ErrAlertNotFound = errors.New("alert not found")
+
+// PriceAlert asks to notify user when price of security crosses Price.
+// Price is in units of the quote, bonds are quoted in percents of face value.
+type PriceAlert struct {
+	Seq   int    `json:"seq"`
+	SecID string `json:"secid"`
+	// Above is true when user waits for price to rise to Price, false when to fall to it
+	Above     bool      `json:"above"`
+	Price     float64   `json:"price"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Triggered reports whether price reached the alert
+func (a PriceAlert) Triggered(price float64) bool {
+	if a.Above {
+		return price >= a.Price
+	}
+	return price <= a.Price
+}
+
+// DriftRule asks to notify user when share of any position differs from target by more than Threshold percentage points
+type DriftRule struct {
+	Threshold float64 `json:"threshold"`
+	// NotifiedAt is the time of the last notification, zero if there were none
+	NotifiedAt time.Time `json:"notified_at"`
+}
+
+// NotificationRules are rules of one user
+type NotificationRules struct {
+	UserID int
+	Alerts []PriceAlert
+	// Drift is keyed by portfolio
+	Drift map[string]DriftRule
+}
+
+// AddAlert saves alert with the next sequence number, which is returned
+func (s *Store) AddAlert(userID int, a PriceAlert) (int, error) {
+	err := s.db.Update(func(txn *badger.Txn) error {
+		seq, err := lastSeq(txn, getAlertsPrefix(userID))
+		if err != nil {
+			return err
+		}
+		a.Seq = seq + 1
+		v, err := json.Marshal(a)
+		if err != nil {
+			return err
+		}
+		if err := txn.Set([]byte(getAlertIndexKey(userID, a.Seq)), []byte{}); err != nil {
+			return err
+		}
+		return txn.Set([]byte(getAlertKey(userID, a.Seq)), v)
+	})
+	return a.Seq, err
+}
+
+// Alerts returns alerts of user in order they were added
+func (s *Store) Alerts(userID int) ([]PriceAlert, error) {
+	var alerts []PriceAlert
+	err := s.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		prefix := []byte(getAlertsPrefix(userID))
+
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			var a PriceAlert
+			if err := it.Item().Value(func(v []byte) error { return json.Unmarshal(v, &a) }); err != nil {
+				return err
+			}
+			alerts = append(alerts, a)
+		}
+		return nil
+	})
+	return alerts, err
+}
+
+// DeleteAlerts removes alerts by sequence numbers, ErrAlertNotFound is returned if any of them is missing
+func (s *Store) DeleteAlerts(userID int, seqs ...int) error {
+	return s.db.Update(func(txn *badger.Txn) error {
+		for _, seq := range seqs {
+			key := []byte(getAlertKey(userID, seq))
+			if _, err := txn.Get(key); err != nil {
+				if err == badger.ErrKeyNotFound {
+					return ErrAlertNotFound
+				}
+				return err
+			}
+			if err := txn.Delete(key); err != nil {
+				return err
+			}
+			if err := txn.Delete([]byte(getAlertIndexKey(userID, seq))); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// SetDrift sets drift threshold of the portfolio, zero threshold removes the rule
+func (s *Store) SetDrift(userID int, portfolio string, threshold float64) error {
+	return s.db.Update(func(txn *badger.Txn) error {
+		key := []byte(getDriftKey(userID, portfolio))
+		if threshold == 0 {
+			return deleteDrift(txn, userID, portfolio)
+		}
+		if err := registerPortfolio(txn, userID, portfolio); err != nil {
+			return err
+		}
+		v, err := json.Marshal(DriftRule{Threshold: threshold})
+		if err != nil {
+			return err
+		}
+		if err := txn.Set([]byte(getDriftIndexKey(userID, portfolio)), []byte{}); err != nil {
+			return err
+		}
+		return txn.Set(key, v)
+	})
+}
+
+// Drift returns drift rule of the portfolio, false is returned if there is none
+func (s *Store) Drift(userID int, portfolio string) (rule DriftRule, ok bool, err error) {
+	err = s.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte(getDriftKey(userID, portfolio)))
+		if err == badger.ErrKeyNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		ok = true
+		return item.Value(func(v []byte) error { return json.Unmarshal(v, &rule) })
+	})
+	return rule, ok, err
+}
+
+// MarkDriftNotified remembers when user was notified about drift of the portfolio.
+// Nothing is done if the rule was removed meanwhile.
+func (s *Store) MarkDriftNotified(userID int, portfolio string, at time.Time) error {
+	return s.db.Update(func(txn *badger.Txn) error {
+		key := []byte(getDriftKey(userID, portfolio))
+		item, err := txn.Get(key)
+		if err == badger.ErrKeyNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var rule DriftRule
+		if err := item.Value(func(v []byte) error { return json.Unmarshal(v, &rule) }); err != nil {
+			return err
+		}
+		rule.NotifiedAt = at
+		v, err := json.Marshal(rule)
+		if err != nil {
+			return err
+		}
+		return txn.Set(key, v)
+	})
+}
+
+// AllNotificationRules returns rules of all users who have any, sorted by user.
+// Only the index of rules is iterated, so that it is cheap to call after every price update.
+func (s *Store) AllNotificationRules() ([]NotificationRules, error) {
+	var res []NotificationRules
+	err := s.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		prefix := []byte(rulesIndexPrefix)
+
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			// rules/<userID>/alerts/<seq> or rules/<userID>/drift/<portfolio>
+			parts := strings.Split(string(it.Item().Key()), keySep)
+			if len(parts) != 4 || (parts[2] != "alerts" && parts[2] != "drift") {
+				return errors.Errorf("unexpected key %s", it.Item().Key())
+			}
+			userID, err := strconv.Atoi(parts[1])
+			if err != nil {
+				return errors.Wrapf(err, "unexpected key %s", it.Item().Key())
+			}
+			// index is sorted by user, so rules of one user are consecutive
+			if len(res) == 0 || res[len(res)-1].UserID != userID {
+				res = append(res, NotificationRules{UserID: userID, Drift: make(map[string]DriftRule)})
+			}
+			rules := &res[len(res)-1]
+
+			if parts[2] == "alerts" {
+				seq, err := strconv.Atoi(parts[3])
+				if err != nil {
+					return errors.Wrapf(err, "unexpected key %s", it.Item().Key())
+				}
+				var a PriceAlert
+				if _, err := getJSON(txn, getAlertKey(userID, seq), &a); err != nil {
+					return err
+				}
+				rules.Alerts = append(rules.Alerts, a)
+				continue
+			}
+			var rule DriftRule
+			if _, err := getJSON(txn, getDriftKey(userID, parts[3]), &rule); err != nil {
+				return err
+			}
+			rules.Drift[parts[3]] = rule
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].UserID < res[j].UserID })
+	return res, nil
+}
+
+// deleteDrift removes drift rule of the portfolio together with its index
+func deleteDrift(txn *badger.Txn, userID int, portfolio string) error {
+	if err := txn.Delete([]byte(getDriftIndexKey(userID, portfolio))); err != nil {
+		return err
+	}
+	return txn.Delete([]byte(getDriftKey(userID, portfolio)))
+}
+
+func getAlertsPrefix(userID int) string {
+	return getUserScope(userID) + "alerts" + keySep
+}
+
+// getAlertKey pads seq with zeros, so that keys are iterated in order alerts were added
+func getAlertKey(userID int, seq int) string {
+	return getAlertsPrefix(userID) + fmt.Sprintf("%010d", seq)
+}
+
+func getDriftKey(userID int, portfolio string) string {
+	return getPortfolioScope(userID, portfolio) + "drift"
+}
+
+const rulesIndexPrefix = "rules" + keySep
+
+func getAlertIndexKey(userID int, seq int) string {
+	return rulesIndexPrefix + strconv.Itoa(userID) + keySep + "alerts" + keySep + fmt.Sprintf("%010d", seq)
+}
+
+func getDriftIndexKey(userID int, portfolio string) string {
+	return rulesIndexPrefix + strconv.Itoa(userID) + keySep + "drift" + keySep + portfolio
+}
@@ -0,0 +1,117 @@
+package store
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestStore_alerts(t *testing.T) {
+	s, err := New("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	const userID = 1
+	for _, a := range []PriceAlert{
+		{SecID: "TQBR:SBER", Above: true, Price: 300},
+		{SecID: "TQBR:AFKS", Price: 20},
+		{SecID: "TQBR:GAZP", Price: 200},
+	} {
+		if _, err := s.AddAlert(userID, a); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.DeleteAlerts(userID, 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeleteAlerts(userID, 2); err != ErrAlertNotFound {
+		t.Errorf("expected ErrAlertNotFound for deleted alert, got %v", err)
+	}
+	alerts, err := s.Alerts(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []PriceAlert{
+		{Seq: 1, SecID: "TQBR:SBER", Above: true, Price: 300},
+		{Seq: 3, SecID: "TQBR:GAZP", Price: 200},
+	}
+	if !reflect.DeepEqual(alerts, want) {
+		t.Errorf("expected alerts %+v, got %+v", want, alerts)
+	}
+	if other, err := s.Alerts(12); err != nil || len(other) != 0 {
+		t.Errorf("expected no alerts of another user, got %+v, %v", other, err)
+	}
+}
+
+func TestPriceAlert_Triggered(t *testing.T) {
+	above := PriceAlert{Above: true, Price: 100}
+	below := PriceAlert{Price: 100}
+	if !above.Triggered(100) || above.Triggered(99.9) {
+		t.Error("expected alert above 100 to trigger from 100")
+	}
+	if !below.Triggered(100) || below.Triggered(100.1) {
+		t.Error("expected alert below 100 to trigger from 100")
+	}
+}
+
+func TestStore_AllNotificationRules(t *testing.T) {
+	s, err := New("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if _, err := s.AddAlert(12, PriceAlert{SecID: "TQBR:SBER", Above: true, Price: 300}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetDrift(1, DefaultPortfolio, 5); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetDrift(1, "iis", 10); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetDrift(1, "iis", 0); err != nil {
+		t.Fatal(err)
+	}
+	notifiedAt := time.Date(2021, 11, 3, 12, 0, 0, 0, time.UTC)
+	if err := s.MarkDriftNotified(1, DefaultPortfolio, notifiedAt); err != nil {
+		t.Fatal(err)
+	}
+	// removed rules are removed from index as well
+	seq, err := s.AddAlert(12, PriceAlert{SecID: "TQBR:GAZP", Price: 200})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeleteAlerts(12, seq); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetDrift(7, "iis", 3); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeletePortfolio(7, "iis"); err != nil {
+		t.Fatal(err)
+	}
+	// other data of users is not a rule
+	if err := s.AddToPartfolio(1, DefaultPortfolio, map[string]float64{"TQBR:AFKS": 100}); err != nil {
+		t.Fatal(err)
+	}
+
+	rules, err := s.AllNotificationRules()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []NotificationRules{
+		{UserID: 1, Drift: map[string]DriftRule{DefaultPortfolio: {Threshold: 5, NotifiedAt: notifiedAt}}},
+		{UserID: 12, Alerts: []PriceAlert{{Seq: 1, SecID: "TQBR:SBER", Above: true, Price: 300}}, Drift: map[string]DriftRule{}},
+	}
+	if !reflect.DeepEqual(rules, want) {
+		t.Errorf("expected rules %+v, got %+v", want, rules)
+	}
+
+	rule, ok, err := s.Drift(1, DefaultPortfolio)
+	if err != nil || !ok || rule.Threshold != 5 {
+		t.Errorf("expected drift threshold 5, got %+v, %v, %v", rule, ok, err)
+	}
+}
@@ -0,0 +1,166 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/pkg/errors"
+)
+
+var ErrChangeNotFound = errors.New("change not found")
+
+// Action is a kind of change of portfolio targets
+type Action string
+
+const (
+	ActionAdd    Action = "add"
+	ActionFinish Action = "finish"
+	ActionClear  Action = "clear"
+	ActionUndo   Action = "undo"
+)
+
+// Snapshot is the state of portfolio targets at some moment
+type Snapshot struct {
+	Targets  Partfolio `json:"targets"`
+	Finished bool      `json:"finished"`
+}
+
+// Change is an entry of append-only portfolio change log
+type Change struct {
+	Seq    int       `json:"seq"`
+	Time   time.Time `json:"time"`
+	Action Action    `json:"action"`
+	// Targets are percents set by ActionAdd, zero means removed security
+	Targets Partfolio `json:"targets,omitempty"`
+	// UndoneTo is Seq of the change reverted by ActionUndo
+	UndoneTo int `json:"undone_to,omitempty"`
+	// Before is the state of portfolio right before the change, undo restores it
+	Before Snapshot `json:"before"`
+}
+
+// History returns up to limit latest changes of the portfolio, oldest first
+func (s *Store) History(userID int, portfolio string, limit int) ([]Change, error) {
+	var changes []Change
+	err := s.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Reverse = true
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		prefix := []byte(getHistoryPrefix(userID, portfolio))
+
+		// reverse iteration starts from the greatest key with the prefix
+		for it.Seek(append(prefix, 0xFF)); it.ValidForPrefix(prefix) && len(changes) < limit; it.Next() {
+			var c Change
+			err := it.Item().Value(func(v []byte) error {
+				return json.Unmarshal(v, &c)
+			})
+			if err != nil {
+				return err
+			}
+			changes = append(changes, c)
+		}
+		return nil
+	})
+	for i, j := 0, len(changes)-1; i < j; i, j = i+1, j-1 {
+		changes[i], changes[j] = changes[j], changes[i]
+	}
+	return changes, err
+}
+
+// Undo restores portfolio targets to the state before change seq.
+// Undo is logged as well, so it can be reverted too.
+func (s *Store) Undo(userID int, portfolio string, seq int) (Snapshot, error) {
+	var restored Snapshot
+	err := s.db.Update(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte(getChangeKey(userID, portfolio, seq)))
+		if err != nil {
+			if err == badger.ErrKeyNotFound {
+				return ErrChangeNotFound
+			}
+			return err
+		}
+		var c Change
+		err = item.Value(func(v []byte) error {
+			return json.Unmarshal(v, &c)
+		})
+		if err != nil {
+			return err
+		}
+		restored = c.Before
+
+		return logChange(txn, userID, portfolio, Change{Action: ActionUndo, UndoneTo: seq}, func() error {
+			if err := deletePrefix(txn, getPartfolioPrefix(userID, portfolio)); err != nil {
+				return err
+			}
+			for secid, percent := range restored.Targets {
+				if err := txn.Set([]byte(getPartfolioPrefix(userID, portfolio)+secid), float64ToBytes(percent)); err != nil {
+					return err
+				}
+			}
+			finishKey := []byte(getFinishedKey(userID, portfolio))
+			if restored.Finished {
+				return txn.Set(finishKey, []byte{})
+			}
+			return txn.Delete(finishKey)
+		})
+	})
+	return restored, err
+}
+
+// logChange applies change and appends it to the log with the state captured before apply
+func logChange(txn *badger.Txn, userID int, portfolio string, c Change, apply func() error) error {
+	targets, err := getFloats(txn, getPartfolioPrefix(userID, portfolio))
+	if err != nil {
+		return err
+	}
+	_, err = txn.Get([]byte(getFinishedKey(userID, portfolio)))
+	if err != nil && err != badger.ErrKeyNotFound {
+		return err
+	}
+	c.Before = Snapshot{Targets: targets, Finished: err == nil}
+
+	if err := apply(); err != nil {
+		return err
+	}
+
+	c.Seq, err = lastSeq(txn, getHistoryPrefix(userID, portfolio))
+	if err != nil {
+		return err
+	}
+	c.Seq++
+	c.Time = time.Now()
+	v, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	return txn.Set([]byte(getChangeKey(userID, portfolio, c.Seq)), v)
+}
+
+// lastSeq returns the greatest zero padded sequence number stored under prefix, zero if there is none
+func lastSeq(txn *badger.Txn, prefix string) (int, error) {
+	opts := badger.DefaultIteratorOptions
+	opts.Reverse = true
+	opts.PrefetchValues = false
+	it := txn.NewIterator(opts)
+	defer it.Close()
+	bprefix := []byte(prefix)
+
+	it.Seek(append(bprefix, 0xFF))
+	if !it.ValidForPrefix(bprefix) {
+		return 0, nil
+	}
+	return strconv.Atoi(strings.TrimPrefix(string(it.Item().Key()), prefix))
+}
+
+func getHistoryPrefix(userID int, portfolio string) string {
+	return getPortfolioScope(userID, portfolio) + "history" + keySep
+}
+
+// getChangeKey pads seq with zeros, so that keys are iterated in order of changes
+func getChangeKey(userID int, portfolio string, seq int) string {
+	return getHistoryPrefix(userID, portfolio) + fmt.Sprintf("%010d", seq)
+}
@@ -0,0 +1,91 @@
+package store
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestStore_undo(t *testing.T) {
+	s, err := New("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	const userID = 1
+	if err := s.AddToPartfolio(userID, DefaultPortfolio, map[string]float64{"AFKS": 60, "SBER": 40}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddToPartfolio(userID, DefaultPortfolio, map[string]float64{"SBER": 0, "GAZP": 40}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Finish(userID, DefaultPortfolio); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ClearData(userID, DefaultPortfolio); err != nil {
+		t.Fatal(err)
+	}
+
+	history, err := s.History(userID, DefaultPortfolio, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var actions []Action
+	for i, c := range history {
+		if c.Seq != i+1 {
+			t.Errorf("change %d has seq %d", i, c.Seq)
+		}
+		actions = append(actions, c.Action)
+	}
+	if want := []Action{ActionAdd, ActionAdd, ActionFinish, ActionClear}; !reflect.DeepEqual(actions, want) {
+		t.Fatalf("actions: got %v, want %v", actions, want)
+	}
+
+	checkState := func(name string, targets Partfolio, finished bool) {
+		t.Helper()
+		p, err := s.GetPartfolio(userID, DefaultPortfolio)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(p, targets) {
+			t.Errorf("%s: targets %v, want %v", name, p, targets)
+		}
+		f, err := s.IsUserFinished(userID, DefaultPortfolio)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if f != finished {
+			t.Errorf("%s: finished %v, want %v", name, f, finished)
+		}
+	}
+
+	// undo of /restart brings back finished portfolio
+	if _, err := s.Undo(userID, DefaultPortfolio, 4); err != nil {
+		t.Fatal(err)
+	}
+	checkState("undo clear", Partfolio{"AFKS": 60, "GAZP": 40}, true)
+
+	// undo of the second edit returns removed security
+	if _, err := s.Undo(userID, DefaultPortfolio, 2); err != nil {
+		t.Fatal(err)
+	}
+	checkState("undo edit", Partfolio{"AFKS": 60, "SBER": 40}, false)
+
+	// undo is logged too, reverting it restores state before it
+	if _, err := s.Undo(userID, DefaultPortfolio, 6); err != nil {
+		t.Fatal(err)
+	}
+	checkState("undo undo", Partfolio{"AFKS": 60, "GAZP": 40}, true)
+
+	if _, err := s.Undo(userID, DefaultPortfolio, 100); err != ErrChangeNotFound {
+		t.Errorf("undo of unknown change: got %v, want %v", err, ErrChangeNotFound)
+	}
+
+	history, err = s.History(userID, DefaultPortfolio, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 2 || history[0].Seq != 6 || history[1].Seq != 7 || history[1].UndoneTo != 6 {
+		t.Errorf("latest history: got %+v", history)
+	}
+}
@@ -0,0 +1,173 @@
+package store
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/pkg/errors"
+)
+
+// schemaVersionKey holds number of the last applied migration, missing key means version 0
+const schemaVersionKey = "schema_version"
+
+type migration struct {
+	version     int
+	description string
+	// apply reads db and writes changes to wb, which is flushed in as many transactions as needed
+	apply func(db *badger.DB, wb *badger.WriteBatch) error
+}
+
+// migrations must be sorted by version. Big dbs don't fit into one transaction, so changes of migration
+// are committed in batches and schema version is updated only after all of them. Migration interrupted
+// in the middle is applied again on the next start, so migrations must be idempotent.
+var migrations = []migration{
+	{version: 1, description: "move single portfolio into default named portfolio", apply: migrateSinglePortfolios},
+}
+
+// SchemaVersion is the version of key layout this package works with
+func SchemaVersion() int {
+	return migrations[len(migrations)-1].version
+}
+
+// migrate applies all migrations newer than schema version of the db
+func (s *Store) migrate() error {
+	var current int
+	err := s.db.View(func(txn *badger.Txn) (err error) {
+		current, err = schemaVersion(txn)
+		return err
+	})
+	if err != nil {
+		return errors.Wrap(err, "error while reading schema version")
+	}
+	if current > SchemaVersion() {
+		return errors.Errorf("db schema version %d is newer than supported %d", current, SchemaVersion())
+	}
+
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+		wb := s.db.NewWriteBatch()
+		if err := m.apply(s.db, wb); err != nil {
+			wb.Cancel()
+			return errors.Wrapf(err, "error while applying migration %d (%s)", m.version, m.description)
+		}
+		if err := wb.Flush(); err != nil {
+			return errors.Wrapf(err, "error while writing migration %d (%s)", m.version, m.description)
+		}
+		err := s.db.Update(func(txn *badger.Txn) error {
+			return txn.Set([]byte(schemaVersionKey), []byte(strconv.Itoa(m.version)))
+		})
+		if err != nil {
+			return errors.Wrapf(err, "error while updating schema version to %d", m.version)
+		}
+	}
+	return nil
+}
+
+func schemaVersion(txn *badger.Txn) (int, error) {
+	item, err := txn.Get([]byte(schemaVersionKey))
+	if err != nil {
+		if err == badger.ErrKeyNotFound {
+			return 0, nil
+		}
+		return 0, err
+	}
+	v, err := item.ValueCopy(nil)
+	if err != nil {
+		return 0, err
+	}
+	version, err := strconv.Atoi(string(v))
+	return version, errors.Wrapf(err, "invalid schema version %q", v)
+}
+
+// forEachItem calls fn for every item of the db snapshot
+func forEachItem(db *badger.DB, fn func(item *badger.Item) error) error {
+	return db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			if err := fn(it.Item()); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// renameKeys moves values of all keys for which rename returns true to the new key
+func renameKeys(db *badger.DB, wb *badger.WriteBatch, rename func(key string) (string, bool)) error {
+	return forEachItem(db, func(item *badger.Item) error {
+		key := item.KeyCopy(nil)
+		newKey, ok := rename(string(key))
+		if !ok {
+			return nil
+		}
+		v, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+		if err := wb.Set([]byte(newKey), v); err != nil {
+			return err
+		}
+		return wb.Delete(key)
+	})
+}
+
+var (
+	legacyPartfolioRx = regexp.MustCompile(`^(\d+)_parfolio(.+)$`)
+	legacyFinishedRx  = regexp.MustCompile(`^(\d+)_finished$`)
+)
+
+// foreignSharesBoard is the board of foreign shares which SECIDs have -RM suffix, it is moex.BoardForeignStock
+const foreignSharesBoard = "FQBR"
+
+// migrateSinglePortfolios moves data stored before named portfolios were introduced into DefaultPortfolio.
+// Foreign shares are referred by board, other SECIDs are kept bare until user picks a board for them.
+// Portfolio is registered before its keys are moved, so that interrupted migration doesn't lose it.
+func migrateSinglePortfolios(db *badger.DB, wb *badger.WriteBatch) error {
+	users := make(map[int]bool)
+	err := forEachItem(db, func(item *badger.Item) error {
+		key := string(item.Key())
+		for _, rx := range []*regexp.Regexp{legacyPartfolioRx, legacyFinishedRx} {
+			if m := rx.FindStringSubmatch(key); m != nil {
+				users[atoi(m[1])] = true
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for userID := range users {
+		if err := wb.Set([]byte(getPortfoliosPrefix(userID)+DefaultPortfolio), []byte{}); err != nil {
+			return err
+		}
+	}
+
+	return renameKeys(db, wb, func(key string) (string, bool) {
+		if m := legacyPartfolioRx.FindStringSubmatch(key); m != nil {
+			return getPartfolioPrefix(atoi(m[1]), DefaultPortfolio) + foreignRef(m[2]), true
+		}
+		if m := legacyFinishedRx.FindStringSubmatch(key); m != nil {
+			return getFinishedKey(atoi(m[1]), DefaultPortfolio), true
+		}
+		return "", false
+	})
+}
+
+// atoi is used only for ids already matched by \d+
+func atoi(s string) int {
+	i, _ := strconv.Atoi(s)
+	return i
+}
+
+func foreignRef(secid string) string {
+	if !strings.HasSuffix(secid, "-RM") {
+		return secid
+	}
+	return foreignSharesBoard + ":" + secid
+}
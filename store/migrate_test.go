@@ -0,0 +1,212 @@
+package store
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+// v0Fixture is a db written before schema versioning, when every user had a single portfolio
+var v0Fixture = map[string][]byte{
+	"1_parfolioAFKS":     float64ToBytes(60),
+	"1_parfolioSBER":     float64ToBytes(40),
+	"1_finished":         {},
+	"12_parfolioGAZP":    float64ToBytes(70),
+	"12_parfolioFXGD-RM": float64ToBytes(30),
+}
+
+func TestStore_migrateV0(t *testing.T) {
+	dir := t.TempDir()
+	writeFixture(t, dir, v0Fixture)
+
+	s, err := New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkFloats := func(name string, got map[string]float64, err error, want map[string]float64) {
+		t.Helper()
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %v, want %v", name, got, want)
+		}
+	}
+	checkFinished := func(userID int, portfolio string, want bool) {
+		t.Helper()
+		got, err := s.IsUserFinished(userID, portfolio)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("finished %d %s: got %v, want %v", userID, portfolio, got, want)
+		}
+	}
+
+	p, err := s.GetPartfolio(1, DefaultPortfolio)
+	checkFloats("user 1 targets", p, err, map[string]float64{"AFKS": 60, "SBER": 40})
+	checkFinished(1, DefaultPortfolio, true)
+
+	p, err = s.GetPartfolio(12, DefaultPortfolio)
+	checkFloats("user 12 targets", p, err, map[string]float64{"GAZP": 70, "FQBR:FXGD-RM": 30})
+	checkFinished(12, DefaultPortfolio, false)
+
+	list, err := s.ListPortfolios(12)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{DefaultPortfolio}; !reflect.DeepEqual(list, want) {
+		t.Errorf("user 12 portfolios: got %v, want %v", list, want)
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	keys := readKeys(t, dir)
+	for key := range v0Fixture {
+		if _, ok := keys[key]; ok {
+			t.Errorf("legacy key %s is left after migration", key)
+		}
+	}
+	if got, want := string(keys[schemaVersionKey]), strconv.Itoa(SchemaVersion()); got != want {
+		t.Errorf("schema version: got %q, want %s", got, want)
+	}
+
+	// reopening migrated db must not change anything
+	s, err = New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err = s.GetPartfolio(1, DefaultPortfolio)
+	checkFloats("user 1 targets after reopen", p, err, map[string]float64{"AFKS": 60, "SBER": 40})
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if after := readKeys(t, dir); !reflect.DeepEqual(after, keys) {
+		t.Errorf("reopen changed keys: got %v, want %v", after, keys)
+	}
+}
+
+func TestStore_migrateBigDB(t *testing.T) {
+	dir := t.TempDir()
+	const n = 20000
+	// renames of n keys with such values don't fit into one transaction
+	value := make([]byte, 1024)
+	fixture := make(map[string][]byte, n)
+	for i := 0; i < n; i++ {
+		fixture[fmt.Sprintf("1_parfolioSEC%d", i)] = value
+	}
+	writeFixture(t, dir, fixture)
+
+	s, err := New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var migrated int
+	for key := range readKeys(t, dir) {
+		if strings.HasPrefix(key, getPartfolioPrefix(1, DefaultPortfolio)) {
+			migrated++
+		}
+	}
+	if migrated != n {
+		t.Errorf("expected %d migrated targets, got %d", n, migrated)
+	}
+}
+
+func TestStore_migrateV0_interrupted(t *testing.T) {
+	dir := t.TempDir()
+	// portfolio is registered and the first key is already migrated by the interrupted run
+	writeFixture(t, dir, map[string][]byte{
+		"u/7/portfolios/default":             {},
+		"u/7/p/default/targets/FQBR:AAPL-RM": float64ToBytes(50),
+		"7_parfolioTSLA-RM":                  float64ToBytes(50),
+		"7_finished":                         {},
+	})
+
+	s, err := New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	p, err := s.GetPartfolio(7, DefaultPortfolio)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (Partfolio{"FQBR:AAPL-RM": 50, "FQBR:TSLA-RM": 50}); !reflect.DeepEqual(p, want) {
+		t.Errorf("targets: got %v, want %v", p, want)
+	}
+	finished, err := s.IsUserFinished(7, DefaultPortfolio)
+	if err != nil || !finished {
+		t.Errorf("expected portfolio to stay finished, got %v, %v", finished, err)
+	}
+}
+
+func TestStore_newerSchema(t *testing.T) {
+	dir := t.TempDir()
+	writeFixture(t, dir, map[string][]byte{schemaVersionKey: []byte("100")})
+
+	if s, err := New(dir); err == nil {
+		s.Close()
+		t.Fatal("expected error for db with unknown schema version")
+	}
+}
+
+func openBadger(t *testing.T, dir string) *badger.DB {
+	t.Helper()
+	db, err := badger.Open(badger.DefaultOptions(dir).WithLogger(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func writeFixture(t *testing.T, dir string, fixture map[string][]byte) {
+	t.Helper()
+	db := openBadger(t, dir)
+	wb := db.NewWriteBatch()
+	for k, v := range fixture {
+		if err := wb.Set([]byte(k), v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := wb.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readKeys(t *testing.T, dir string) map[string][]byte {
+	t.Helper()
+	db := openBadger(t, dir)
+	defer db.Close()
+	keys := make(map[string][]byte)
+	err := db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			v, err := it.Item().ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			keys[string(it.Item().KeyCopy(nil))] = v
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
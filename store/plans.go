@@ -0,0 +1,257 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/pkg/errors"
+)
+
+var ErrNoPendingPurchase = errors.New("no pending purchase")
+
+// Plan is a purchase of portfolio securities for Amount rubles on Day of every month
+type Plan struct {
+	Day    int     `json:"day"`
+	Amount float64 `json:"amount"`
+	// NextRun is when the plan is due next time, it is kept so that runs missed while bot was down are not lost
+	NextRun time.Time `json:"next_run"`
+	// Attempts is the number of failed runs since the last successful one, NextRun is a retry time while it is not zero
+	Attempts int `json:"attempts,omitempty"`
+}
+
+// ScheduledPlan is a plan together with its owner
+type ScheduledPlan struct {
+	UserID    int
+	Portfolio string
+	Plan
+}
+
+// Purchase is a suggested purchase, it is added to holdings when user confirms that securities were bought
+type Purchase struct {
+	// ID tells one suggestion from another, it is unique among purchases of user.
+	// Only the last suggestion of portfolio can be confirmed.
+	ID int64 `json:"id"`
+	// Quantities are numbers of securities to buy, Prices are prices of one security in rubles
+	Quantities map[string]float64 `json:"quantities"`
+	Prices     map[string]float64 `json:"prices"`
+}
+
+// SetPlan replaces plan of the portfolio
+func (s *Store) SetPlan(userID int, portfolio string, plan Plan) error {
+	return s.db.Update(func(txn *badger.Txn) error {
+		if err := registerPortfolio(txn, userID, portfolio); err != nil {
+			return err
+		}
+		return putPlan(txn, userID, portfolio, &plan)
+	})
+}
+
+func (s *Store) DeletePlan(userID int, portfolio string) error {
+	return s.db.Update(func(txn *badger.Txn) error {
+		return putPlan(txn, userID, portfolio, nil)
+	})
+}
+
+// GetPlan returns plan of the portfolio, false is returned if there is none
+func (s *Store) GetPlan(userID int, portfolio string) (plan Plan, ok bool, err error) {
+	err = s.db.View(func(txn *badger.Txn) error {
+		ok, err = getJSON(txn, getPlanKey(userID, portfolio), &plan)
+		return err
+	})
+	return plan, ok, err
+}
+
+// DuePlans returns plans of all users with NextRun not after now, sorted by NextRun.
+// Only the index of plans is read, so that data of users without due plans is not touched.
+func (s *Store) DuePlans(now time.Time) ([]ScheduledPlan, error) {
+	var plans []ScheduledPlan
+	err := s.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		prefix := []byte(plansIndexPrefix)
+
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			// plans/<nextRun>/<userID>/<portfolio>
+			parts := strings.Split(string(it.Item().Key()), keySep)
+			if len(parts) != 4 {
+				return errors.Errorf("unexpected key %s", it.Item().Key())
+			}
+			nextRun, err := strconv.ParseInt(parts[1], 10, 64)
+			if err != nil {
+				return errors.Wrapf(err, "unexpected key %s", it.Item().Key())
+			}
+			if nextRun > now.Unix() { // index is sorted by next run
+				break
+			}
+			userID, err := strconv.Atoi(parts[2])
+			if err != nil {
+				return errors.Wrapf(err, "unexpected key %s", it.Item().Key())
+			}
+			p := ScheduledPlan{UserID: userID, Portfolio: parts[3]}
+			ok, err := getJSON(txn, getPlanKey(userID, p.Portfolio), &p.Plan)
+			if err != nil {
+				return err
+			}
+			if ok && !p.NextRun.After(now) {
+				plans = append(plans, p)
+			}
+		}
+		return nil
+	})
+	sort.SliceStable(plans, func(i, j int) bool { return plans[i].NextRun.Before(plans[j].NextRun) })
+	return plans, err
+}
+
+// SetPlanNextRun moves the next run of the plan after a successful run, failed attempts are reset.
+// Nothing is done if the plan was removed meanwhile.
+func (s *Store) SetPlanNextRun(userID int, portfolio string, next time.Time) error {
+	return s.updatePlan(userID, portfolio, func(plan *Plan) {
+		plan.NextRun = next
+		plan.Attempts = 0
+	})
+}
+
+// MarkPlanAttempt counts an attempt to run the plan and moves its next run to retryAt.
+// It is saved before the run, so that the plan is not run again until retryAt if bot stops in the middle.
+// Nothing is done if the plan was removed meanwhile.
+func (s *Store) MarkPlanAttempt(userID int, portfolio string, retryAt time.Time) error {
+	return s.updatePlan(userID, portfolio, func(plan *Plan) {
+		plan.NextRun = retryAt
+		plan.Attempts++
+	})
+}
+
+func (s *Store) updatePlan(userID int, portfolio string, update func(plan *Plan)) error {
+	return s.db.Update(func(txn *badger.Txn) error {
+		var plan Plan
+		ok, err := getJSON(txn, getPlanKey(userID, portfolio), &plan)
+		if err != nil || !ok {
+			return err
+		}
+		update(&plan)
+		return putPlan(txn, userID, portfolio, &plan)
+	})
+}
+
+// putPlan saves the plan and keeps index of plans by next run in sync with it, nil plan is deleted
+func putPlan(txn *badger.Txn, userID int, portfolio string, plan *Plan) error {
+	var old Plan
+	ok, err := getJSON(txn, getPlanKey(userID, portfolio), &old)
+	if err != nil {
+		return err
+	}
+	if ok {
+		if err := txn.Delete([]byte(getPlanIndexKey(userID, portfolio, old.NextRun))); err != nil {
+			return err
+		}
+	}
+	if plan == nil {
+		return txn.Delete([]byte(getPlanKey(userID, portfolio)))
+	}
+	if err := txn.Set([]byte(getPlanIndexKey(userID, portfolio, plan.NextRun)), []byte{}); err != nil {
+		return err
+	}
+	return setJSON(txn, getPlanKey(userID, portfolio), plan)
+}
+
+// SetPendingPurchase replaces purchase waiting for confirmation
+func (s *Store) SetPendingPurchase(userID int, portfolio string, p Purchase) error {
+	return s.db.Update(func(txn *badger.Txn) error {
+		return setJSON(txn, getPurchaseKey(userID, portfolio), p)
+	})
+}
+
+// ConfirmPurchase adds pending purchase with the id to holdings of its portfolio, which is returned.
+// Bought securities are logged as trades.
+// ErrNoPendingPurchase is returned if the purchase was already confirmed or replaced by a newer one.
+func (s *Store) ConfirmPurchase(userID int, id int64) (string, Purchase, error) {
+	var (
+		portfolio string
+		p         Purchase
+	)
+	err := s.db.Update(func(txn *badger.Txn) error {
+		var err error
+		portfolio, p, err = pendingPurchase(txn, userID, id)
+		if err != nil {
+			return err
+		}
+		current, err := getFloats(txn, getHoldingsPrefix(userID, portfolio))
+		if err != nil {
+			return err
+		}
+		holdings := make(map[string]float64, len(p.Quantities))
+		for secid, qty := range p.Quantities {
+			holdings[secid] = current[secid] + qty
+		}
+		if err := setHoldings(txn, userID, portfolio, holdings, p.Prices); err != nil {
+			return err
+		}
+		return txn.Delete([]byte(getPurchaseKey(userID, portfolio)))
+	})
+	return portfolio, p, err
+}
+
+// pendingPurchase finds purchase with the id among pending purchases of all user portfolios
+func pendingPurchase(txn *badger.Txn, userID int, id int64) (string, Purchase, error) {
+	it := txn.NewIterator(badger.DefaultIteratorOptions)
+	defer it.Close()
+	prefix := []byte(getUserScope(userID) + "p" + keySep)
+
+	for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+		// u/<userID>/p/<portfolio>/purchase
+		parts := strings.Split(string(it.Item().Key()), keySep)
+		if len(parts) != 5 || parts[4] != "purchase" {
+			continue
+		}
+		var p Purchase
+		if err := it.Item().Value(func(v []byte) error { return json.Unmarshal(v, &p) }); err != nil {
+			return "", Purchase{}, err
+		}
+		if p.ID == id {
+			return parts[3], p, nil
+		}
+	}
+	return "", Purchase{}, ErrNoPendingPurchase
+}
+
+// getJSON decodes value of the key into v, false is returned if there is no such key
+func getJSON(txn *badger.Txn, key string, v interface{}) (bool, error) {
+	item, err := txn.Get([]byte(key))
+	if err == badger.ErrKeyNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, item.Value(func(data []byte) error { return json.Unmarshal(data, v) })
+}
+
+func setJSON(txn *badger.Txn, key string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return txn.Set([]byte(key), data)
+}
+
+func getPlanKey(userID int, portfolio string) string {
+	return getPortfolioScope(userID, portfolio) + "plan"
+}
+
+const plansIndexPrefix = "plans" + keySep
+
+// getPlanIndexKey pads next run with zeros, so that plans are iterated in order they are due
+func getPlanIndexKey(userID int, portfolio string, nextRun time.Time) string {
+	return plansIndexPrefix + fmt.Sprintf("%020d", nextRun.Unix()) + keySep + strconv.Itoa(userID) + keySep + portfolio
+}
+
+func getPurchaseKey(userID int, portfolio string) string {
+	return getPortfolioScope(userID, portfolio) + "purchase"
+}
@@ -0,0 +1,134 @@
+package store
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestStore_DuePlans(t *testing.T) {
+	s, err := New("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	now := time.Date(2021, 11, 15, 10, 0, 0, 0, time.UTC)
+	if err := s.SetPlan(1, DefaultPortfolio, Plan{Day: 15, Amount: 30000, NextRun: now}); err != nil {
+		t.Fatal(err)
+	}
+	// missed while bot was down
+	if err := s.SetPlan(12, "iis", Plan{Day: 1, Amount: 10000, NextRun: now.AddDate(0, 0, -14)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetPlan(1, "iis", Plan{Day: 20, Amount: 5000, NextRun: now.AddDate(0, 0, 5)}); err != nil {
+		t.Fatal(err)
+	}
+
+	due, err := s.DuePlans(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []ScheduledPlan{
+		{UserID: 12, Portfolio: "iis", Plan: Plan{Day: 1, Amount: 10000, NextRun: now.AddDate(0, 0, -14)}},
+		{UserID: 1, Portfolio: DefaultPortfolio, Plan: Plan{Day: 15, Amount: 30000, NextRun: now}},
+	}
+	if !reflect.DeepEqual(due, want) {
+		t.Errorf("expected due plans %+v, got %+v", want, due)
+	}
+
+	// failed run is retried later
+	if err := s.MarkPlanAttempt(1, DefaultPortfolio, now.Add(5*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	due, err = s.DuePlans(now.Add(5 * time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(due) != 2 || due[1].UserID != 1 || due[1].Attempts != 1 || !due[1].NextRun.Equal(now.Add(5*time.Minute)) {
+		t.Errorf("expected plan to be retried after failed attempt, got %+v", due)
+	}
+
+	if err := s.SetPlanNextRun(1, DefaultPortfolio, now.AddDate(0, 1, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeletePlan(12, "iis"); err != nil {
+		t.Fatal(err)
+	}
+	// plan of deleted portfolio is not due anymore
+	if err := s.DeletePortfolio(1, "iis"); err != nil {
+		t.Fatal(err)
+	}
+	if due, err := s.DuePlans(now.AddDate(0, 0, 5)); err != nil || len(due) != 0 {
+		t.Errorf("expected plans of deleted portfolio to be removed, got %+v, %v", due, err)
+	}
+	if due, err := s.DuePlans(now); err != nil || len(due) != 0 {
+		t.Errorf("expected no due plans after run, got %+v, %v", due, err)
+	}
+	plan, ok, err := s.GetPlan(1, DefaultPortfolio)
+	if err != nil || !ok || !plan.NextRun.Equal(now.AddDate(0, 1, 0)) || plan.Attempts != 0 {
+		t.Errorf("expected next run to be moved and attempts to be reset, got %+v, %v, %v", plan, ok, err)
+	}
+}
+
+func TestStore_ConfirmPurchase(t *testing.T) {
+	s, err := New("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	const userID = 1
+	if err := s.SetHoldings(userID, DefaultPortfolio, map[string]float64{"TQBR:SBER": 10}, nil); err != nil {
+		t.Fatal(err)
+	}
+	old := Purchase{ID: 1, Quantities: map[string]float64{"TQBR:SBER": 100}}
+	if err := s.SetPendingPurchase(userID, DefaultPortfolio, old); err != nil {
+		t.Fatal(err)
+	}
+	p := Purchase{
+		ID:         2,
+		Quantities: map[string]float64{"TQBR:SBER": 20, "TQBR:AFKS": 100},
+		Prices:     map[string]float64{"TQBR:SBER": 300, "TQBR:AFKS": 20},
+	}
+	if err := s.SetPendingPurchase(userID, DefaultPortfolio, p); err != nil {
+		t.Fatal(err)
+	}
+
+	other := Purchase{ID: 3, Quantities: map[string]float64{"TQBR:GAZP": 10}}
+	if err := s.SetPendingPurchase(userID, "iis", other); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := s.ConfirmPurchase(userID, old.ID); err != ErrNoPendingPurchase {
+		t.Errorf("expected replaced purchase not to be confirmed, got %v", err)
+	}
+	portfolio, confirmed, err := s.ConfirmPurchase(userID, p.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if portfolio != DefaultPortfolio || !reflect.DeepEqual(confirmed, p) {
+		t.Errorf("expected purchase %+v of %s to be confirmed, got %+v of %s", p, DefaultPortfolio, confirmed, portfolio)
+	}
+	if _, _, err := s.ConfirmPurchase(userID, p.ID); err != ErrNoPendingPurchase {
+		t.Errorf("expected purchase to be confirmed only once, got %v", err)
+	}
+
+	holdings, err := s.GetHoldings(userID, DefaultPortfolio)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (Holdings{"TQBR:SBER": 30, "TQBR:AFKS": 100}); !reflect.DeepEqual(holdings, want) {
+		t.Errorf("expected holdings %v, got %v", want, holdings)
+	}
+	trades, err := s.Trades(userID, DefaultPortfolio, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trades) != 3 || trades[1].SecID != "TQBR:AFKS" || trades[1].Price != 20 || trades[2].Quantity != 20 || trades[2].Price != 300 {
+		t.Errorf("expected purchase to be logged as trades, got %+v", trades)
+	}
+	if portfolio, _, err := s.ConfirmPurchase(userID, other.ID); err != nil || portfolio != "iis" {
+		t.Errorf("expected purchase of another portfolio to stay pending, got %s, %v", portfolio, err)
+	}
+}
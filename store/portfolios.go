@@ -0,0 +1,153 @@
+package store
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/pkg/errors"
+)
+
+// DefaultPortfolio is active until user switches to another portfolio
+const DefaultPortfolio = "default"
+
+var (
+	ErrPortfolioExists   = errors.New("portfolio already exists")
+	ErrPortfolioNotFound = errors.New("portfolio not found")
+	ErrPortfolioIsActive = errors.New("portfolio is active")
+)
+
+// ActivePortfolio returns the portfolio all commands work with
+func (s *Store) ActivePortfolio(userID int) (portfolio string, err error) {
+	err = s.db.View(func(txn *badger.Txn) error {
+		portfolio, err = activePortfolio(txn, userID)
+		return err
+	})
+	return portfolio, err
+}
+
+// CreatePortfolio creates empty portfolio and makes it active
+func (s *Store) CreatePortfolio(userID int, portfolio string) error {
+	return s.db.Update(func(txn *badger.Txn) error {
+		exists, err := portfolioExists(txn, userID, portfolio)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return ErrPortfolioExists
+		}
+		if err := registerPortfolio(txn, userID, portfolio); err != nil {
+			return err
+		}
+		return txn.Set([]byte(getActiveKey(userID)), []byte(portfolio))
+	})
+}
+
+func (s *Store) SwitchPortfolio(userID int, portfolio string) error {
+	return s.db.Update(func(txn *badger.Txn) error {
+		exists, err := portfolioExists(txn, userID, portfolio)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return ErrPortfolioNotFound
+		}
+		return txn.Set([]byte(getActiveKey(userID)), []byte(portfolio))
+	})
+}
+
+// ListPortfolios returns sorted names of all user portfolios, active one is always listed
+func (s *Store) ListPortfolios(userID int) ([]string, error) {
+	var portfolios []string
+	err := s.db.View(func(txn *badger.Txn) error {
+		active, err := activePortfolio(txn, userID)
+		if err != nil {
+			return err
+		}
+		portfolios = append(portfolios, active)
+
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		prefix := getPortfoliosPrefix(userID)
+		bprefix := []byte(prefix)
+
+		for it.Seek(bprefix); it.ValidForPrefix(bprefix); it.Next() {
+			name := strings.TrimPrefix(string(it.Item().Key()), prefix)
+			if name != active {
+				portfolios = append(portfolios, name)
+			}
+		}
+		return nil
+	})
+	sort.Strings(portfolios)
+	return portfolios, err
+}
+
+// DeletePortfolio removes portfolio with its targets and holdings. Active portfolio can't be deleted.
+func (s *Store) DeletePortfolio(userID int, portfolio string) error {
+	return s.db.Update(func(txn *badger.Txn) error {
+		active, err := activePortfolio(txn, userID)
+		if err != nil {
+			return err
+		}
+		if active == portfolio {
+			return ErrPortfolioIsActive
+		}
+		exists, err := portfolioExists(txn, userID, portfolio)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return ErrPortfolioNotFound
+		}
+		if err := putPlan(txn, userID, portfolio, nil); err != nil {
+			return err
+		}
+		if err := deleteDrift(txn, userID, portfolio); err != nil {
+			return err
+		}
+		if err := deletePrefix(txn, getPortfolioScope(userID, portfolio)); err != nil {
+			return err
+		}
+		return txn.Delete([]byte(getPortfoliosPrefix(userID) + portfolio))
+	})
+}
+
+var portfolioNameRx = regexp.MustCompile(`^[\p{L}\d-]{1,32}$`)
+
+// ValidPortfolioName reports whether name can be used as portfolio name:
+// up to 32 letters, digits or dashes
+func ValidPortfolioName(name string) bool {
+	return portfolioNameRx.MatchString(name)
+}
+
+func activePortfolio(txn *badger.Txn, userID int) (string, error) {
+	item, err := txn.Get([]byte(getActiveKey(userID)))
+	if err != nil {
+		if err == badger.ErrKeyNotFound {
+			return DefaultPortfolio, nil
+		}
+		return "", err
+	}
+	v, err := item.ValueCopy(nil)
+	return string(v), err
+}
+
+func portfolioExists(txn *badger.Txn, userID int, portfolio string) (bool, error) {
+	_, err := txn.Get([]byte(getPortfoliosPrefix(userID) + portfolio))
+	if err != nil {
+		if err == badger.ErrKeyNotFound {
+			return portfolio == DefaultPortfolio, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// registerPortfolio makes portfolio visible in ListPortfolios
+func registerPortfolio(txn *badger.Txn, userID int, portfolio string) error {
+	return txn.Set([]byte(getPortfoliosPrefix(userID)+portfolio), []byte{})
+}
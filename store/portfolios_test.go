@@ -0,0 +1,115 @@
+package store
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestStore_portfolios(t *testing.T) {
+	s, err := New("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	checkList := func(want ...string) {
+		t.Helper()
+		got, err := s.ListPortfolios(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("expected portfolios %v, got %v", want, got)
+		}
+	}
+	checkActive := func(want string) {
+		t.Helper()
+		got, err := s.ActivePortfolio(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("expected active portfolio %s, got %s", want, got)
+		}
+	}
+
+	// default portfolio exists before anything is written
+	checkActive(DefaultPortfolio)
+	checkList(DefaultPortfolio)
+	if err := s.AddToPartfolio(1, DefaultPortfolio, map[string]float64{"TQBR:SBER": 100}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.CreatePortfolio(1, "iis"); err != nil {
+		t.Fatal(err)
+	}
+	checkActive("iis")
+	if err := s.CreatePortfolio(1, "iis"); err != ErrPortfolioExists {
+		t.Errorf("expected ErrPortfolioExists on duplicate create, got %v", err)
+	}
+	if err := s.CreatePortfolio(1, DefaultPortfolio); err != ErrPortfolioExists {
+		t.Errorf("expected ErrPortfolioExists on default create, got %v", err)
+	}
+	// other users have their own portfolios
+	if err := s.CreatePortfolio(2, "iis"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.SwitchPortfolio(1, "broker"); err != ErrPortfolioNotFound {
+		t.Errorf("expected ErrPortfolioNotFound on switch to unknown portfolio, got %v", err)
+	}
+	if err := s.SwitchPortfolio(1, DefaultPortfolio); err != nil {
+		t.Fatal(err)
+	}
+	checkActive(DefaultPortfolio)
+	checkList(DefaultPortfolio, "iis")
+
+	if err := s.DeletePortfolio(1, DefaultPortfolio); err != ErrPortfolioIsActive {
+		t.Errorf("expected ErrPortfolioIsActive on active delete, got %v", err)
+	}
+	if err := s.DeletePortfolio(1, "broker"); err != ErrPortfolioNotFound {
+		t.Errorf("expected ErrPortfolioNotFound on unknown delete, got %v", err)
+	}
+
+	// default portfolio may be deleted when it is not active, it stays listed empty while it is active again
+	if err := s.SwitchPortfolio(1, "iis"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeletePortfolio(1, DefaultPortfolio); err != nil {
+		t.Fatal(err)
+	}
+	checkList("iis")
+	p, err := s.GetPartfolio(1, DefaultPortfolio)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p) != 0 {
+		t.Errorf("expected targets of deleted portfolio to be removed, got %v", p)
+	}
+	if err := s.SwitchPortfolio(1, DefaultPortfolio); err != nil {
+		t.Fatal(err)
+	}
+	checkList(DefaultPortfolio, "iis")
+}
+
+func TestValidPortfolioName(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"iis", true},
+		{"ИИС-2", true},
+		{strings.Repeat("a", 32), true},
+		{"", false},
+		{strings.Repeat("a", 33), false},
+		{"my iis", false},
+		{"a/b", false},
+		{"a_b", false},
+	}
+	for _, tt := range tests {
+		if got := ValidPortfolioName(tt.name); got != tt.want {
+			t.Errorf("ValidPortfolioName(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
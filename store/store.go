@@ -2,6 +2,7 @@ package store
 
 import (
 	"encoding/binary"
+	"encoding/json"
 	"math"
 	"strconv"
 	"strings"
@@ -25,27 +26,64 @@ func New(path string) (*Store, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &Store{db: db}, nil
+	s := &Store{db: db}
+	if err := s.migrate(); err != nil {
+		if cerr := db.Close(); cerr != nil {
+			return nil, errors.Wrapf(err, "error while closing db: %v, after failed migration", cerr)
+		}
+		return nil, errors.Wrap(err, "error while migrating store")
+	}
+	return s, nil
 }
 
 func (s *Store) Close() error {
 	return s.db.Close()
 }
 
+// Partfolio is target percent by security. Securities are referred in "BOARD:SECID" format,
+// bare SECID is left only by data written before boards were known until user picks its board, see ResolveSecurity.
 type Partfolio map[string]float64
 
-func (s *Store) AddToPartfolio(userID int, secidPercent map[string]float64) error {
+func (s *Store) AddToPartfolio(userID int, portfolio string, secidPercent map[string]float64) error {
 	return s.db.Update(func(txn *badger.Txn) error {
-		finished, err := s.isUserFinished(txn, userID)
-		if err != nil {
-			return err
+		return s.addToPartfolio(txn, userID, portfolio, secidPercent)
+	})
+}
+
+// ImportPortfolio applies targets like AddToPartfolio and holdings like SetHoldings at once,
+// so failed import leaves portfolio untouched.
+func (s *Store) ImportPortfolio(userID int, portfolio string, secidPercent map[string]float64, secidQty map[string]float64, prices map[string]float64) error {
+	return s.db.Update(func(txn *badger.Txn) error {
+		if len(secidPercent) > 0 {
+			if err := s.addToPartfolio(txn, userID, portfolio, secidPercent); err != nil {
+				return err
+			}
 		}
-		if finished {
-			return ErrUserIsFinished
+		if len(secidQty) == 0 {
+			return nil
+		}
+		if err := registerPortfolio(txn, userID, portfolio); err != nil {
+			return err
 		}
+		return setHoldings(txn, userID, portfolio, secidQty, prices)
+	})
+}
 
+func (s *Store) addToPartfolio(txn *badger.Txn, userID int, portfolio string, secidPercent map[string]float64) error {
+	finished, err := s.isUserFinished(txn, userID, portfolio)
+	if err != nil {
+		return err
+	}
+	if finished {
+		return ErrUserIsFinished
+	}
+	if err := registerPortfolio(txn, userID, portfolio); err != nil {
+		return err
+	}
+
+	return logChange(txn, userID, portfolio, Change{Action: ActionAdd, Targets: secidPercent}, func() error {
 		for secid, percent := range secidPercent {
-			key := getPartfolioPrefix(userID) + secid
+			key := getPartfolioPrefix(userID, portfolio) + secid
 			var err error
 			switch percent {
 			case 0:
@@ -57,80 +95,256 @@ func (s *Store) AddToPartfolio(userID int, secidPercent map[string]float64) erro
 				return err
 			}
 		}
-
 		return nil
 	})
 }
 
-func (s *Store) IsUserFinished(userID int) (finished bool, err error) {
+// Holdings is the number of securities user actually has, securities are referred like in Partfolio
+type Holdings map[string]float64
+
+// SetHoldings replaces number of held securities, zero quantity removes security from holdings.
+// Every change of quantity is logged as a Trade with price in rubles taken from prices, they may be missing.
+func (s *Store) SetHoldings(userID int, portfolio string, secidQty map[string]float64, prices map[string]float64) error {
+	return s.db.Update(func(txn *badger.Txn) error {
+		if err := registerPortfolio(txn, userID, portfolio); err != nil {
+			return err
+		}
+		return setHoldings(txn, userID, portfolio, secidQty, prices)
+	})
+}
+
+func setHoldings(txn *badger.Txn, userID int, portfolio string, secidQty map[string]float64, prices map[string]float64) error {
+	current, err := getFloats(txn, getHoldingsPrefix(userID, portfolio))
+	if err != nil {
+		return err
+	}
+	deltas := make(map[string]float64)
+	for secid, qty := range secidQty {
+		if delta := qty - current[secid]; delta != 0 {
+			deltas[secid] = delta
+		}
+		key := getHoldingsPrefix(userID, portfolio) + secid
+		var err error
+		switch qty {
+		case 0:
+			err = txn.Delete([]byte(key))
+		default:
+			err = txn.Set([]byte(key), float64ToBytes(qty))
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return logTrades(txn, userID, portfolio, deltas, prices)
+}
+
+func (s *Store) GetHoldings(userID int, portfolio string) (Holdings, error) {
+	var holdings Holdings
+	err := s.db.View(func(txn *badger.Txn) (err error) {
+		holdings, err = getFloats(txn, getHoldingsPrefix(userID, portfolio))
+		return err
+	})
+	return holdings, err
+}
+
+func (s *Store) IsUserFinished(userID int, portfolio string) (finished bool, err error) {
 	err = s.db.View(func(txn *badger.Txn) error {
-		finished, err = s.isUserFinished(txn, userID)
+		finished, err = s.isUserFinished(txn, userID, portfolio)
 		return err
 	})
 	return finished, err
 }
 
-func (s *Store) Finish(userID int) error {
+func (s *Store) Finish(userID int, portfolio string) error {
 	return s.db.Update(func(txn *badger.Txn) error {
-		finished, err := s.isUserFinished(txn, userID)
+		finished, err := s.isUserFinished(txn, userID, portfolio)
 		if err != nil {
 			return err
 		}
 		if finished {
 			return ErrUserIsFinished
 		}
-		return txn.Set([]byte(getFinishedKey(userID)), []byte{})
+		if err := registerPortfolio(txn, userID, portfolio); err != nil {
+			return err
+		}
+		return logChange(txn, userID, portfolio, Change{Action: ActionFinish}, func() error {
+			return txn.Set([]byte(getFinishedKey(userID, portfolio)), []byte{})
+		})
 	})
 }
 
-func (s *Store) GetPartfolio(userID int) (Partfolio, error) {
-	partfolio := make(Partfolio)
-	err := s.db.View(func(txn *badger.Txn) error {
-		it := txn.NewIterator(badger.DefaultIteratorOptions)
-		defer it.Close()
-		prefix := getPartfolioPrefix(userID)
-		bprefix := []byte(prefix)
+func (s *Store) GetPartfolio(userID int, portfolio string) (Partfolio, error) {
+	var partfolio Partfolio
+	err := s.db.View(func(txn *badger.Txn) (err error) {
+		partfolio, err = getFloats(txn, getPartfolioPrefix(userID, portfolio))
+		return err
+	})
+
+	return partfolio, err
+}
 
-		for it.Seek(bprefix); it.ValidForPrefix(bprefix); it.Next() {
+// ResolveSecurity replaces bare SECID written before boards were known by ref with board in targets,
+// holdings, trades and change log of all user portfolios. Values already stored under ref are summed with moved ones.
+func (s *Store) ResolveSecurity(userID int, secid, ref string) error {
+	if secid == ref {
+		return nil
+	}
+	return s.db.Update(func(txn *badger.Txn) error {
+		var (
+			floats  = make(map[string]float64)
+			trades  = make(map[string]Trade)
+			changes = make(map[string]Change)
+		)
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		prefix := []byte(getUserScope(userID) + "p" + keySep)
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
 			item := it.Item()
-			k := item.Key()
+			key := string(item.KeyCopy(nil))
+			// u/<userID>/p/<portfolio>/targets|holdings/<secid> or u/<userID>/p/<portfolio>/trades|history/<seq>
+			parts := strings.Split(key, keySep)
+			if len(parts) != 6 {
+				continue
+			}
 			err := item.Value(func(v []byte) error {
-				key := strings.TrimPrefix(string(k), prefix)
-				partfolio[key] = bytesToFloat64(v)
+				switch parts[4] {
+				case "targets", "holdings":
+					if parts[5] == secid {
+						floats[key] = bytesToFloat64(v)
+					}
+				case "trades":
+					var t Trade
+					if err := json.Unmarshal(v, &t); err != nil {
+						return err
+					}
+					if t.SecID == secid {
+						t.SecID = ref
+						trades[key] = t
+					}
+				case "history":
+					var c Change
+					if err := json.Unmarshal(v, &c); err != nil {
+						return err
+					}
+					targets, changed := resolvePartfolio(c.Targets, secid, ref)
+					before, changedBefore := resolvePartfolio(c.Before.Targets, secid, ref)
+					if changed || changedBefore {
+						c.Targets, c.Before.Targets = targets, before
+						changes[key] = c
+					}
+				}
 				return nil
 			})
 			if err != nil {
+				it.Close()
 				return err
 			}
 		}
+		it.Close()
 
+		for key, v := range floats {
+			newKey := strings.TrimSuffix(key, secid) + ref
+			item, err := txn.Get([]byte(newKey))
+			switch {
+			case err == nil:
+				if err := item.Value(func(b []byte) error { v += bytesToFloat64(b); return nil }); err != nil {
+					return err
+				}
+			case err != badger.ErrKeyNotFound:
+				return err
+			}
+			if err := txn.Set([]byte(newKey), float64ToBytes(v)); err != nil {
+				return err
+			}
+			if err := txn.Delete([]byte(key)); err != nil {
+				return err
+			}
+		}
+		for key, t := range trades {
+			if err := setJSON(txn, key, t); err != nil {
+				return err
+			}
+		}
+		// undo must not bring bare secid back
+		for key, c := range changes {
+			if err := setJSON(txn, key, c); err != nil {
+				return err
+			}
+		}
 		return nil
 	})
+}
 
-	return partfolio, err
+// resolvePartfolio moves value of secid to ref, false is returned if there is no secid in p
+func resolvePartfolio(p Partfolio, secid, ref string) (Partfolio, bool) {
+	v, ok := p[secid]
+	if !ok {
+		return p, false
+	}
+	res := make(Partfolio, len(p))
+	for key, value := range p {
+		res[key] = value
+	}
+	delete(res, secid)
+	res[ref] += v
+	return res, true
 }
 
-func (s *Store) ClearData(userID int) error {
-	return s.db.Update(func(txn *badger.Txn) error {
-		it := txn.NewIterator(badger.DefaultIteratorOptions)
-		defer it.Close()
-		prefix := getPartfolioPrefix(userID)
-		bprefix := []byte(prefix)
+// getFloats returns all values stored under prefix by key without prefix
+func getFloats(txn *badger.Txn, prefix string) (map[string]float64, error) {
+	res := make(map[string]float64)
+	it := txn.NewIterator(badger.DefaultIteratorOptions)
+	defer it.Close()
+	bprefix := []byte(prefix)
 
-		for it.Seek(bprefix); it.ValidForPrefix(bprefix); it.Next() {
-			err := txn.Delete(it.Item().Key())
-			if err != nil {
+	for it.Seek(bprefix); it.ValidForPrefix(bprefix); it.Next() {
+		item := it.Item()
+		k := item.Key()
+		err := item.Value(func(v []byte) error {
+			key := strings.TrimPrefix(string(k), prefix)
+			res[key] = bytesToFloat64(v)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return res, nil
+}
+
+// ClearData removes target percents of the portfolio, holdings and history are kept
+func (s *Store) ClearData(userID int, portfolio string) error {
+	return s.db.Update(func(txn *badger.Txn) error {
+		return logChange(txn, userID, portfolio, Change{Action: ActionClear}, func() error {
+			if err := deletePrefix(txn, getPartfolioPrefix(userID, portfolio)); err != nil {
 				return err
 			}
-		}
 
-		finishKey := getFinishedKey(userID)
-		return txn.Delete([]byte(finishKey))
+			finishKey := getFinishedKey(userID, portfolio)
+			return txn.Delete([]byte(finishKey))
+		})
 	})
 }
 
-func (s *Store) isUserFinished(txn *badger.Txn, userID int) (bool, error) {
-	_, err := txn.Get([]byte(getFinishedKey(userID)))
+func deletePrefix(txn *badger.Txn, prefix string) error {
+	opts := badger.DefaultIteratorOptions
+	opts.PrefetchValues = false
+	it := txn.NewIterator(opts)
+	defer it.Close()
+	bprefix := []byte(prefix)
+
+	for it.Seek(bprefix); it.ValidForPrefix(bprefix); it.Next() {
+		err := txn.Delete(it.Item().KeyCopy(nil))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Store) isUserFinished(txn *badger.Txn, userID int, portfolio string) (bool, error) {
+	_, err := txn.Get([]byte(getFinishedKey(userID, portfolio)))
 
 	if err != nil {
 		if err == badger.ErrKeyNotFound {
@@ -142,12 +356,51 @@ func (s *Store) isUserFinished(txn *badger.Txn, userID int) (bool, error) {
 	return true, nil
 }
 
-func getFinishedKey(userID int) string {
-	return strconv.Itoa(userID) + "_finished"
+// Key layout, every segment is followed by keySep, so that prefix of one user or portfolio never matches another one:
+//
+//	u/<userID>/active                            name of active portfolio
+//	u/<userID>/portfolios/<portfolio>            registry of user portfolios
+//	u/<userID>/p/<portfolio>/finished            portfolio input is finished
+//	u/<userID>/p/<portfolio>/targets/<secid>     target percent
+//	u/<userID>/p/<portfolio>/holdings/<secid>    number of held securities
+//	u/<userID>/p/<portfolio>/history/<seq>       json encoded Change, seq is zero padded
+//	u/<userID>/p/<portfolio>/trades/<seq>        json encoded Trade, seq is zero padded
+//	u/<userID>/p/<portfolio>/drift               json encoded DriftRule
+//	u/<userID>/p/<portfolio>/plan                json encoded Plan
+//	u/<userID>/p/<portfolio>/purchase            json encoded Purchase waiting for confirmation
+//	u/<userID>/alerts/<seq>                      json encoded PriceAlert, seq is zero padded
+//	plans/<nextRun>/<userID>/<portfolio>         index of plans by next run, nextRun is zero padded unix time
+//	rules/<userID>/alerts/<seq>                  index of alerts, seq is zero padded
+//	rules/<userID>/drift/<portfolio>             index of drift rules
+const keySep = "/"
+
+func getUserScope(userID int) string {
+	return "u" + keySep + strconv.Itoa(userID) + keySep
+}
+
+func getActiveKey(userID int) string {
+	return getUserScope(userID) + "active"
+}
+
+func getPortfoliosPrefix(userID int) string {
+	return getUserScope(userID) + "portfolios" + keySep
+}
+
+// getPortfolioScope is a prefix of all keys that belong to the portfolio
+func getPortfolioScope(userID int, portfolio string) string {
+	return getUserScope(userID) + "p" + keySep + portfolio + keySep
+}
+
+func getFinishedKey(userID int, portfolio string) string {
+	return getPortfolioScope(userID, portfolio) + "finished"
+}
+
+func getPartfolioPrefix(userID int, portfolio string) string {
+	return getPortfolioScope(userID, portfolio) + "targets" + keySep
 }
 
-func getPartfolioPrefix(userID int) string {
-	return strconv.Itoa(userID) + "_parfolio"
+func getHoldingsPrefix(userID int, portfolio string) string {
+	return getPortfolioScope(userID, portfolio) + "holdings" + keySep
 }
 
 func bytesToFloat64(bytes []byte) float64 {
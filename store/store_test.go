@@ -0,0 +1,169 @@
+package store
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestStore_keysDoNotCollide(t *testing.T) {
+	s, err := New("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if err := s.AddToPartfolio(1, DefaultPortfolio, map[string]float64{"AFKS": 100}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddToPartfolio(12, DefaultPortfolio, map[string]float64{"SBER": 100}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CreatePortfolio(1, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddToPartfolio(1, "a", map[string]float64{"GAZP": 50}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CreatePortfolio(1, "a-b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddToPartfolio(1, "a-b", map[string]float64{"LKOH": 50}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		userID    int
+		portfolio string
+		want      Partfolio
+	}{
+		{1, DefaultPortfolio, Partfolio{"AFKS": 100}},
+		{12, DefaultPortfolio, Partfolio{"SBER": 100}},
+		{1, "a", Partfolio{"GAZP": 50}},
+		{1, "a-b", Partfolio{"LKOH": 50}},
+	}
+	for _, tt := range tests {
+		got, err := s.GetPartfolio(tt.userID, tt.portfolio)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("GetPartfolio(%d, %s) = %v, want %v", tt.userID, tt.portfolio, got, tt.want)
+		}
+	}
+
+	if err := s.ClearData(1, DefaultPortfolio); err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.GetPartfolio(12, DefaultPortfolio)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, Partfolio{"SBER": 100}) {
+		t.Errorf("ClearData of user 1 touched user 12: %v", got)
+	}
+}
+
+func TestStore_ImportPortfolio(t *testing.T) {
+	s, err := New("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if err := s.ImportPortfolio(1, DefaultPortfolio, map[string]float64{"TQBR:SBER": 100}, map[string]float64{"TQBR:SBER": 10}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Finish(1, DefaultPortfolio); err != nil {
+		t.Fatal(err)
+	}
+	// targets of finished portfolio can't be changed, so holdings are not changed either
+	err = s.ImportPortfolio(1, DefaultPortfolio, map[string]float64{"TQBR:GAZP": 0}, map[string]float64{"TQBR:SBER": 20}, nil)
+	if err != ErrUserIsFinished {
+		t.Fatalf("expected ErrUserIsFinished, got %v", err)
+	}
+	h, err := s.GetHoldings(1, DefaultPortfolio)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(h, Holdings{"TQBR:SBER": 10}) {
+		t.Errorf("failed import changed holdings: %v", h)
+	}
+	trades, err := s.Trades(1, DefaultPortfolio, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trades) != 1 {
+		t.Errorf("failed import logged trades: %+v", trades)
+	}
+}
+
+func TestStore_ResolveSecurity(t *testing.T) {
+	s, err := New("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if err := s.AddToPartfolio(1, DefaultPortfolio, map[string]float64{"SBER": 60, "GAZP": 40}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddToPartfolio(1, DefaultPortfolio, map[string]float64{"LKOH": 10}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetHoldings(1, DefaultPortfolio, map[string]float64{"SBER": 10, "TQBR:SBER": 5}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddToPartfolio(1, "iis", map[string]float64{"SBER": 100}); err != nil {
+		t.Fatal(err)
+	}
+	// other users are not touched
+	if err := s.AddToPartfolio(2, DefaultPortfolio, map[string]float64{"SBER": 100}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.ResolveSecurity(1, "SBER", "TQBR:SBER"); err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(name string, got map[string]float64, err error, want map[string]float64) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %v, want %v", name, got, want)
+		}
+	}
+	p, err := s.GetPartfolio(1, DefaultPortfolio)
+	check("targets", p, err, Partfolio{"TQBR:SBER": 60, "GAZP": 40, "LKOH": 10})
+	h, err := s.GetHoldings(1, DefaultPortfolio)
+	check("holdings", h, err, Holdings{"TQBR:SBER": 15})
+	p, err = s.GetPartfolio(1, "iis")
+	check("targets of another portfolio", p, err, Partfolio{"TQBR:SBER": 100})
+	p, err = s.GetPartfolio(2, DefaultPortfolio)
+	check("targets of another user", p, err, Partfolio{"SBER": 100})
+
+	trades, err := s.Trades(1, DefaultPortfolio, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, trade := range trades {
+		if trade.SecID != "TQBR:SBER" {
+			t.Errorf("expected trade to be resolved, got %+v", trade)
+		}
+	}
+
+	history, err := s.History(1, DefaultPortfolio, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 2 {
+		t.Fatalf("expected 2 changes, got %+v", history)
+	}
+	check("history targets", history[0].Targets, nil, Partfolio{"TQBR:SBER": 60, "GAZP": 40})
+	check("history state before", history[1].Before.Targets, nil, Partfolio{"TQBR:SBER": 60, "GAZP": 40})
+	// undo must not bring bare secid back
+	restored, err := s.Undo(1, DefaultPortfolio, history[1].Seq)
+	check("undo", restored.Targets, err, Partfolio{"TQBR:SBER": 60, "GAZP": 40})
+}
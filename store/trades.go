@@ -0,0 +1,81 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+// Trade is a change of held quantity of a security, SetHoldings logs one for every changed security
+type Trade struct {
+	Seq   int       `json:"seq"`
+	Time  time.Time `json:"time"`
+	SecID string    `json:"secid"`
+	// Quantity is the change of held quantity, negative for sales
+	Quantity float64 `json:"quantity"`
+	// Price is the price of one security in rubles at the moment of trade, zero if it was unknown
+	Price float64 `json:"price"`
+}
+
+// Trades returns trades of the portfolio made after since, oldest first
+func (s *Store) Trades(userID int, portfolio string, since time.Time) ([]Trade, error) {
+	var trades []Trade
+	err := s.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		prefix := []byte(getTradesPrefix(userID, portfolio))
+
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			var t Trade
+			err := it.Item().Value(func(v []byte) error {
+				return json.Unmarshal(v, &t)
+			})
+			if err != nil {
+				return err
+			}
+			if t.Time.After(since) {
+				trades = append(trades, t)
+			}
+		}
+		return nil
+	})
+	return trades, err
+}
+
+// logTrades appends changes of held quantities to the trades log in order of secids
+func logTrades(txn *badger.Txn, userID int, portfolio string, deltas, prices map[string]float64) error {
+	secids := make([]string, 0, len(deltas))
+	for secid := range deltas {
+		secids = append(secids, secid)
+	}
+	sort.Strings(secids)
+
+	seq, err := lastSeq(txn, getTradesPrefix(userID, portfolio))
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, secid := range secids {
+		seq++
+		v, err := json.Marshal(Trade{Seq: seq, Time: now, SecID: secid, Quantity: deltas[secid], Price: prices[secid]})
+		if err != nil {
+			return err
+		}
+		if err := txn.Set([]byte(getTradeKey(userID, portfolio, seq)), v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func getTradesPrefix(userID int, portfolio string) string {
+	return getPortfolioScope(userID, portfolio) + "trades" + keySep
+}
+
+// getTradeKey pads seq with zeros, so that keys are iterated in order of trades
+func getTradeKey(userID int, portfolio string, seq int) string {
+	return getTradesPrefix(userID, portfolio) + fmt.Sprintf("%010d", seq)
+}
@@ -0,0 +1,57 @@
+package store
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStore_trades(t *testing.T) {
+	s, err := New("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	const userID = 1
+	start := time.Now()
+	if err := s.SetHoldings(userID, DefaultPortfolio, map[string]float64{"TQBR:SBER": 10, "TQBR:AFKS": 100}, map[string]float64{"TQBR:SBER": 300}); err != nil {
+		t.Fatal(err)
+	}
+	// unchanged quantity is not a trade
+	if err := s.SetHoldings(userID, DefaultPortfolio, map[string]float64{"TQBR:SBER": 10}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetHoldings(userID, DefaultPortfolio, map[string]float64{"TQBR:SBER": 0}, map[string]float64{"TQBR:SBER": 310}); err != nil {
+		t.Fatal(err)
+	}
+
+	trades, err := s.Trades(userID, DefaultPortfolio, start.Add(-time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Trade{
+		{Seq: 1, SecID: "TQBR:AFKS", Quantity: 100},
+		{Seq: 2, SecID: "TQBR:SBER", Quantity: 10, Price: 300},
+		{Seq: 3, SecID: "TQBR:SBER", Quantity: -10, Price: 310},
+	}
+	if len(trades) != len(want) {
+		t.Fatalf("expected %d trades, got %+v", len(want), trades)
+	}
+	for i, tr := range trades {
+		if tr.Time.Before(start) {
+			t.Errorf("trade %d has time %v before start", i, tr.Time)
+		}
+		tr.Time = time.Time{}
+		if tr != want[i] {
+			t.Errorf("trade %d: got %+v, want %+v", i, tr, want[i])
+		}
+	}
+
+	later, err := s.Trades(userID, DefaultPortfolio, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(later) != 0 {
+		t.Errorf("expected no trades after now, got %+v", later)
+	}
+}